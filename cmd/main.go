@@ -2,60 +2,200 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	_ "test-task1/docs"
+	"test-task1/internal/metrics"
 	handlers "test-task1/internal/service"
 	"test-task1/internal/storage"
 	"test-task1/models"
+	coinbase_api "test-task1/pkg/coinbase-api"
+	kraken_api "test-task1/pkg/kraken-api"
 	"time"
 )
 
 const (
 	configPath = "config.yaml"
+	// defaultAddr is the listen address when server.host is empty.
+	defaultAddr = ":8080"
+	// defaultShutdownTimeout bounds the shutdown when server.shutdown_timeout is unset.
+	defaultShutdownTimeout = 10 * time.Second
 )
 
-func setupRouter(storage *storage.Storage) *gin.Engine {
+// streamPath is the WebSocket route, which is not bounded by the request timeout.
+const streamPath = "/currency/stream"
+
+func setupRouter(storage *storage.Storage, cfg models.Config) *gin.Engine {
 	r := gin.Default()
+	r.Use(handlers.JSONCase(cfg.ServConf.JSONCase))
+	r.Use(handlers.Compress(cfg.ServConf.Compression, cfg.ServConf.CompressionMinSize))
+	handlers.UseJSONFallbacks(r)
 
-	currencyHandler := handlers.NewCurrencyHandler(storage)
+	currencyHandler := handlers.NewCurrencyHandler(storage, cfg)
+	adminHandler := handlers.NewAdminHandler(storage, cfg)
+	healthHandler := handlers.NewHealthHandler(storage)
+	streamHandler := handlers.NewStreamHandler(storage)
+	tickerHandler := handlers.NewTickerHandler(kraken_api.GetTicker)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	if cfg.MetricConf.Prometheus {
+		r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	}
+	r.GET("/ready", healthHandler.Ready)
+	r.GET("/health", healthHandler.Health)
 
-	// API endpoints
-	api := r.Group("/currency")
+	// API endpoints, which require one of server.api_keys when any is set
+	api := r.Group("/currency", handlers.APIKeyAuth(cfg.ServConf.APIKeys))
 	{
 		api.POST("/add", currencyHandler.AddCurrency)
+		api.POST("/add-batch", currencyHandler.AddBatch)
+		api.POST("/add-all", currencyHandler.AddAllCurrencies)
 		api.POST("/remove", currencyHandler.RemoveCurrency)
 		api.POST("/price", currencyHandler.GetPrice)
+		api.POST("/portfolio", currencyHandler.Portfolio)
+		api.POST("/depth", currencyHandler.GetDepth)
+		api.POST("/ticker", tickerHandler.Ticker)
+		api.POST("/twap-decay", currencyHandler.DecayedAverage)
+		api.POST("/range", currencyHandler.GetPriceRange)
+		api.POST("/ohlc", currencyHandler.GetOHLC)
+		api.POST("/compare", currencyHandler.ComparePrices)
+		api.GET("/hot", currencyHandler.HotCoins)
+		api.GET("/stream", handlers.LimitConnections(cfg.ServConf.MaxStreamConnections), streamHandler.Stream)
+	}
+
+	// Admin endpoints rewrite history and drain the instance, so they require a key as well
+	admin := r.Group("/admin", handlers.APIKeyAuth(cfg.ServConf.APIKeys))
+	{
+		admin.POST("/coins/merge", adminHandler.MergeCoins)
+		admin.POST("/backfill", adminHandler.Backfill)
+		admin.GET("/backfill/:id", adminHandler.BackfillStatus)
+		admin.POST("/drain", adminHandler.Drain)
+		admin.GET("/migrations", adminHandler.MigrationStatus)
 	}
 
+	setupAccount(r, cfg)
+
 	return r
 }
 
+// setupAccount exposes the balances of the configured Kraken account. The
+// endpoint is only registered when both the Kraken credentials and the
+// service's own API keys are set, so balances are never served unauthenticated.
+func setupAccount(r *gin.Engine, cfg models.Config) {
+	if cfg.KrakenConf.APIKey == "" {
+		return
+	}
+	if len(cfg.ServConf.APIKeys) == 0 {
+		slog.Warn("Kraken credentials are set but server.api_keys is empty: /account is disabled")
+		return
+	}
+
+	client, err := kraken_api.NewPrivateClient(cfg.KrakenConf.APIKey, cfg.KrakenConf.APISecret)
+	if err != nil {
+		slog.Error("failed to create Kraken account client", "err", err)
+		return
+	}
+	accountHandler := handlers.NewAccountHandler(client)
+
+	account := r.Group("/account", handlers.APIKeyAuth(cfg.ServConf.APIKeys))
+	{
+		account.GET("/balance", accountHandler.Balance)
+	}
+}
+
+// setupStatsD pushes the metrics to StatsD until stop is closed when
+// metrics.statsd_address is set.
+func setupStatsD(cfg models.MetricsCfg, stop <-chan struct{}) {
+	if cfg.StatsDAddress == "" {
+		return
+	}
+	exporter, err := metrics.NewStatsD(cfg.StatsDAddress, cfg.StatsDPrefix, prometheus.DefaultGatherer)
+	if err != nil {
+		slog.Error("failed to set up StatsD export", "err", err)
+		return
+	}
+	interval := cfg.StatsDInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go exporter.Run(interval, stop)
+}
+
+// setupLogger makes a text logger at log.level the default one, which the
+// log package writes through as well.
+func setupLogger(cfg models.LogCfg) {
+	level, err := cfg.SlogLevel()
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+}
+
+// compareSources returns collector.compare_sources with Coinbase added when
+// coinbase.compare is set and it is not the price source already.
+func compareSources(cfg models.Config) []string {
+	sources := cfg.CollConf.CompareSources
+	if !cfg.CoinbaseConf.Compare || slices.Contains(sources, models.PriceSourceCoinbase) ||
+		cfg.CollConf.PriceSource == models.PriceSourceCoinbase || slices.Contains(cfg.CollConf.PriceSources, models.PriceSourceCoinbase) {
+		return sources
+	}
+	return append(slices.Clone(sources), models.PriceSourceCoinbase)
+}
+
 func main() {
 	cfg := models.MustLoad(configPath)
+	setupLogger(cfg.LogConf)
+	kraken_api.Configure(cfg.KrakenConf)
+	coinbase_api.Configure(cfg.CoinbaseConf)
+	cfg.CollConf.CompareSources = compareSources(*cfg)
+	if err := kraken_api.RefreshPairs(context.Background()); errors.Is(err, kraken_api.ErrNoPairs) {
+		log.Fatalf("Invalid kraken.quote: %v", err)
+	} else if err != nil {
+		// Kraken may just be unreachable; pairs are loaded again on first use
+		slog.Warn("failed to load Kraken pairs", "err", err)
+	}
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+	if cfg.KrakenConf.PairsRefresh > 0 {
+		go kraken_api.RefreshPairsEvery(refreshCtx, cfg.KrakenConf.PairsRefresh)
+	}
 
 	db, err := storage.New(*cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
-	defer db.Shutdown()
 
-	r := setupRouter(db)
+	stopStatsD := make(chan struct{})
+	defer close(stopStatsD)
+	setupStatsD(cfg.MetricConf, stopStatsD)
+
+	r := setupRouter(db, *cfg)
+	addr := cfg.ServConf.Host
+	if addr == "" {
+		addr = defaultAddr
+	}
+	// WebSocket streams are not bounded by the timeouts: the upgrade clears
+	// the connection's deadlines.
 	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: r,
+		Addr:         addr,
+		Handler:      handlers.TimeoutHandler(r, cfg.ServConf.RequestTimeout, streamPath),
+		ReadTimeout:  cfg.ServConf.Timeout,
+		WriteTimeout: cfg.ServConf.Timeout,
 	}
 
 	go func() {
-		log.Printf("Server starting on %s", srv.Addr)
+		slog.Info("server starting", "addr", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
@@ -64,14 +204,22 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("shutting down server")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownTimeout := cfg.ServConf.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	// Collectors still running at the deadline are logged by the storage
+	if err := db.ShutdownContext(ctx); err != nil {
+		slog.Error("storage did not shut down in time", "timeout", shutdownTimeout, "err", err)
+	}
 
-	log.Println("Server exited properly")
+	slog.Info("server exited properly")
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+func TestAdminRequiresAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := models.Config{ServConf: models.ServerCfg{APIKeys: []string{"secret"}, JSONCase: models.JSONCaseSnake}}
+	r := setupRouter(&storage.Storage{}, cfg)
+
+	for _, path := range []string{"/admin/coins/merge", "/admin/drain", "/admin/backfill"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+	}
+}
+
+func TestCompareSources(t *testing.T) {
+	cfg := models.Config{CollConf: models.CollectorCfg{PriceSource: models.PriceSourceKraken}}
+	assert.Empty(t, compareSources(cfg))
+
+	cfg.CoinbaseConf.Compare = true
+	assert.Equal(t, []string{models.PriceSourceCoinbase}, compareSources(cfg))
+
+	// Listed already or collected as the price source
+	cfg.CollConf.CompareSources = []string{models.PriceSourceCoinbase}
+	assert.Equal(t, []string{models.PriceSourceCoinbase}, compareSources(cfg))
+	cfg.CollConf = models.CollectorCfg{PriceSource: models.PriceSourceCoinbase}
+	assert.Empty(t, compareSources(cfg))
+}
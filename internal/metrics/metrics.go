@@ -0,0 +1,128 @@
+// Package metrics holds the Prometheus collectors exported by the service.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// BreakerOpen is 1 while the price-source circuit breaker is open.
+	BreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "price_source_breaker_open",
+		Help: "1 while the price source circuit breaker is open and fetches are skipped.",
+	})
+
+	// ActiveCollectors is the number of running price collector loops: 1 once
+	// a coin was tracked, until shutdown.
+	ActiveCollectors = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_active",
+		Help: "Number of running price collectors.",
+	})
+
+	// ActiveCoins is the number of coins whose prices are being collected.
+	ActiveCoins = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "coins_active",
+		Help: "Number of tracked coins.",
+	})
+
+	// SourceRequests counts the price requests of collectors to the price
+	// source (Kraken by default); SourceRequestFailures counts those that failed.
+	SourceRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "price_source_requests_total",
+		Help: "Price requests of collectors to the price source.",
+	})
+	SourceRequestFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "price_source_request_failures_total",
+		Help: "Failed price requests of collectors to the price source.",
+	})
+
+	// PriceLookups counts answered price queries by where the price came
+	// from: memory, cache or db.
+	PriceLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "price_lookups_total",
+		Help: "Answered price queries by the store that answered them.",
+	}, []string{"source"})
+
+	// CollectorGoroutines is the number of collections running on collector
+	// workers plus the depth collectors of tracked coins; it returns to its
+	// previous value once the coins are removed.
+	CollectorGoroutines = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_goroutines",
+		Help: "Number of running price collections and depth collectors.",
+	})
+
+	// CollectorLag is how late each coin's last collection started relative to its schedule.
+	CollectorLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_lag_seconds",
+		Help: "Delay between the scheduled and the actual start of the last price collection.",
+	}, []string{"coin"})
+
+	// CollectorPaused is 1 while collection is paused outside the collector schedule.
+	CollectorPaused = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "collector_paused",
+		Help: "1 while price collection is paused outside the configured schedule.",
+	})
+
+	// CollectorPanics counts price collections that panicked, by coin.
+	CollectorPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_panics_total",
+		Help: "Price collections that panicked and were recovered.",
+	}, []string{"coin"})
+
+	// StreamConnections is the number of open streaming connections.
+	StreamConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "stream_connections",
+		Help: "Number of open streaming (WebSocket) connections.",
+	})
+
+	// CacheHitsWithoutDB counts cache hits whose point was never persisted to Postgres.
+	CacheHitsWithoutDB = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cache_hits_without_db_total",
+		Help: "Cache hits with no corresponding row in the database.",
+	})
+
+	// BreakerTrips counts how many times the circuit breaker has opened.
+	BreakerTrips = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "price_source_breaker_trips_total",
+		Help: "Number of times the price source circuit breaker has opened.",
+	})
+
+	// CacheWriteFailures counts failed commands of cache update pipelines by command.
+	CacheWriteFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_write_failures_total",
+		Help: "Failed Redis commands while updating the price cache.",
+	}, []string{"command"})
+
+	// CacheMalformedMembers counts cache members skipped because they could not be parsed.
+	CacheMalformedMembers = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cache_malformed_members_total",
+		Help: "Cached price members skipped because they are not timestamp:price.",
+	})
+
+	// DBQueryDuration is the latency of database queries by query type.
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Latency of database queries.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms to ~4s
+	}, []string{"query"})
+)
+
+// Query types of DBQueryDuration.
+const (
+	QueryNearest = "nearest"
+	QueryRange   = "range"
+	QueryInsert  = "insert"
+	QueryExists  = "exists"
+	QueryDepth   = "depth"
+
+	QueryNeighbors = "neighbors"
+	QueryEarliest  = "earliest"
+	QueryOHLC      = "ohlc"
+)
+
+// TimeDBQuery starts timing a query of the given type; call ObserveDuration
+// on the result once the query has finished.
+func TimeDBQuery(query string) *prometheus.Timer {
+	return prometheus.NewTimer(DBQueryDuration.WithLabelValues(query))
+}
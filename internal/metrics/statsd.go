@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxPacketSize keeps StatsD datagrams below a typical Ethernet MTU.
+const maxPacketSize = 1432
+
+var unsafeStatsDChars = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+
+// StatsD pushes the collectors of a Prometheus registry to a StatsD server
+// over UDP. Label values are appended to the metric name as path segments,
+// e.g. collector_lag_seconds{coin="BTC"} becomes crypto.collector_lag_seconds.BTC.
+// Gauges are sent as gauges and counters as the increase since the previous
+// flush; histograms and summaries as their count (a counter) and sum (a gauge).
+type StatsD struct {
+	conn     net.Conn
+	prefix   string
+	gatherer prometheus.Gatherer
+	last     map[string]float64 // counter values of the previous flush
+}
+
+// NewStatsD returns an exporter of gatherer's metrics to the StatsD server at addr.
+func NewStatsD(addr, prefix string, gatherer prometheus.Gatherer) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+	return &StatsD{
+		conn:     conn,
+		prefix:   prefix,
+		gatherer: gatherer,
+		last:     make(map[string]float64),
+	}, nil
+}
+
+// Run flushes every interval until stop is closed, then flushes a last time
+// and closes the connection.
+func (s *StatsD) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer s.conn.Close()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				slog.Error("StatsD flush failed", "err", err)
+			}
+		case <-stop:
+			if err := s.Flush(); err != nil {
+				slog.Error("StatsD flush failed", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// Flush sends the current value of every metric.
+func (s *StatsD) Flush() error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("statsd: %v", err)
+	}
+
+	var lines []string
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			name := s.metricName(family.GetName(), m)
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = s.appendCounter(lines, name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				lines = appendGauge(lines, name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				lines = appendGauge(lines, name, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				lines = s.appendCounter(lines, name+".count", float64(h.GetSampleCount()))
+				lines = appendGauge(lines, name+".sum", h.GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				sm := m.GetSummary()
+				lines = s.appendCounter(lines, name+".count", float64(sm.GetSampleCount()))
+				lines = appendGauge(lines, name+".sum", sm.GetSampleSum())
+			}
+		}
+	}
+	return s.send(lines)
+}
+
+// metricName builds the StatsD name of m from the family name and its label values.
+func (s *StatsD) metricName(family string, m *dto.Metric) string {
+	parts := []string{s.prefix + family}
+	for _, label := range m.GetLabel() {
+		parts = append(parts, unsafeStatsDChars.ReplaceAllString(label.GetValue(), "_"))
+	}
+	return strings.Join(parts, ".")
+}
+
+// appendCounter adds the increase of a counter since the previous flush. A
+// counter that went down was reset (e.g. a deleted label set), so its whole
+// value is the increase.
+func (s *StatsD) appendCounter(lines []string, name string, value float64) []string {
+	delta := value - s.last[name]
+	if delta < 0 {
+		delta = value
+	}
+	s.last[name] = value
+	if delta == 0 {
+		return lines
+	}
+	return append(lines, fmt.Sprintf("%s:%g|c", name, delta))
+}
+
+func appendGauge(lines []string, name string, value float64) []string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return lines
+	}
+	return append(lines, fmt.Sprintf("%s:%g|g", name, value))
+}
+
+// send writes lines in as few datagrams as fit maxPacketSize.
+func (s *StatsD) send(lines []string) error {
+	var packet strings.Builder
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := s.conn.Write([]byte(packet.String()))
+		packet.Reset()
+		return err
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			if err := flush(); err != nil {
+				return fmt.Errorf("statsd: %v", err)
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("statsd: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package metrics_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/metrics"
+)
+
+// readPacket returns the lines of the next datagram received by conn.
+func readPacket(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	reg := prometheus.NewRegistry()
+	hits := prometheus.NewCounter(prometheus.CounterOpts{Name: "cache_hits_total"})
+	lag := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "collector_lag_seconds"}, []string{"coin"})
+	fetch := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "fetch_duration_seconds"})
+	reg.MustRegister(hits, lag, fetch)
+
+	hits.Add(3)
+	lag.WithLabelValues("BTC").Set(0.25)
+	fetch.Observe(0.5)
+	fetch.Observe(1)
+
+	exporter, err := metrics.NewStatsD(conn.LocalAddr().String(), "crypto.", reg)
+	require.NoError(t, err)
+
+	require.NoError(t, exporter.Flush())
+	assert.ElementsMatch(t, []string{
+		"crypto.cache_hits_total:3|c",
+		"crypto.collector_lag_seconds.BTC:0.25|g",
+		"crypto.fetch_duration_seconds.count:2|c",
+		"crypto.fetch_duration_seconds.sum:1.5|g",
+	}, readPacket(t, conn))
+
+	// Counters are sent as the increase since the previous flush
+	hits.Inc()
+	require.NoError(t, exporter.Flush())
+	assert.ElementsMatch(t, []string{
+		"crypto.cache_hits_total:1|c",
+		"crypto.collector_lag_seconds.BTC:0.25|g",
+		"crypto.fetch_duration_seconds.sum:1.5|g",
+	}, readPacket(t, conn))
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+)
+
+// BalanceSource returns the balances of the configured exchange account.
+type BalanceSource interface {
+	Balance(ctx context.Context) (map[string]float64, error)
+}
+
+type AccountHandler struct {
+	account BalanceSource
+}
+
+func NewAccountHandler(account BalanceSource) *AccountHandler {
+	return &AccountHandler{account: account}
+}
+
+// Balance godoc
+// @Summary Get account balances
+// @Description Returns the balance of every asset of the configured Kraken account
+// @Tags account
+// @Produce json
+// @Param X-API-Key header string true "Service API key"
+// @Success 200 {object} models.BalanceResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Router /account/balance [get]
+func (h *AccountHandler) Balance(c *gin.Context) {
+	balances, err := h.account.Balance(c.Request.Context())
+	if err != nil {
+		respond(c, http.StatusBadGateway, models.ErrorResponse{Error: "failed to fetch balance"})
+		return
+	}
+	respond(c, http.StatusOK, models.BalanceResponse{Balances: balances})
+}
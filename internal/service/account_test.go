@@ -0,0 +1,78 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	handlers "test-task1/internal/service"
+)
+
+type fakeAccount struct {
+	balances map[string]float64
+	err      error
+}
+
+func (f fakeAccount) Balance(context.Context) (map[string]float64, error) {
+	return f.balances, f.err
+}
+
+func newAccountRouter(account handlers.BalanceSource) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/account/balance", handlers.APIKeyAuth([]string{"secret-1", "secret-2"}), handlers.NewAccountHandler(account).Balance)
+	return r
+}
+
+func TestAccountBalance(t *testing.T) {
+	r := newAccountRouter(fakeAccount{balances: map[string]float64{"BTC": 0.5, "USD": 1200.25}})
+
+	tests := []struct {
+		name string
+		key  string
+		code int
+	}{
+		{"missing key", "", http.StatusUnauthorized},
+		{"wrong key", "guess", http.StatusUnauthorized},
+		{"first key", "secret-1", http.StatusOK},
+		{"second key", "secret-2", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/account/balance", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == http.StatusOK {
+				assert.JSONEq(t, `{"balances":{"BTC":0.5,"USD":1200.25}}`, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("exchange error", func(t *testing.T) {
+		r := newAccountRouter(fakeAccount{err: errors.New("EAPI:Invalid key")})
+		req := httptest.NewRequest(http.MethodGet, "/account/balance", nil)
+		req.Header.Set("X-API-Key", "secret-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+}
+
+func TestAPIKeyAuthDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/open", handlers.APIKeyAuth(nil), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/open", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
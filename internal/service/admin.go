@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+)
+
+// AdminStore is the storage used by the maintenance endpoints.
+type AdminStore interface {
+	MergeCoins(oldCoin, newCoin string) (int64, error)
+	StartBackfill(coins []string, since int64) models.BackfillJob
+	BackfillStatus(id string) (models.BackfillJob, bool)
+	Drain()
+	MigrationStatus() (uint, bool, error)
+}
+
+type AdminHandler struct {
+	storage AdminStore
+	cfg     models.Config
+}
+
+func NewAdminHandler(storage AdminStore, cfg models.Config) *AdminHandler {
+	return &AdminHandler{storage: storage, cfg: cfg}
+}
+
+// MergeCoins godoc
+// @Summary Merge the history of a renamed coin
+// @Description Re-labels all stored rows of the old symbol with the new one and keeps the old symbol as an alias
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param input body models.MergeCoinsRequest true "Old and new symbol"
+// @Success 200 {object} models.MergeCoinsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/coins/merge [post]
+func (h *AdminHandler) MergeCoins(c *gin.Context) {
+	var req models.MergeCoinsRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	from, err := normalizeCoin(req.From)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "from: " + err.Error()})
+		return
+	}
+	to, err := normalizeCoin(req.To)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "to: " + err.Error()})
+		return
+	}
+	if from == to {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "from and to must differ"})
+		return
+	}
+
+	rows, err := h.storage.MergeCoins(from, to)
+	if err != nil {
+		respond(c, http.StatusInternalServerError, models.ErrorResponse{Error: "merge failed"})
+		return
+	}
+
+	respond(c, http.StatusOK, models.MergeCoinsResponse{From: from, To: to, Rows: rows})
+}
+
+// Backfill godoc
+// @Summary Backfill the history of many coins
+// @Description Starts a background job loading one-minute candles from Kraken for every coin.
+// @Description Coins are fetched with bounded concurrency; Kraken serves at most the last 720 candles per coin.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param input body models.BackfillRequest true "Coins and optional start timestamp"
+// @Success 202 {object} models.BackfillJob
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Router /admin/backfill [post]
+func (h *AdminHandler) Backfill(c *gin.Context) {
+	var req models.BackfillRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	coins := make([]string, 0, len(req.Coins))
+	seen := make(map[string]bool, len(req.Coins))
+	for _, symbol := range req.Coins {
+		coin, err := normalizeSymbol(symbol)
+		if err != nil {
+			respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if seen[coin] {
+			continue
+		}
+		seen[coin] = true
+		coins = append(coins, coin)
+	}
+
+	var since int64
+	if req.Since != nil {
+		if err := h.cfg.CollConf.CheckTimestamp(*req.Since); err != nil {
+			respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		since = *req.Since
+	}
+
+	respond(c, http.StatusAccepted, h.storage.StartBackfill(coins, since))
+}
+
+// BackfillStatus godoc
+// @Summary Get backfill job progress
+// @Description Returns the state of the job and of each of its coins
+// @Tags admin
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.BackfillJob
+// @Failure 404 {object} models.ErrorResponse
+// @Router /admin/backfill/{id} [get]
+func (h *AdminHandler) BackfillStatus(c *gin.Context) {
+	job, ok := h.storage.BackfillStatus(c.Param("id"))
+	if !ok {
+		respond(c, http.StatusNotFound, models.ErrorResponse{Error: "job not found"})
+		return
+	}
+	respond(c, http.StatusOK, job)
+}
+
+// Drain godoc
+// @Summary Drain the instance before shutdown
+// @Description Makes /ready return 503 so load balancers stop routing new traffic, while requests keep being served.
+// @Description Send SIGTERM once the instance no longer receives traffic.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.StatusResponse
+// @Router /admin/drain [post]
+func (h *AdminHandler) Drain(c *gin.Context) {
+	h.storage.Drain()
+	respond(c, http.StatusOK, models.StatusResponse{Status: "draining"})
+}
+
+// MigrationStatus godoc
+// @Summary Get the database schema version
+// @Description Returns the applied migration version and whether it is dirty, i.e. failed half-way.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.MigrationStatusResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/migrations [get]
+func (h *AdminHandler) MigrationStatus(c *gin.Context) {
+	version, dirty, err := h.storage.MigrationStatus()
+	if err != nil {
+		respond(c, http.StatusInternalServerError, models.ErrorResponse{Error: "failed to read migration status"})
+		return
+	}
+	respond(c, http.StatusOK, models.MigrationStatusResponse{Version: version, Dirty: dirty})
+}
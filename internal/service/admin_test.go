@@ -0,0 +1,95 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	handlers "test-task1/internal/service"
+	"test-task1/models"
+)
+
+// fakeAdmin is an in-memory AdminStore used to drive the admin handlers.
+type fakeAdmin struct {
+	started []string
+	since   int64
+}
+
+func (f *fakeAdmin) Drain() {}
+
+func (f *fakeAdmin) MigrationStatus() (uint, bool, error) { return 4, true, nil }
+
+func (f *fakeAdmin) MergeCoins(oldCoin, newCoin string) (int64, error) { return 0, nil }
+
+func (f *fakeAdmin) StartBackfill(coins []string, since int64) models.BackfillJob {
+	f.started, f.since = coins, since
+	return models.BackfillJob{ID: "job1", Status: models.BackfillRunning, Since: since}
+}
+
+func (f *fakeAdmin) BackfillStatus(id string) (models.BackfillJob, bool) {
+	if id != "job1" {
+		return models.BackfillJob{}, false
+	}
+	return models.BackfillJob{ID: id, Status: models.BackfillDone, Coins: []models.BackfillCoin{
+		{Coin: "BTC", Status: models.BackfillDone, Rows: 720},
+	}}, true
+}
+
+func newAdminRouter(s handlers.AdminStore) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := handlers.NewAdminHandler(s, models.Config{})
+	r.POST("/admin/backfill", h.Backfill)
+	r.GET("/admin/backfill/:id", h.BackfillStatus)
+	r.GET("/admin/migrations", h.MigrationStatus)
+	return r
+}
+
+func TestBackfill(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"valid", `{"coins":["BTC","ETH"],"since":1736500490}`, http.StatusAccepted},
+		{"no since", `{"coins":["BTC"]}`, http.StatusAccepted},
+		{"no coins", `{"coins":[]}`, http.StatusBadRequest},
+		{"empty coin", `{"coins":["BTC",""]}`, http.StatusBadRequest},
+		{"invalid coin", `{"coins":["BTC;ETH"]}`, http.StatusBadRequest},
+		{"milliseconds in seconds mode", `{"coins":["BTC"],"since":1736500490123}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doJSON(newAdminRouter(&fakeAdmin{}), http.MethodPost, "/admin/backfill", tt.body)
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestBackfillNormalizesCoins(t *testing.T) {
+	s := &fakeAdmin{}
+	w := doJSON(newAdminRouter(s), http.MethodPost, "/admin/backfill", `{"coins":["btc"," eth ","BTC"]}`)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, []string{"BTC", "ETH"}, s.started)
+}
+
+func TestBackfillStatus(t *testing.T) {
+	r := newAdminRouter(&fakeAdmin{})
+
+	w := doJSON(r, http.MethodGet, "/admin/backfill/job1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":"job1","status":"done","since":0,"coins":[{"coin":"BTC","status":"done","rows":720}]}`, w.Body.String())
+
+	w = doJSON(r, http.MethodGet, "/admin/backfill/other", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestMigrationStatus(t *testing.T) {
+	r := newAdminRouter(&fakeAdmin{})
+	w := doJSON(r, http.MethodGet, "/admin/migrations", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"version":4,"dirty":true}`, w.Body.String())
+}
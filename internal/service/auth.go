@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+)
+
+// apiKeyHeader carries the client's key on protected endpoints.
+const apiKeyHeader = "X-API-Key"
+
+// APIKeyAuth rejects requests whose X-API-Key header doesn't match one of
+// the keys with 401. With no keys configured every request is let through.
+func APIKeyAuth(keys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(keys) == 0 {
+			c.Next()
+			return
+		}
+
+		got := []byte(c.GetHeader(apiKeyHeader))
+		for _, key := range keys {
+			if subtle.ConstantTimeCompare(got, []byte(key)) == 1 {
+				c.Next()
+				return
+			}
+		}
+		respond(c, http.StatusUnauthorized, models.ErrorResponse{Error: "invalid API key"})
+		c.Abort()
+	}
+}
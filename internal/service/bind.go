@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+)
+
+// bindJSON decodes the request body into obj and writes an error response
+// if it can't. Bodies sent with a non-JSON Content-Type are rejected with
+// 415; empty bodies, malformed JSON and failed validation get distinct 400
+// messages. A missing Content-Type is accepted as JSON.
+// Returns false if a response has been written.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if ct := c.GetHeader("Content-Type"); ct != "" {
+		if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != "application/json" {
+			respond(c, http.StatusUnsupportedMediaType, models.ErrorResponse{Error: "Content-Type must be application/json"})
+			return false
+		}
+	}
+
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.Is(err, io.EOF):
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "empty body"})
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "malformed JSON"})
+	default:
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "invalid request"})
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindJSON(t *testing.T) {
+	r := newTestRouter(&fakeStorage{price: 50000})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+		error       string
+	}{
+		{"form-encoded", "application/x-www-form-urlencoded", "coin=BTC", http.StatusUnsupportedMediaType, "Content-Type must be application/json"},
+		{"plain text", "text/plain", `{"coin":"BTC"}`, http.StatusUnsupportedMediaType, "Content-Type must be application/json"},
+		{"empty body", "application/json", "", http.StatusBadRequest, "empty body"},
+		{"malformed JSON", "application/json", `{"coin":`, http.StatusBadRequest, "malformed JSON"},
+		{"invalid syntax", "application/json", `{coin: BTC}`, http.StatusBadRequest, "malformed JSON"},
+		{"wrong type", "application/json", `{"coin":42}`, http.StatusBadRequest, "malformed JSON"},
+		{"missing field", "application/json", `{}`, http.StatusBadRequest, "invalid request"},
+		{"charset parameter", "application/json; charset=utf-8", `{"coin":"BTC","timestamp":1736500490}`, http.StatusOK, ""},
+		{"no content type", "", `{"coin":"BTC","timestamp":1736500490}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/currency/price", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+			if tt.error != "" {
+				assert.JSONEq(t, `{"error":"`+tt.error+`"}`, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+)
+
+// Compress encodes response bodies of at least minSize bytes with the best of
+// the enabled algorithms (models.EncodingBrotli, models.EncodingGzip) the
+// client accepts. Clients' Accept-Encoding q-values decide first; on a tie
+// the order of algorithms does. An empty algorithms list disables compression.
+// Responses are buffered to learn their size; a handler that flushes streams
+// the rest of its response uncompressed.
+func Compress(algorithms []string, minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(algorithms) == 0 {
+			c.Next()
+			return
+		}
+		c.Header("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), algorithms)
+		if encoding == "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if w.streaming {
+			return
+		}
+		body := w.buf.Bytes()
+		if len(body) < minSize || w.ResponseWriter.Written() || w.Header().Get("Content-Encoding") != "" {
+			w.ResponseWriter.Write(body)
+			return
+		}
+
+		var out bytes.Buffer
+		if err := encode(&out, encoding, body); err != nil {
+			w.ResponseWriter.Write(body)
+			return
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Content-Length", strconv.Itoa(out.Len()))
+		w.ResponseWriter.Write(out.Bytes())
+	}
+}
+
+// negotiateEncoding picks the enabled algorithm with the highest q-value in
+// the Accept-Encoding header, or "" when the client accepts none of them.
+func negotiateEncoding(header string, algorithms []string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		accepted[strings.ToLower(name)] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range algorithms {
+		q, ok := accepted[enc]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// encode writes body compressed with the given algorithm to w.
+func encode(w io.Writer, encoding string, body []byte) error {
+	var enc io.WriteCloser
+	switch encoding {
+	case models.EncodingBrotli:
+		enc = brotli.NewWriter(w)
+	case models.EncodingGzip:
+		enc = gzip.NewWriter(w)
+	default:
+		_, err := w.Write(body)
+		return err
+	}
+	if _, err := enc.Write(body); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// compressWriter buffers the response body until Compress decides whether to
+// encode it.
+type compressWriter struct {
+	gin.ResponseWriter
+	buf       bytes.Buffer
+	streaming bool
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what is buffered so far uncompressed and stops buffering.
+func (w *compressWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Hijack hands the connection over to the handler, e.g. for a WebSocket
+// upgrade; nothing is buffered or written to it afterwards.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.streaming = true
+	return w.ResponseWriter.Hijack()
+}
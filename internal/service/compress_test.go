@@ -0,0 +1,87 @@
+package handlers_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	handlers "test-task1/internal/service"
+	"test-task1/models"
+)
+
+func newCompressRouter(algorithms []string, body string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(handlers.Compress(algorithms, 100))
+	r.GET("/data", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", []byte(body))
+	})
+	return r
+}
+
+func getEncoded(r http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/data", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decode returns the response body decoded according to its Content-Encoding.
+func decode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var rd io.Reader = w.Body
+	switch w.Header().Get("Content-Encoding") {
+	case models.EncodingGzip:
+		gz, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		rd = gz
+	case models.EncodingBrotli:
+		rd = brotli.NewReader(w.Body)
+	}
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCompress(t *testing.T) {
+	large := `{"prices":[` + strings.Repeat(`48523.42,`, 100) + `1]}`
+	all := []string{models.EncodingBrotli, models.EncodingGzip}
+
+	tests := []struct {
+		name           string
+		algorithms     []string
+		body           string
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip", all, large, "gzip", "gzip"},
+		{"brotli", all, large, "br", "br"},
+		{"both prefers configured order", all, large, "gzip, deflate, br", "br"},
+		{"q-value wins over order", all, large, "br;q=0.5, gzip", "gzip"},
+		{"rejected with q=0", all, large, "br;q=0, gzip;q=0", ""},
+		{"wildcard", all, large, "*", "br"},
+		{"neither", all, large, "", ""},
+		{"unsupported only", all, large, "deflate", ""},
+		{"below threshold", all, `{"price":1}`, "gzip, br", ""},
+		{"algorithm disabled", []string{models.EncodingGzip}, large, "br", ""},
+		{"compression disabled", nil, large, "gzip, br", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := getEncoded(newCompressRouter(tt.algorithms, tt.body), tt.acceptEncoding)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.want, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, tt.body, decode(t, w))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+	"test-task1/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+)
+
+// LimitConnections bounds how many requests of the routes it guards are
+// served at once, answering 503 beyond max. It is meant for long-lived
+// streaming routes such as WebSocket upgrades, whose handlers only return
+// when the client disconnects, freeing the slot. A non-positive max
+// disables the limit.
+func LimitConnections(max int) gin.HandlerFunc {
+	var open atomic.Int64
+	return func(c *gin.Context) {
+		if max <= 0 {
+			c.Next()
+			return
+		}
+
+		if open.Add(1) > int64(max) {
+			open.Add(-1)
+			respond(c, http.StatusServiceUnavailable, models.ErrorResponse{Error: "too many streaming connections"})
+			c.Abort()
+			return
+		}
+		metrics.StreamConnections.Inc()
+		defer func() {
+			open.Add(-1)
+			metrics.StreamConnections.Dec()
+		}()
+
+		c.Next()
+	}
+}
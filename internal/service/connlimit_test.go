@@ -0,0 +1,67 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	handlers "test-task1/internal/service"
+)
+
+func TestLimitConnections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	connected := make(chan struct{})
+	disconnect := make(chan struct{})
+	r := gin.New()
+	// Holds the connection open like a WebSocket until the test disconnects it
+	r.GET("/stream", handlers.LimitConnections(2), func(c *gin.Context) {
+		connected <- struct{}{}
+		<-disconnect
+		c.Status(http.StatusOK)
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	done := make(chan int, 3)
+	connect := func() {
+		go func() {
+			resp, err := http.Get(srv.URL + "/stream")
+			if err != nil {
+				done <- 0
+				return
+			}
+			resp.Body.Close()
+			done <- resp.StatusCode
+		}()
+	}
+
+	connect()
+	connect()
+	<-connected
+	<-connected
+
+	// Past the limit
+	resp, err := http.Get(srv.URL + "/stream")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// A disconnect frees a slot
+	disconnect <- struct{}{}
+	assert.Equal(t, http.StatusOK, <-done)
+
+	connect()
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not accepted after a disconnect")
+	}
+
+	close(disconnect)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, <-done)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+)
+
+// HealthStore reports whether the instance should receive traffic, whether
+// it is still starting, and the status of its dependencies.
+type HealthStore interface {
+	Ready() bool
+	Starting() bool
+	HealthCheck(ctx context.Context) map[string]string
+}
+
+type HealthHandler struct {
+	storage HealthStore
+}
+
+func NewHealthHandler(storage HealthStore) *HealthHandler {
+	return &HealthHandler{storage: storage}
+}
+
+// Ready godoc
+// @Summary Readiness probe
+// @Description Returns 503 while the instance is starting, i.e. the Kraken pairs are not loaded yet, and once it has been drained
+// @Tags health
+// @Produce json
+// @Success 200 {object} models.StatusResponse
+// @Failure 503 {object} models.StatusResponse
+// @Router /ready [get]
+func (h *HealthHandler) Ready(c *gin.Context) {
+	if h.storage.Starting() {
+		respond(c, http.StatusServiceUnavailable, models.StatusResponse{Status: "starting"})
+		return
+	}
+	if !h.storage.Ready() {
+		respond(c, http.StatusServiceUnavailable, models.StatusResponse{Status: "draining"})
+		return
+	}
+	respond(c, http.StatusOK, models.StatusResponse{Status: "ready"})
+}
+
+// Health godoc
+// @Summary Dependency health check
+// @Description Pings PostgreSQL and Redis and returns "ok" or the error of each. Returns 503 unless all are healthy.
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /health [get]
+func (h *HealthHandler) Health(c *gin.Context) {
+	health := h.storage.HealthCheck(c.Request.Context())
+	code := http.StatusOK
+	for _, status := range health {
+		if status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+	}
+	respond(c, code, health)
+}
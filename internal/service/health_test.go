@@ -0,0 +1,80 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	handlers "test-task1/internal/service"
+	"test-task1/models"
+)
+
+// drainableStorage serves prices and admin requests and can be drained.
+type drainableStorage struct {
+	fakeStorage
+	fakeAdmin
+	draining bool
+	starting bool
+	health   map[string]string
+}
+
+func (d *drainableStorage) Drain()         { d.draining = true }
+func (d *drainableStorage) Ready() bool    { return !d.starting && !d.draining }
+func (d *drainableStorage) Starting() bool { return d.starting }
+
+func (d *drainableStorage) HealthCheck(context.Context) map[string]string { return d.health }
+
+func TestDrain(t *testing.T) {
+	s := &drainableStorage{fakeStorage: fakeStorage{price: 50000}}
+
+	r := newTestRouter(s)
+	r.GET("/ready", handlers.NewHealthHandler(s).Ready)
+	r.POST("/admin/drain", handlers.NewAdminHandler(s, models.Config{}).Drain)
+
+	w := doJSON(r, http.MethodGet, "/ready", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ready"}`, w.Body.String())
+
+	w = doJSON(r, http.MethodPost, "/admin/drain", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doJSON(r, http.MethodGet, "/ready", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status":"draining"}`, w.Body.String())
+
+	// Requests are still served while draining
+	w = doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestReadyStarting(t *testing.T) {
+	s := &drainableStorage{starting: true}
+
+	r := newTestRouter(s)
+	r.GET("/ready", handlers.NewHealthHandler(s).Ready)
+
+	w := doJSON(r, http.MethodGet, "/ready", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status":"starting"}`, w.Body.String())
+
+	s.starting = false
+	w = doJSON(r, http.MethodGet, "/ready", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHealth(t *testing.T) {
+	s := &drainableStorage{health: map[string]string{"postgres": "ok", "redis": "ok"}}
+
+	r := newTestRouter(s)
+	r.GET("/health", handlers.NewHealthHandler(s).Health)
+
+	w := doJSON(r, http.MethodGet, "/health", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"postgres":"ok","redis":"ok"}`, w.Body.String())
+
+	s.health["redis"] = "connection refused"
+	w = doJSON(r, http.MethodGet, "/health", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"postgres":"ok","redis":"connection refused"}`, w.Body.String())
+}
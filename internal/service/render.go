@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+)
+
+// jsonCaseKey is the gin context key holding the configured JSON field casing.
+const jsonCaseKey = "json_case"
+
+// JSONCase makes respond emit field names in the given casing
+// (models.JSONCaseSnake or models.JSONCaseCamel).
+func JSONCase(style string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(jsonCaseKey, style)
+		c.Next()
+	}
+}
+
+// respond writes obj as JSON. Models are tagged in snake_case; when camelCase
+// is configured the field names are converted before writing.
+func respond(c *gin.Context, code int, obj interface{}) {
+	v, err := casedValue(c, obj)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to encode response"})
+		return
+	}
+	c.JSON(code, v)
+}
+
+// casedValue returns obj as respond encodes it: unchanged for snake_case,
+// otherwise decoded with its field names converted to camelCase.
+func casedValue(c *gin.Context, obj interface{}) (interface{}, error) {
+	if c.GetString(jsonCaseKey) != models.JSONCaseCamel {
+		return obj, nil
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep numbers as json.Number so large integers survive the round trip
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return camelizeKeys(v), nil
+}
+
+// camelizeKeys recursively converts the object keys of a decoded JSON value to camelCase.
+func camelizeKeys(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[snakeToCamel(k)] = camelizeKeys(item)
+		}
+		return out
+	case []interface{}:
+		for i, item := range val {
+			val[i] = camelizeKeys(item)
+		}
+		return val
+	}
+	return v
+}
+
+// snakeToCamel converts "matched_timestamp" to "matchedTimestamp".
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// UseJSONFallbacks makes unknown paths answer 404 and known paths requested
+// with the wrong method answer 405, both with an ErrorResponse body instead
+// of gin's plain-text defaults.
+func UseJSONFallbacks(r *gin.Engine) {
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(func(c *gin.Context) {
+		respond(c, http.StatusNotFound, models.ErrorResponse{Error: "route not found"})
+	})
+	r.NoMethod(func(c *gin.Context) {
+		respond(c, http.StatusMethodNotAllowed, models.ErrorResponse{Error: "method not allowed"})
+	})
+}
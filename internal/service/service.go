@@ -1,7 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strings"
+	"test-task1/internal/storage"
 	kraken_api "test-task1/pkg/kraken-api"
 	"time"
 
@@ -10,107 +18,1022 @@ import (
 )
 
 type CryptoServer interface {
-	AddCurrency(coin string)
-	RemoveCurrency(coin string)
-	GetPrice(coin string, timestamp int64) (float64, error)
+	AddCurrency(coin string) error
+	Tracked(coin string) bool
+	RemoveCurrency(coin string) (bool, error)
+	GetPriceWith(ctx context.Context, coin string, timestamp int64, match storage.MatchStrategy) (models.PricePoint, string, error)
+	GetPriceWithin(ctx context.Context, coin string, timestamp int64, match storage.MatchStrategy, maxGap time.Duration) (models.PricePoint, string, error)
+	AddDepth(coin string)
+	GetDepth(coin string, timestamp int64) (models.OrderBook, int64, error)
+	GetDecayedAverage(coin string, from, to, halfLife int64) (float64, int, error)
+	GetPriceRange(coin string, from, to int64, limit, offset int) ([]models.PricePoint, bool, error)
+	GetOHLC(coin string, from, to, interval int64) ([]models.Candle, error)
+	LastUpdate(coin string) (time.Time, bool)
+	CheckEarliest(ctx context.Context, coin string, timestamp int64) (int64, error)
+	ComparePrices(coin string, timestamp int64) ([]models.ExchangePrice, []string, error)
+	HotCoins(limit int) ([]models.HotCoin, error)
 }
 
+// maxAddAll caps how many coins one add-all request starts tracking, matching
+// the number of coins the Redis cache keeps.
+const maxAddAll = 100
+
+// defaultHotCoins is how many coins /currency/hot returns without a limit.
+const defaultHotCoins = 10
+
+// defaultMaxCandles caps /currency/ohlc when query.max_range_points is unset,
+// like the storage caps /currency/range.
+const defaultMaxCandles = 1000
+
+const (
+	defaultDecayHalfLife  = 10 * time.Minute
+	defaultMaxDecayWindow = 24 * time.Hour
+)
+
+// maxCoinLength caps the length of a requested coin symbol.
+const maxCoinLength = 16
+
+// priceSourceHeader reports where the returned price came from (cache or db).
+const priceSourceHeader = "X-Price-Source"
+
 type CurrencyHandler struct {
 	storage CryptoServer
+	cfg     models.Config
+}
+
+func NewCurrencyHandler(storage CryptoServer, cfg models.Config) *CurrencyHandler {
+	return &CurrencyHandler{storage: storage, cfg: cfg}
+}
+
+// quote returns the configured quote currency prices are denominated in.
+func (h *CurrencyHandler) quote() string {
+	if h.cfg.KrakenConf.Quote == "" {
+		return kraken_api.DefaultQuote
+	}
+	return strings.ToUpper(h.cfg.KrakenConf.Quote)
+}
+
+// resolveQuote returns the requested quote currency, or the configured one
+// when it is omitted.
+func (h *CurrencyHandler) resolveQuote(quote string) string {
+	if quote == "" {
+		return h.quote()
+	}
+	return strings.ToUpper(quote)
+}
+
+// normalizeCoin uppercases a requested coin symbol and strips surrounding
+// whitespace. Symbols that are empty, longer than maxCoinLength or not
+// alphanumeric are rejected with an error describing why.
+func normalizeCoin(coin string) (string, error) {
+	return normalizeAlnum("coin", coin)
+}
+
+// normalizeSymbol is normalizeCoin for the symbol of a tracked pair, which
+// may name a quote currency after a slash, e.g. "btc/eur". It is returned as
+// the pair is stored (see kraken_api.Symbol): "BTC/EUR", or the bare coin in
+// the configured quote currency.
+func normalizeSymbol(symbol string) (string, error) {
+	coin, quote, found := strings.Cut(symbol, "/")
+	coin, err := normalizeCoin(coin)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return coin, nil
+	}
+	if quote, err = normalizeAlnum("quote", quote); err != nil {
+		return "", err
+	}
+	return kraken_api.Symbol(coin, quote), nil
+}
+
+// normalizeAlnum uppercases and trims s, the part of a symbol named by what,
+// checking it is a non-empty alphanumeric string of at most maxCoinLength.
+func normalizeAlnum(what, s string) (string, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return "", fmt.Errorf("%s must not be empty", what)
+	}
+	if len(s) > maxCoinLength {
+		return "", fmt.Errorf("%s must be at most %d characters", what, maxCoinLength)
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return "", fmt.Errorf("%s must be alphanumeric, got %q", what, s)
+		}
+	}
+	return s, nil
+}
+
+// symbolQuote returns the quote currency the prices of symbol (see
+// normalizeSymbol) are denominated in.
+func (h *CurrencyHandler) symbolQuote(symbol string) string {
+	if _, quote, found := strings.Cut(symbol, "/"); found {
+		return quote
+	}
+	return h.quote()
+}
+
+// roundPrice rounds a price of the coin to its display precision:
+// query.price_decimals if configured for the coin, otherwise the decimals
+// Kraken quotes the pair with. Prices of coins without either are returned as is.
+func (h *CurrencyHandler) roundPrice(coin string, price float64) float64 {
+	decimals, ok := h.cfg.QueryConf.PriceDecimals[coin]
+	if !ok {
+		decimals, ok = kraken_api.PairDecimals(coin)
+	}
+	if !ok {
+		return price
+	}
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(price*scale) / scale
 }
 
-func NewCurrencyHandler(storage CryptoServer) *CurrencyHandler {
-	return &CurrencyHandler{storage: storage}
+// resolveTimestamp returns the requested timestamp, or the current time when
+// it is omitted, checking it matches the configured precision.
+func (h *CurrencyHandler) resolveTimestamp(ts *int64) (int64, error) {
+	if ts == nil {
+		return h.cfg.CollConf.Now(), nil
+	}
+	if err := h.cfg.CollConf.CheckTimestamp(*ts); err != nil {
+		return 0, err
+	}
+	return *ts, nil
+}
+
+// resolveRelative returns the timestamp rel (a negative Go duration) before now.
+func (h *CurrencyHandler) resolveRelative(rel string) (int64, error) {
+	d, err := time.ParseDuration(rel)
+	if err != nil {
+		return 0, fmt.Errorf("invalid relative time %q: must be a duration like -15m", rel)
+	}
+	if d > 0 {
+		return 0, fmt.Errorf("relative time must not be in the future")
+	}
+	return h.cfg.CollConf.Now() + h.cfg.CollConf.Units(d), nil
+}
+
+// resolveMaxGap parses the max_gap of a price request, a positive Go
+// duration; without one query.max_price_gap applies.
+func (h *CurrencyHandler) resolveMaxGap(gap string) (time.Duration, error) {
+	if gap == "" {
+		return h.cfg.QueryConf.MaxPriceGap, nil
+	}
+	d, err := time.ParseDuration(gap)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max_gap %q: must be a duration like 10m", gap)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("max_gap must be positive")
+	}
+	return d, nil
+}
+
+// checkStaleness reports whether the coin's last collected price is recent
+// enough to be served as the current one. Without max_staleness every price is.
+func (h *CurrencyHandler) checkStaleness(coin string) (models.StalePriceResponse, bool) {
+	maxStaleness := h.cfg.QueryConf.MaxStaleness
+	if maxStaleness <= 0 {
+		return models.StalePriceResponse{}, true
+	}
+
+	last, ok := h.storage.LastUpdate(coin)
+	if !ok {
+		return models.StalePriceResponse{Error: "no price collected yet"}, false
+	}
+	age := time.Since(last)
+	if age <= maxStaleness {
+		return models.StalePriceResponse{}, true
+	}
+
+	lastUpdate := last.Unix()
+	if h.cfg.CollConf.Milliseconds() {
+		lastUpdate = last.UnixMilli()
+	}
+	return models.StalePriceResponse{
+		Error:      "price is stale",
+		LastUpdate: lastUpdate,
+		Age:        age.Truncate(time.Second).String(),
+	}, false
 }
 
 // AddCurrency godoc
 // @Summary Add cryptocurrency to tracking
-// @Description Starts collecting prices for specified cryptocurrency with 15 seconds interval
+// @Description Starts collecting prices for specified cryptocurrency every collector.interval (5s by default).
+// @Description With depth set, order-book snapshots are collected as well.
+// @Description quote selects the pair, by default the configured quote currency; pairs in other quotes are
+// @Description tracked as "COIN/QUOTE", e.g. "BTC/EUR", which is the coin to pass to the other endpoints.
+// @Description 503 is returned while the Kraken pairs could not be loaded yet.
 // @Tags currency
 // @Accept json
 // @Produce json
 // @Param input body models.AddCurrencyRequest true "Currency data"
 // @Success 200
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /currency/add [post]
 func (h *CurrencyHandler) AddCurrency(c *gin.Context) {
 	var req models.AddCurrencyRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request"})
+	if !bindJSON(c, &req) {
+		return
+	}
+	coin, err := normalizeCoin(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
 	// Check if currency is supported by Kraken
-	kraken_api.InitKrakenPairs()
-	if _, ok := kraken_api.KrakenPairs[req.Coin]; !ok {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
+	if !requirePairs(c) {
+		return
+	}
+	symbol := kraken_api.Symbol(coin, req.Quote)
+	if !kraken_api.IsSupported(symbol) {
+		respond(c, http.StatusNotFound, models.ErrorResponse{
 			Error: "currency not supported",
 		})
 		return
 	}
 
-	h.storage.AddCurrency(req.Coin)
+	if err := h.storage.AddCurrency(symbol); err != nil {
+		respondAddError(c, err)
+		return
+	}
+	if req.Depth {
+		h.storage.AddDepth(symbol)
+	}
 	c.Status(http.StatusOK)
 }
 
+// respondAddError reports a failed AddCurrency, see addError.
+func respondAddError(c *gin.Context, err error) {
+	code, reason := addError(err)
+	respond(c, code, models.ErrorResponse{Error: reason})
+}
+
+// addError returns the status and reason of a failed AddCurrency: 503 while
+// a store is down and 409 once collector.max_coins coins are tracked.
+func addError(err error) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrUnhealthy):
+		return http.StatusServiceUnavailable, "storage unavailable"
+	case errors.Is(err, storage.ErrTooManyCoins):
+		return http.StatusConflict, "too many tracked coins"
+	default:
+		return http.StatusInternalServerError, "failed to add currency"
+	}
+}
+
+// rejectCoin records that coin of a batch could not be added because of err.
+func rejectCoin(rejected []models.RejectedCoin, coin string, err error) []models.RejectedCoin {
+	_, reason := addError(err)
+	return append(rejected, models.RejectedCoin{Coin: coin, Reason: reason})
+}
+
+// respondPriceError reports a failed price lookup: 404 when no price matches,
+// 503 while the store is down and 500 for anything unexpected.
+func respondPriceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrPriceTooFar):
+		respond(c, http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+	case errors.Is(err, storage.ErrUnsupportedCoin):
+		respond(c, http.StatusNotFound, models.ErrorResponse{Error: "currency not tracked"})
+	case errors.Is(err, storage.ErrNotFound):
+		respond(c, http.StatusNotFound, models.ErrorResponse{Error: "price not found"})
+	case errors.Is(err, storage.ErrBackend):
+		respond(c, http.StatusServiceUnavailable, models.ErrorResponse{Error: "storage unavailable"})
+	default:
+		respond(c, http.StatusInternalServerError, models.ErrorResponse{Error: "failed to get price"})
+	}
+}
+
+// respondLookupError answers a failed depth, decay or comparison lookup:
+// 404 with notFound when there is no data, 501 when the lookup needs the
+// database in cache-only mode and 503 when the storage failed.
+func respondLookupError(c *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respond(c, http.StatusNotFound, models.ErrorResponse{Error: notFound})
+	case errors.Is(err, storage.ErrCacheOnly):
+		respond(c, http.StatusNotImplemented, models.ErrorResponse{Error: "not available in cache-only mode"})
+	default:
+		respond(c, http.StatusServiceUnavailable, models.ErrorResponse{Error: "storage unavailable"})
+	}
+}
+
+// requirePairs responds 503 unless the Kraken pairs were loaded, so a Kraken
+// outage is not reported as every coin being unsupported.
+func requirePairs(c *gin.Context) bool {
+	if kraken_api.PairsLoaded() {
+		return true
+	}
+	respond(c, http.StatusServiceUnavailable, models.ErrorResponse{Error: "currency pairs unavailable"})
+	return false
+}
+
+// AddBatch godoc
+// @Summary Add many cryptocurrencies to tracking
+// @Description Starts collecting prices for every supported coin of the list. Unsupported coins do not fail
+// @Description the request; the response lists which coins were added, already tracked or unsupported.
+// @Description Coins that could not be added, e.g. beyond collector.max_coins, are listed in rejected with
+// @Description the reason, while the others are still added.
+// @Description 503 is returned while the Kraken pairs could not be loaded yet.
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.AddBatchRequest true "Coins"
+// @Success 200 {object} models.AddBatchResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /currency/add-batch [post]
+func (h *CurrencyHandler) AddBatch(c *gin.Context) {
+	var req models.AddBatchRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !requirePairs(c) {
+		return
+	}
+
+	resp := models.AddBatchResponse{
+		Added:          make([]string, 0),
+		AlreadyTracked: make([]string, 0),
+		Unsupported:    make([]string, 0),
+		Rejected:       make([]models.RejectedCoin, 0),
+	}
+	seen := make(map[string]bool, len(req.Coins))
+	for _, symbol := range req.Coins {
+		coin, err := normalizeCoin(symbol)
+		if err != nil {
+			resp.Unsupported = append(resp.Unsupported, symbol)
+			continue
+		}
+		if seen[coin] {
+			continue
+		}
+		seen[coin] = true
+
+		if !kraken_api.IsSupported(coin) {
+			resp.Unsupported = append(resp.Unsupported, coin)
+			continue
+		}
+		if h.storage.Tracked(coin) {
+			resp.AlreadyTracked = append(resp.AlreadyTracked, coin)
+			continue
+		}
+		if err := h.storage.AddCurrency(coin); err != nil {
+			resp.Rejected = rejectCoin(resp.Rejected, coin, err)
+			continue
+		}
+		resp.Added = append(resp.Added, coin)
+	}
+
+	respond(c, http.StatusOK, resp)
+}
+
+// AddAllCurrencies godoc
+// @Summary Add all matching cryptocurrencies to tracking
+// @Description Starts collecting prices for every online pair in the configured quote currency
+// @Description whose symbol starts with prefix, in symbol order, up to limit (at most 100) coins.
+// @Description Coins that could not be added, e.g. beyond collector.max_coins, are listed in rejected and count towards limit.
+// @Description Coins that are tracked already are listed in skipped and do not count towards limit.
+// @Description 503 is returned while the Kraken pairs could not be loaded yet.
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.AddAllRequest true "Coin filter"
+// @Success 200 {object} models.AddAllResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /currency/add-all [post]
+func (h *CurrencyHandler) AddAllCurrencies(c *gin.Context) {
+	var req models.AddAllRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !requirePairs(c) {
+		return
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = maxAddAll
+	}
+	prefix := strings.ToUpper(req.Prefix)
+
+	resp := models.AddAllResponse{
+		Quote:    h.quote(),
+		Coins:    make([]string, 0),
+		Skipped:  make([]string, 0),
+		Rejected: make([]models.RejectedCoin, 0),
+	}
+	for _, coin := range kraken_api.Coins() {
+		if len(resp.Coins)+len(resp.Rejected) == limit {
+			break
+		}
+		if !strings.HasPrefix(coin, prefix) {
+			continue
+		}
+		if h.storage.Tracked(coin) {
+			resp.Skipped = append(resp.Skipped, coin)
+			continue
+		}
+		if err := h.storage.AddCurrency(coin); err != nil {
+			resp.Rejected = rejectCoin(resp.Rejected, coin, err)
+			continue
+		}
+		resp.Coins = append(resp.Coins, coin)
+	}
+
+	respond(c, http.StatusOK, resp)
+}
+
 // RemoveCurrency godoc
 // @Summary Remove cryptocurrency from tracking
-// @Description Stops collecting prices for specified cryptocurrency
+// @Description Stops collecting prices for specified cryptocurrency.
+// @Description 404 is returned if the coin was not tracked; removing it again is harmless.
 // @Tags currency
 // @Accept json
 // @Produce json
 // @Param input body models.RemoveCurrencyRequest true "Currency data"
 // @Success 200
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /currency/remove [post]
 func (h *CurrencyHandler) RemoveCurrency(c *gin.Context) {
 	var req models.RemoveCurrencyRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request"})
+	if !bindJSON(c, &req) {
+		return
+	}
+	coin, err := normalizeSymbol(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	h.storage.RemoveCurrency(req.Coin)
+	tracked, err := h.storage.RemoveCurrency(coin)
+	if err != nil {
+		respond(c, http.StatusInternalServerError, models.ErrorResponse{Error: "failed to remove currency"})
+		return
+	}
+	if !tracked {
+		respond(c, http.StatusNotFound, models.ErrorResponse{Error: "currency not tracked"})
+		return
+	}
 	c.Status(http.StatusOK)
 }
 
 // GetPrice godoc
 // @Summary Get cryptocurrency price
-// @Description Returns cryptocurrency price at specified time or nearest available
+// @Description Returns cryptocurrency price at specified time or nearest available.
+// @Description The time is either a timestamp or relative to now, e.g. "-15m"; the response holds the timestamp of the matched price point and the resolved requested one.
+// @Description source tells which store answered: memory, cache (Redis) or db (PostgreSQL), e.g. to spot a cold cache.
+// @Description match selects the stored price answering it: nearest (default), last_before, first_after or interpolate.
+// @Description max_gap overrides max_price_gap: a stored price further from the time than it is answered with 404.
+// @Description Without a timestamp, 503 is returned once the last collected price is older than max_staleness.
+// @Description 404 tells a coin that is not tracked apart from a missing price; 503 is also returned while the store is down.
+// @Description quote selects the pair, by default the configured quote currency.
 // @Tags currency
 // @Accept json
 // @Produce json
 // @Param input body models.PriceRequest true "Request parameters"
 // @Success 200 {object} models.PriceResponse
+// @Header 200 {string} X-Price-Source "Data source of the price: memory, cache or db"
 // @Failure 400 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 404 {object} models.NoDataBeforeResponse
 // @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.StalePriceResponse
 // @Router /currency/price [post]
 func (h *CurrencyHandler) GetPrice(c *gin.Context) {
 	var req models.PriceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid request"})
+	if !bindJSON(c, &req) {
+		return
+	}
+	coin, err := normalizeCoin(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if req.Timestamp != nil && req.Relative != "" {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "timestamp and relative are mutually exclusive"})
+		return
+	}
+	var timestamp int64
+	if req.Relative != "" {
+		timestamp, err = h.resolveRelative(req.Relative)
+	} else {
+		timestamp, err = h.resolveTimestamp(req.Timestamp)
+	}
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	timestamp := time.Now().Unix()
-	if req.Timestamp != nil {
-		timestamp = *req.Timestamp
+	quote := h.resolveQuote(req.Quote)
+	symbol := kraken_api.Symbol(coin, quote)
+
+	if req.Timestamp == nil && req.Relative == "" {
+		if stale, ok := h.checkStaleness(symbol); !ok {
+			respond(c, http.StatusServiceUnavailable, stale)
+			return
+		}
 	}
 
-	price, err := h.storage.GetPrice(req.Coin, timestamp)
+	match, err := storage.ParseMatchStrategy(req.Match)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "price not found"})
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	maxGap, err := h.resolveMaxGap(req.MaxGap)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
+	if h.cfg.QueryConf.RejectBeforeFirst {
+		earliest, err := h.storage.CheckEarliest(c.Request.Context(), symbol, timestamp)
+		switch {
+		case errors.Is(err, storage.ErrNoDataBefore):
+			respond(c, http.StatusNotFound, models.NoDataBeforeResponse{Error: err.Error(), Earliest: earliest})
+			return
+		case errors.Is(err, storage.ErrBackend):
+			respondPriceError(c, err)
+			return
+		}
+	}
+
+	point, source, err := h.storage.GetPriceWithin(c.Request.Context(), symbol, timestamp, match, maxGap)
+	if err != nil {
+		respondPriceError(c, err)
+		return
+	}
+	c.Header(priceSourceHeader, source)
+
 	response := models.PriceResponse{
-		Coin:      req.Coin,
-		Price:     price,
+		Coin:               coin,
+		Quote:              quote,
+		Price:              h.roundPrice(symbol, point.Price),
+		Timestamp:          point.Timestamp,
+		RequestedTimestamp: timestamp,
+		Source:             source,
+	}
+
+	respond(c, http.StatusOK, response)
+}
+
+// Portfolio godoc
+// @Summary Get the value of a portfolio
+// @Description Values the holdings with the price of every coin nearest to the specified time and returns the total
+// @Description with a per-coin breakdown. Coins without a price are listed in missing and left out of the total,
+// @Description or fail the request with 404 when strict is set.
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.PortfolioRequest true "Holdings"
+// @Success 200 {object} models.PortfolioResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Router /currency/portfolio [post]
+func (h *CurrencyHandler) Portfolio(c *gin.Context) {
+	var req models.PortfolioRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	timestamp, err := h.resolveTimestamp(req.Timestamp)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// Holdings of the same coin in another casing are added up
+	holdings := make(map[string]float64, len(req.Holdings))
+	for symbol, amount := range req.Holdings {
+		coin, err := normalizeCoin(symbol)
+		if err != nil {
+			respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if amount < 0 {
+			respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "amount of " + coin + " is negative"})
+			return
+		}
+		holdings[coin] += amount
+	}
+	coins := make([]string, 0, len(holdings))
+	for coin := range holdings {
+		coins = append(coins, coin)
+	}
+	sort.Strings(coins)
+
+	quote := h.resolveQuote(req.Quote)
+	resp := models.PortfolioResponse{
+		Quote:     quote,
+		Timestamp: timestamp,
+		Holdings:  make([]models.PortfolioHolding, 0, len(coins)),
+		Missing:   make([]string, 0),
+	}
+	for _, coin := range coins {
+		symbol := kraken_api.Symbol(coin, quote)
+		point, _, err := h.storage.GetPriceWith(c.Request.Context(), symbol, timestamp, storage.NearestMatch{})
+		if err != nil {
+			resp.Missing = append(resp.Missing, coin)
+			continue
+		}
+		price := h.roundPrice(symbol, point.Price)
+		amount := holdings[coin]
+		resp.Holdings = append(resp.Holdings, models.PortfolioHolding{
+			Coin:      coin,
+			Amount:    amount,
+			Price:     price,
+			Value:     amount * price,
+			Timestamp: point.Timestamp,
+		})
+		resp.Total += amount * price
+	}
+
+	if req.Strict && len(resp.Missing) > 0 {
+		respond(c, http.StatusNotFound, models.ErrorResponse{
+			Error: "price not found for " + strings.Join(resp.Missing, ", "),
+		})
+		return
+	}
+	respond(c, http.StatusOK, resp)
+}
+
+// GetDepth godoc
+// @Summary Get order-book depth snapshot
+// @Description Returns the order-book snapshot nearest to the specified time
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.DepthRequest true "Request parameters"
+// @Success 200 {object} models.DepthResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 501 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /currency/depth [post]
+func (h *CurrencyHandler) GetDepth(c *gin.Context) {
+	var req models.DepthRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	coin, err := normalizeSymbol(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	timestamp, err := h.resolveTimestamp(req.Timestamp)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	book, snapTimestamp, err := h.storage.GetDepth(coin, timestamp)
+	if err != nil {
+		respondLookupError(c, err, "depth not found")
+		return
+	}
+
+	respond(c, http.StatusOK, models.DepthResponse{
+		Coin:      coin,
+		Timestamp: snapTimestamp,
+		Bids:      book.Bids,
+		Asks:      book.Asks,
+	})
+}
+
+// DecayedAverage godoc
+// @Summary Get time-decayed average price
+// @Description Returns the exponentially time-weighted average price over a window ending at the specified time.
+// @Description A point's weight halves every half_life before the end of the window.
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.DecayRequest true "Request parameters"
+// @Success 200 {object} models.DecayResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 501 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /currency/twap-decay [post]
+func (h *CurrencyHandler) DecayedAverage(c *gin.Context) {
+	var req models.DecayRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	coin, err := normalizeSymbol(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	to, err := h.resolveTimestamp(req.Timestamp)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	maxWindow := h.cfg.QueryConf.MaxDecayWindow
+	if maxWindow <= 0 {
+		maxWindow = defaultMaxDecayWindow
+	}
+	window, err := time.ParseDuration(req.Window)
+	if err != nil || window <= 0 || window > maxWindow {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{
+			Error: "window must be a positive duration up to " + maxWindow.String(),
+		})
+		return
+	}
+
+	halfLife := h.cfg.QueryConf.DecayHalfLife
+	if halfLife <= 0 {
+		halfLife = defaultDecayHalfLife
+	}
+	if req.HalfLife != "" {
+		halfLife, err = time.ParseDuration(req.HalfLife)
+		if err != nil {
+			respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "half_life must be a duration"})
+			return
+		}
+	}
+	halfLifeUnits := h.cfg.CollConf.Units(halfLife)
+	if halfLifeUnits <= 0 {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "half_life is too small"})
+		return
+	}
+
+	from := to - h.cfg.CollConf.Units(window)
+	price, points, err := h.storage.GetDecayedAverage(coin, from, to, halfLifeUnits)
+	if err != nil {
+		respondLookupError(c, err, "no prices in window")
+		return
+	}
+
+	respond(c, http.StatusOK, models.DecayResponse{
+		Coin:     coin,
+		Quote:    h.symbolQuote(coin),
+		Price:    price,
+		From:     from,
+		To:       to,
+		HalfLife: halfLife.String(),
+		Points:   points,
+	})
+}
+
+// GetPriceRange godoc
+// @Summary Get prices over a window
+// @Description Returns a page of the stored prices of the cryptocurrency between from and to (inclusive) in time order:
+// @Description limit points (at most and by default query.max_range_points) after skipping offset of them.
+// @Description next holds the offset of the following page while there is one.
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.RangeRequest true "Request parameters"
+// @Success 200 {object} models.RangeResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /currency/range [post]
+func (h *CurrencyHandler) GetPriceRange(c *gin.Context) {
+	var req models.RangeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	coin, err := normalizeSymbol(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	for _, ts := range []int64{req.From, req.To} {
+		if err := h.cfg.CollConf.CheckTimestamp(ts); err != nil {
+			respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	if req.From > req.To {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "from must not be after to"})
+		return
+	}
+
+	points, more, err := h.storage.GetPriceRange(coin, req.From, req.To, req.Limit, req.Offset)
+	if err != nil {
+		respond(c, http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	for i := range points {
+		points[i].Price = h.roundPrice(coin, points[i].Price)
+	}
+
+	resp := models.RangeResponse{
+		Coin:   coin,
+		Quote:  h.symbolQuote(coin),
+		From:   req.From,
+		To:     req.To,
+		Points: points,
+	}
+	if more {
+		next := req.Offset + len(points)
+		resp.Next = &next
+	}
+	respond(c, http.StatusOK, resp)
+}
+
+// GetOHLC godoc
+// @Summary Get OHLC candles over a window
+// @Description Aggregates the stored prices of the cryptocurrency between from and to (inclusive) into open/high/low/close
+// @Description candles of interval, e.g. "5m", aligned to multiples of it. interval must divide the window evenly and the
+// @Description window may hold at most query.max_range_points candles. Buckets without prices are left out.
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.OHLCRequest true "Request parameters"
+// @Success 200 {object} models.OHLCResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /currency/ohlc [post]
+func (h *CurrencyHandler) GetOHLC(c *gin.Context) {
+	var req models.OHLCRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	coin, err := normalizeSymbol(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	for _, ts := range []int64{req.From, req.To} {
+		if err := h.cfg.CollConf.CheckTimestamp(ts); err != nil {
+			respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	if req.From > req.To {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "from must not be after to"})
+		return
+	}
+
+	interval, err := h.resolveInterval(req.Interval, req.To-req.From)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	candles, err := h.storage.GetOHLC(coin, req.From, req.To, h.cfg.CollConf.Units(interval))
+	if err != nil {
+		respond(c, http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	for i := range candles {
+		candles[i].Open = h.roundPrice(coin, candles[i].Open)
+		candles[i].High = h.roundPrice(coin, candles[i].High)
+		candles[i].Low = h.roundPrice(coin, candles[i].Low)
+		candles[i].Close = h.roundPrice(coin, candles[i].Close)
+	}
+
+	respond(c, http.StatusOK, models.OHLCResponse{
+		Coin:     coin,
+		Quote:    h.symbolQuote(coin),
+		From:     req.From,
+		To:       req.To,
+		Interval: interval.String(),
+		Candles:  candles,
+	})
+}
+
+// resolveInterval parses the candle interval of an OHLC request. It must be a
+// positive whole number of timestamp units dividing the window, which is in
+// the configured timestamp precision, into at most query.max_range_points candles.
+func (h *CurrencyHandler) resolveInterval(interval string, window int64) (time.Duration, error) {
+	unit := time.Second
+	if h.cfg.CollConf.Milliseconds() {
+		unit = time.Millisecond
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d < unit || d%unit != 0 {
+		return 0, fmt.Errorf("interval must be a positive duration in whole %s, e.g. 5m", unit)
+	}
+
+	units := h.cfg.CollConf.Units(d)
+	if window%units != 0 {
+		return 0, fmt.Errorf("interval %s must divide the window evenly", d)
+	}
+	maxCandles := int64(h.cfg.QueryConf.MaxRangePoints)
+	if maxCandles <= 0 {
+		maxCandles = defaultMaxCandles
+	}
+	if window/units > maxCandles {
+		return 0, fmt.Errorf("window holds more than %d candles of %s", maxCandles, d)
+	}
+	return d, nil
+}
+
+// HotCoins godoc
+// @Summary Most queried cryptocurrencies
+// @Description Returns the coins with the most price queries, most queried first.
+// @Tags currency
+// @Produce json
+// @Param limit query int false "Number of coins (1-100, default 10)"
+// @Success 200 {object} models.HotCoinsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /currency/hot [get]
+func (h *CurrencyHandler) HotCoins(c *gin.Context) {
+	var req models.HotCoinsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "invalid limit"})
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultHotCoins
+	}
+
+	coins, err := h.storage.HotCoins(req.Limit)
+	if err != nil {
+		respond(c, http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	respond(c, http.StatusOK, models.HotCoinsResponse{Coins: coins})
+}
+
+// ComparePrices godoc
+// @Summary Compare prices across exchanges
+// @Description Returns the price of every collected exchange nearest to the specified time and the spread between them.
+// @Description Exchanges without data for the coin are listed in missing.
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.CompareRequest true "Request parameters"
+// @Success 200 {object} models.CompareResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 501 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /currency/compare [post]
+func (h *CurrencyHandler) ComparePrices(c *gin.Context) {
+	var req models.CompareRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	coin, err := normalizeSymbol(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	timestamp, err := h.resolveTimestamp(req.Timestamp)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	prices, missing, err := h.storage.ComparePrices(coin, timestamp)
+	if err != nil {
+		respondLookupError(c, err, "price not found")
+		return
+	}
+
+	response := models.CompareResponse{
+		Coin:      coin,
+		Quote:     h.symbolQuote(coin),
 		Timestamp: timestamp,
+		Prices:    prices,
+		Missing:   missing,
+	}
+	if len(prices) >= 2 {
+		low, high := prices[0].Price, prices[0].Price
+		for _, p := range prices[1:] {
+			low, high = math.Min(low, p.Price), math.Max(high, p.Price)
+		}
+		spread := high - low
+		response.Spread = &spread
+		if low > 0 {
+			percent := spread / low * 100
+			response.SpreadPercent = &percent
+		}
 	}
 
-	c.JSON(http.StatusOK, response)
+	respond(c, http.StatusOK, response)
 }
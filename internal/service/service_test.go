@@ -0,0 +1,1076 @@
+package handlers_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	handlers "test-task1/internal/service"
+	"test-task1/internal/storage"
+	"test-task1/models"
+	kraken_api "test-task1/pkg/kraken-api"
+)
+
+// fakeStorage is an in-memory CryptoServer used to drive the handlers.
+type fakeStorage struct {
+	price   float64
+	source  string
+	err     error
+	depth   []string
+	added   []string
+	addErr  error
+	maxAdds int // AddCurrency fails with ErrTooManyCoins beyond this many added coins; 0 = no cap
+	match   storage.MatchStrategy
+	tracked []string
+
+	lastUpdate  time.Time
+	compare     []models.ExchangePrice
+	missing     []string
+	hot         []models.HotCoin
+	points      []models.PricePoint
+	hotLimit    int
+	priceCoin   string             // coin of the last price, depth or history query
+	prices      map[string]float64 // per-coin prices of GetPriceWith, overriding price
+	earliest    int64              // first stored timestamp; 0 if nothing is stored
+	earliestErr error              // error of CheckEarliest, overriding earliest
+	pointOffset int64              // how much earlier than requested the found point is
+	removed     []string
+	removeErr   error
+	maxGap      time.Duration
+	candles     []models.Candle
+	interval    int64
+}
+
+func (f *fakeStorage) AddDepth(coin string) { f.depth = append(f.depth, coin) }
+
+func (f *fakeStorage) RemoveCurrency(coin string) (bool, error) {
+	if f.removeErr != nil {
+		return false, f.removeErr
+	}
+	f.removed = append(f.removed, coin)
+	return f.Tracked(coin), nil
+}
+
+func (f *fakeStorage) Tracked(coin string) bool {
+	for _, c := range append(f.tracked, f.added...) {
+		if c == coin {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeStorage) AddCurrency(coin string) error {
+	if f.addErr != nil {
+		return f.addErr
+	}
+	if f.maxAdds > 0 && len(f.added) >= f.maxAdds {
+		return fmt.Errorf("storage.AddCurrency: %w (%d)", storage.ErrTooManyCoins, f.maxAdds)
+	}
+	f.added = append(f.added, coin)
+	return nil
+}
+
+func (f *fakeStorage) GetDepth(coin string, timestamp int64) (models.OrderBook, int64, error) {
+	f.priceCoin = coin
+	return models.OrderBook{}, 0, f.err
+}
+
+func (f *fakeStorage) GetDecayedAverage(coin string, from, to, halfLife int64) (float64, int, error) {
+	f.priceCoin = coin
+	return f.price, 1, f.err
+}
+
+func (f *fakeStorage) GetPriceWith(_ context.Context, coin string, timestamp int64, match storage.MatchStrategy) (models.PricePoint, string, error) {
+	f.match = match
+	f.priceCoin = coin
+	point := models.PricePoint{Timestamp: timestamp - f.pointOffset, Price: f.price}
+	if f.prices != nil {
+		price, ok := f.prices[coin]
+		if !ok {
+			return models.PricePoint{}, "", errors.New("no price")
+		}
+		point.Price = price
+		return point, f.source, nil
+	}
+	return point, f.source, f.err
+}
+
+func (f *fakeStorage) GetPriceWithin(ctx context.Context, coin string, timestamp int64, match storage.MatchStrategy, maxGap time.Duration) (models.PricePoint, string, error) {
+	f.maxGap = maxGap
+	point, source, err := f.GetPriceWith(ctx, coin, timestamp, match)
+	if err == nil && maxGap > 0 && f.pointOffset > int64(maxGap/time.Second) {
+		return models.PricePoint{}, "", fmt.Errorf("%w of %s", storage.ErrPriceTooFar, maxGap)
+	}
+	return point, source, err
+}
+
+func (f *fakeStorage) LastUpdate(coin string) (time.Time, bool) {
+	return f.lastUpdate, !f.lastUpdate.IsZero()
+}
+
+func (f *fakeStorage) CheckEarliest(_ context.Context, coin string, timestamp int64) (int64, error) {
+	if f.earliestErr != nil {
+		return 0, f.earliestErr
+	}
+	if f.earliest == 0 {
+		return 0, sql.ErrNoRows
+	}
+	if timestamp < f.earliest {
+		return f.earliest, storage.ErrNoDataBefore
+	}
+	return f.earliest, nil
+}
+
+func (f *fakeStorage) ComparePrices(coin string, timestamp int64) ([]models.ExchangePrice, []string, error) {
+	f.priceCoin = coin
+	return f.compare, f.missing, f.err
+}
+
+func (f *fakeStorage) GetPriceRange(coin string, from, to int64, limit, offset int) ([]models.PricePoint, bool, error) {
+	f.priceCoin = coin
+	points := f.points[min(offset, len(f.points)):]
+	if limit > 0 && len(points) > limit {
+		return points[:limit], true, f.err
+	}
+	return points, false, f.err
+}
+
+func (f *fakeStorage) GetOHLC(coin string, from, to, interval int64) ([]models.Candle, error) {
+	f.priceCoin = coin
+	f.interval = interval
+	return f.candles, f.err
+}
+
+func (f *fakeStorage) HotCoins(limit int) ([]models.HotCoin, error) {
+	f.hotLimit = limit
+	return f.hot, f.err
+}
+
+func newTestRouter(s handlers.CryptoServer) *gin.Engine {
+	return newTestRouterWithConfig(s, models.Config{})
+}
+
+func newTestRouterWithConfig(s handlers.CryptoServer, cfg models.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(handlers.JSONCase(cfg.ServConf.JSONCase))
+	handlers.UseJSONFallbacks(r)
+	h := handlers.NewCurrencyHandler(s, cfg)
+	r.POST("/currency/add", h.AddCurrency)
+	r.POST("/currency/add-batch", h.AddBatch)
+	r.POST("/currency/add-all", h.AddAllCurrencies)
+	r.POST("/currency/remove", h.RemoveCurrency)
+	r.POST("/currency/price", h.GetPrice)
+	r.POST("/currency/depth", h.GetDepth)
+	r.POST("/currency/twap-decay", h.DecayedAverage)
+	r.POST("/currency/compare", h.ComparePrices)
+	r.GET("/currency/hot", h.HotCoins)
+	r.POST("/currency/range", h.GetPriceRange)
+	r.POST("/currency/ohlc", h.GetOHLC)
+	r.POST("/currency/portfolio", h.Portfolio)
+	return r
+}
+
+func doJSON(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetPriceSourceHeader(t *testing.T) {
+	t.Run("cache hit", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{price: 50000, source: storage.SourceCache})
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "cache", w.Header().Get("X-Price-Source"))
+	})
+
+	t.Run("db hit", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{price: 50000, source: storage.SourceDB})
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "db", w.Header().Get("X-Price-Source"))
+	})
+
+	t.Run("not found has no source", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{err: storage.ErrNotFound})
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC"}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, w.Header().Get("X-Price-Source"))
+	})
+}
+
+func TestGetPriceErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+		body string
+	}{
+		{"not found", fmt.Errorf("%w: BTCUSD", storage.ErrNotFound), http.StatusNotFound, `{"error":"price not found"}`},
+		{"not tracked", fmt.Errorf("%w: BTCUSD", storage.ErrUnsupportedCoin), http.StatusNotFound, `{"error":"currency not tracked"}`},
+		{"store down", fmt.Errorf("%w: connection refused", storage.ErrBackend), http.StatusServiceUnavailable, `{"error":"storage unavailable"}`},
+		{"unexpected", errors.New("boom"), http.StatusInternalServerError, `{"error":"failed to get price"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRouter(&fakeStorage{err: tt.err})
+			w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+
+			assert.Equal(t, tt.code, w.Code)
+			assert.JSONEq(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+func TestGetPriceQuote(t *testing.T) {
+	t.Run("default quote", func(t *testing.T) {
+		// The nearest stored price is from two seconds before the requested time
+		r := newTestRouter(&fakeStorage{price: 50000, source: storage.SourceDB, pointOffset: 2})
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"USD","price":50000,"timestamp":1736500488,"requested_timestamp":1736500490,"source":"db"}`, w.Body.String())
+	})
+
+	t.Run("configured quote", func(t *testing.T) {
+		cfg := models.Config{KrakenConf: models.KrakenCfg{Quote: "EUR"}}
+		r := newTestRouterWithConfig(&fakeStorage{price: 45000, source: storage.SourceDB}, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"EUR","price":45000,"timestamp":1736500490,"requested_timestamp":1736500490,"source":"db"}`, w.Body.String())
+	})
+}
+
+func TestGetPriceRelative(t *testing.T) {
+	t.Run("resolved against now", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{price: 50000, source: storage.SourceDB})
+		before := time.Now().Add(-15 * time.Minute).Unix()
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","relative":"-15m"}`)
+		after := time.Now().Add(-15 * time.Minute).Unix()
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp models.PriceResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.GreaterOrEqual(t, resp.Timestamp, before)
+		assert.LessOrEqual(t, resp.Timestamp, after)
+	})
+
+	t.Run("milliseconds", func(t *testing.T) {
+		cfg := models.Config{CollConf: models.CollectorCfg{TimestampPrecision: models.PrecisionMilliseconds}}
+		r := newTestRouterWithConfig(&fakeStorage{price: 50000, source: storage.SourceDB}, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","relative":"-1h30m"}`)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp models.PriceResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.InDelta(t, time.Now().Add(-90*time.Minute).UnixMilli(), resp.Timestamp, 5000)
+	})
+
+	for _, body := range []string{
+		`{"coin":"BTC","relative":"15m"}`,
+		`{"coin":"BTC","relative":"-15 minutes"}`,
+		`{"coin":"BTC","relative":"yesterday"}`,
+		`{"coin":"BTC","relative":"-15m","timestamp":1736500490}`,
+	} {
+		t.Run(body, func(t *testing.T) {
+			r := newTestRouter(&fakeStorage{price: 50000, source: storage.SourceDB})
+			w := doJSON(r, http.MethodPost, "/currency/price", body)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestGetPriceMaxGap(t *testing.T) {
+	cfg := models.Config{QueryConf: models.QueryCfg{MaxPriceGap: time.Hour}}
+
+	t.Run("configured gap", func(t *testing.T) {
+		fake := &fakeStorage{price: 50000, source: storage.SourceDB, pointOffset: 7200}
+		r := newTestRouterWithConfig(fake, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":"no price within tolerance of 1h0m0s"}`, w.Body.String())
+		assert.Equal(t, time.Hour, fake.maxGap)
+	})
+
+	t.Run("request override", func(t *testing.T) {
+		fake := &fakeStorage{price: 50000, source: storage.SourceDB, pointOffset: 7200}
+		r := newTestRouterWithConfig(fake, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490,"max_gap":"3h"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 3*time.Hour, fake.maxGap)
+	})
+
+	for _, gap := range []string{"0s", "-1h", "an hour"} {
+		t.Run("invalid "+gap, func(t *testing.T) {
+			r := newTestRouterWithConfig(&fakeStorage{price: 50000, source: storage.SourceDB}, cfg)
+			w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","max_gap":"`+gap+`"}`)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestTimestampPrecision(t *testing.T) {
+	seconds := models.Config{}
+	millis := models.Config{CollConf: models.CollectorCfg{TimestampPrecision: models.PrecisionMilliseconds}}
+
+	tests := []struct {
+		name string
+		cfg  models.Config
+		body string
+		code int
+	}{
+		{"seconds accepted", seconds, `{"coin":"BTC","timestamp":1736500490}`, http.StatusOK},
+		{"milliseconds rejected in seconds mode", seconds, `{"coin":"BTC","timestamp":1736500490123}`, http.StatusBadRequest},
+		{"milliseconds accepted", millis, `{"coin":"BTC","timestamp":1736500490123}`, http.StatusOK},
+		{"seconds rejected in milliseconds mode", millis, `{"coin":"BTC","timestamp":1736500490}`, http.StatusBadRequest},
+		{"negative rejected", seconds, `{"coin":"BTC","timestamp":-1}`, http.StatusBadRequest},
+		{"negative rejected in milliseconds mode", millis, `{"coin":"BTC","timestamp":-1736500490123}`, http.StatusBadRequest},
+		{"max int64 rejected", seconds, `{"coin":"BTC","timestamp":9223372036854775807}`, http.StatusBadRequest},
+		{"max int64 rejected in milliseconds mode", millis, `{"coin":"BTC","timestamp":9223372036854775807}`, http.StatusBadRequest},
+		{"after year 3000 rejected", millis, `{"coin":"BTC","timestamp":32503680000001}`, http.StatusBadRequest},
+		{"year 3000 accepted", millis, `{"coin":"BTC","timestamp":32503680000000}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRouterWithConfig(&fakeStorage{price: 50000, source: storage.SourceDB}, tt.cfg)
+			w := doJSON(r, http.MethodPost, "/currency/price", tt.body)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestDecayedAverageValidation(t *testing.T) {
+	r := newTestRouter(&fakeStorage{price: 50000})
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"valid", `{"coin":"BTC","window":"1h","half_life":"5m","timestamp":1736500490}`, http.StatusOK},
+		{"default half-life", `{"coin":"BTC","window":"1h"}`, http.StatusOK},
+		{"unparseable window", `{"coin":"BTC","window":"soon"}`, http.StatusBadRequest},
+		{"negative window", `{"coin":"BTC","window":"-1h"}`, http.StatusBadRequest},
+		{"window too large", `{"coin":"BTC","window":"48h"}`, http.StatusBadRequest},
+		{"zero half-life", `{"coin":"BTC","window":"1h","half_life":"0s"}`, http.StatusBadRequest},
+		{"sub-unit half-life", `{"coin":"BTC","window":"1h","half_life":"10ms"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doJSON(r, http.MethodPost, "/currency/twap-decay", tt.body)
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestJSONCase(t *testing.T) {
+	body := `{"coin":"BTC","window":"1h","half_life":"5m","timestamp":1736500490}`
+
+	t.Run("snake_case", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{price: 50000})
+		w := doJSON(r, http.MethodPost, "/currency/twap-decay", body)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"USD","price":50000,"from":1736496890,"to":1736500490,"half_life":"5m0s","points":1}`, w.Body.String())
+	})
+
+	t.Run("camelCase", func(t *testing.T) {
+		cfg := models.Config{ServConf: models.ServerCfg{JSONCase: models.JSONCaseCamel}}
+		r := newTestRouterWithConfig(&fakeStorage{price: 50000}, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/twap-decay", body)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"USD","price":50000,"from":1736496890,"to":1736500490,"halfLife":"5m0s","points":1}`, w.Body.String())
+	})
+}
+
+func TestGetPriceStaleness(t *testing.T) {
+	cfg := models.Config{QueryConf: models.QueryCfg{MaxStaleness: 30 * time.Second}}
+
+	t.Run("fresh", func(t *testing.T) {
+		r := newTestRouterWithConfig(&fakeStorage{price: 50000, source: storage.SourceCache, lastUpdate: time.Now()}, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		last := time.Now().Add(-5 * time.Minute)
+		r := newTestRouterWithConfig(&fakeStorage{price: 50000, source: storage.SourceCache, lastUpdate: last}, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC"}`)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, fmt.Sprintf(`{"error":"price is stale","last_update":%d,"age":"5m0s"}`, last.Unix()), w.Body.String())
+	})
+
+	t.Run("never collected", func(t *testing.T) {
+		r := newTestRouterWithConfig(&fakeStorage{price: 50000, source: storage.SourceDB}, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC"}`)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("explicit timestamp is not checked", func(t *testing.T) {
+		r := newTestRouterWithConfig(&fakeStorage{price: 50000, source: storage.SourceDB}, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{price: 50000, source: storage.SourceDB})
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestFallbacks(t *testing.T) {
+	r := newTestRouter(&fakeStorage{})
+
+	w := doJSON(r, http.MethodGet, "/no/such/path", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error":"route not found"}`, w.Body.String())
+
+	w = doJSON(r, http.MethodGet, "/currency/price", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "POST", w.Header().Get("Allow"))
+	assert.JSONEq(t, `{"error":"method not allowed"}`, w.Body.String())
+}
+
+func TestComparePrices(t *testing.T) {
+	t.Run("spread", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{compare: []models.ExchangePrice{
+			{Source: "kraken", Price: 50000, Timestamp: 1736500488},
+			{Source: "coinbase", Price: 50100, Timestamp: 1736500489},
+		}})
+		w := doJSON(r, http.MethodPost, "/currency/compare", `{"coin":"BTC","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"USD","timestamp":1736500490,
+			"prices":[{"source":"kraken","price":50000,"timestamp":1736500488},{"source":"coinbase","price":50100,"timestamp":1736500489}],
+			"spread":100,"spread_percent":0.2}`, w.Body.String())
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{
+			compare: []models.ExchangePrice{{Source: "kraken", Price: 50000, Timestamp: 1736500488}},
+			missing: []string{"coinbase"},
+		})
+		w := doJSON(r, http.MethodPost, "/currency/compare", `{"coin":"BTC","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"USD","timestamp":1736500490,
+			"prices":[{"source":"kraken","price":50000,"timestamp":1736500488}],
+			"missing":["coinbase"]}`, w.Body.String())
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		r := newTestRouter(&fakeStorage{err: sql.ErrNoRows})
+		w := doJSON(r, http.MethodPost, "/currency/compare", `{"coin":"BTC"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestLookupErrors(t *testing.T) {
+	requests := map[string]string{
+		"/currency/depth":      `{"coin":"BTC","timestamp":1736500490}`,
+		"/currency/twap-decay": `{"coin":"BTC","window":"1h"}`,
+		"/currency/compare":    `{"coin":"BTC","timestamp":1736500490}`,
+	}
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"no data", sql.ErrNoRows, http.StatusNotFound},
+		{"cache-only", fmt.Errorf("storage.GetDepth: %w", storage.ErrCacheOnly), http.StatusNotImplemented},
+		{"database down", errors.New("connection refused"), http.StatusServiceUnavailable},
+	}
+	for path, body := range requests {
+		for _, tt := range tests {
+			t.Run(path+" "+tt.name, func(t *testing.T) {
+				w := doJSON(newTestRouter(&fakeStorage{err: tt.err}), http.MethodPost, path, body)
+				assert.Equal(t, tt.code, w.Code, w.Body.String())
+			})
+		}
+	}
+}
+
+// useAssetPairs points the Kraken client at a server answering AssetPairs
+// with body and loads the pairs from it.
+func useAssetPairs(t *testing.T, body string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	kraken_api.Configure(models.KrakenCfg{BaseURL: srv.URL})
+	t.Cleanup(func() {
+		kraken_api.Configure(models.KrakenCfg{BaseURL: kraken_api.DefaultBaseURL})
+	})
+	require.NoError(t, kraken_api.RefreshPairs(context.Background()))
+}
+
+func TestAddAllCurrencies(t *testing.T) {
+	useAssetPairs(t, `{"error":[],"result":{
+		"XXBTZUSD":{"wsname":"XBT/USD","status":"online"},
+		"BTTUSD":{"wsname":"BTT/USD","status":"online"},
+		"BATUSD":{"wsname":"BAT/USD","status":"online"},
+		"BCHUSD":{"wsname":"BCH/USD","status":"cancel_only"},
+		"BLZEUR":{"wsname":"BLZ/EUR","status":"online"},
+		"XETHZUSD":{"wsname":"ETH/USD","status":"online"}}}`)
+
+	t.Run("prefix", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-all", `{"prefix":"b"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"quote":"USD","coins":["BAT","BTC","BTT"],"skipped":[],"rejected":[]}`, w.Body.String())
+		assert.Equal(t, []string{"BAT", "BTC", "BTT"}, s.added)
+	})
+
+	t.Run("cap reached", func(t *testing.T) {
+		s := &fakeStorage{maxAdds: 2}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-all", `{"prefix":"b"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"quote":"USD","coins":["BAT","BTC"],"skipped":[],
+			"rejected":[{"coin":"BTT","reason":"too many tracked coins"}]}`, w.Body.String())
+		assert.Equal(t, []string{"BAT", "BTC"}, s.added)
+	})
+
+	t.Run("already tracked", func(t *testing.T) {
+		s := &fakeStorage{tracked: []string{"BTC"}}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-all", `{"prefix":"b","limit":2}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"quote":"USD","coins":["BAT","BTT"],"skipped":["BTC"],"rejected":[]}`, w.Body.String())
+		assert.Equal(t, []string{"BAT", "BTT"}, s.added)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-all", `{"limit":2}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"BAT", "BTC"}, s.added)
+	})
+
+	t.Run("limit above the cap", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-all", `{"limit":101}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Empty(t, s.added)
+	})
+}
+
+func TestQuoteCurrencies(t *testing.T) {
+	useAssetPairs(t, `{"error":[],"result":{
+		"XXBTZUSD":{"wsname":"XBT/USD","status":"online","pair_decimals":1},
+		"XXBTZEUR":{"wsname":"XBT/EUR","status":"online","pair_decimals":1},
+		"XDGEUR":{"wsname":"XDG/EUR","status":"online","pair_decimals":7}}}`)
+
+	t.Run("add in another quote", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add", `{"coin":"DOGE","quote":"eur","depth":true}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"DOGE/EUR"}, s.added)
+		assert.Equal(t, []string{"DOGE/EUR"}, s.depth)
+	})
+
+	t.Run("add in the configured quote", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add", `{"coin":"BTC","quote":"USD"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"BTC"}, s.added)
+	})
+
+	t.Run("unsupported pair", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add", `{"coin":"DOGE","quote":"GBP"}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, s.added)
+	})
+
+	t.Run("price", func(t *testing.T) {
+		s := &fakeStorage{price: 45123.456, source: storage.SourceCache}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/price", `{"coin":"BTC","quote":"EUR","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"EUR","price":45123.5,"timestamp":1736500490,"requested_timestamp":1736500490,"source":"cache"}`, w.Body.String())
+		assert.Equal(t, "BTC/EUR", s.priceCoin)
+	})
+
+	t.Run("query and remove a pair in another quote", func(t *testing.T) {
+		s := &fakeStorage{points: []models.PricePoint{{Price: 0.25, Timestamp: 1736500490}}}
+		r := newTestRouter(s)
+		w := doJSON(r, http.MethodPost, "/currency/add", `{"coin":"DOGE","quote":"EUR"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = doJSON(r, http.MethodPost, "/currency/range", `{"coin":"doge/eur","from":1736500000,"to":1736501000}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"quote":"EUR"`)
+		assert.Equal(t, "DOGE/EUR", s.priceCoin)
+
+		w = doJSON(r, http.MethodPost, "/currency/remove", `{"coin":"DOGE/EUR"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"DOGE/EUR"}, s.removed)
+
+		// Its history can still be queried once it is no longer tracked
+		s.priceCoin = ""
+		w = doJSON(r, http.MethodPost, "/currency/range", `{"coin":"DOGE/EUR","from":1736500000,"to":1736501000}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "DOGE/EUR", s.priceCoin)
+	})
+
+	t.Run("pair in the configured quote", func(t *testing.T) {
+		s := &fakeStorage{tracked: []string{"BTC"}}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/remove", `{"coin":"btc/usd"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"BTC"}, s.removed)
+	})
+
+	t.Run("invalid quote", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/remove", `{"coin":"BTC/E-R"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"error":"quote must be alphanumeric, got \"E-R\""}`, w.Body.String())
+		assert.Empty(t, s.removed)
+	})
+}
+
+func TestAddCurrencyUnhealthy(t *testing.T) {
+	useAssetPairs(t, `{"error":[],"result":{"XXBTZUSD":{"wsname":"XBT/USD","status":"online"}}}`)
+
+	unhealthy := fmt.Errorf("storage.AddCurrency: %w", storage.ErrUnhealthy)
+	w := doJSON(newTestRouter(&fakeStorage{addErr: unhealthy}), http.MethodPost, "/currency/add", `{"coin":"BTC"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"error":"storage unavailable"}`, w.Body.String())
+
+	tooMany := fmt.Errorf("storage.AddCurrency: %w (100)", storage.ErrTooManyCoins)
+	w = doJSON(newTestRouter(&fakeStorage{addErr: tooMany}), http.MethodPost, "/currency/add", `{"coin":"BTC"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"too many tracked coins"}`, w.Body.String())
+
+	w = doJSON(newTestRouter(&fakeStorage{addErr: errors.New("boom")}), http.MethodPost, "/currency/add", `{"coin":"BTC"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+// Test adds and the ticker fail with 503 instead of 404 while the Kraken pairs could not be loaded
+func TestAddCurrencyPairsUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":["EService:Unavailable"],"result":{}}`))
+	}))
+	defer srv.Close()
+	kraken_api.Configure(models.KrakenCfg{BaseURL: srv.URL})
+	defer kraken_api.Configure(models.KrakenCfg{BaseURL: kraken_api.DefaultBaseURL})
+	require.Error(t, kraken_api.RefreshPairs(context.Background()))
+
+	s := &fakeStorage{}
+	for path, body := range map[string]string{
+		"/currency/add":       `{"coin":"BTC"}`,
+		"/currency/add-batch": `{"coins":["BTC"]}`,
+		"/currency/add-all":   `{}`,
+	} {
+		w := doJSON(newTestRouter(s), http.MethodPost, path, body)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+		assert.JSONEq(t, `{"error":"currency pairs unavailable"}`, w.Body.String(), path)
+	}
+
+	ticker := func(context.Context, string, string) (models.Ticker, error) { return models.Ticker{}, nil }
+	w := doJSON(newTickerRouter(ticker), http.MethodPost, "/currency/ticker", `{"coin":"BTC"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"error":"currency pairs unavailable"}`, w.Body.String())
+
+	// Once Kraken answers, an unlisted coin is not supported
+	useAssetPairs(t, `{"error":[],"result":{"XXBTZUSD":{"wsname":"XBT/USD","status":"online"}}}`)
+	w = doJSON(newTestRouter(s), http.MethodPost, "/currency/add", `{"coin":"DOGE"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestNormalizeCoin(t *testing.T) {
+	useAssetPairs(t, `{"error":[],"result":{"XXBTZUSD":{"wsname":"XBT/USD","status":"online"}}}`)
+
+	t.Run("normalized", func(t *testing.T) {
+		s := &fakeStorage{price: 50000, source: storage.SourceDB}
+		r := newTestRouter(s)
+
+		w := doJSON(r, http.MethodPost, "/currency/add", `{"coin":" btc "}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"BTC"}, s.added)
+
+		w = doJSON(r, http.MethodPost, "/currency/price", `{"coin":"btc","timestamp":1736500490}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "BTC", s.priceCoin)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"USD","price":50000,"timestamp":1736500490,"requested_timestamp":1736500490,"source":"db"}`, w.Body.String())
+
+		w = doJSON(r, http.MethodPost, "/currency/remove", `{"coin":"Btc"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"BTC"}, s.removed)
+
+		w = doJSON(r, http.MethodPost, "/currency/remove", `{"coin":"ETH"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":"currency not tracked"}`, w.Body.String())
+
+		s.removeErr = errors.New("connection refused")
+		w = doJSON(r, http.MethodPost, "/currency/remove", `{"coin":"BTC"}`)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"error":"failed to remove currency"}`, w.Body.String())
+	})
+
+	tests := []struct {
+		name string
+		coin string
+		want string
+	}{
+		{"blank", `"   "`, "coin must not be empty"},
+		{"too long", `"ABCDEFGHIJKLMNOPQ"`, "coin must be at most 16 characters"},
+		{"symbols", `"BTC;DROP"`, `coin must be alphanumeric, got \"BTC;DROP\"`},
+		{"inner space", `"B TC"`, `coin must be alphanumeric, got \"B TC\"`},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/currency/add", "/currency/remove", "/currency/price"} {
+			t.Run(tt.name+" "+path, func(t *testing.T) {
+				s := &fakeStorage{price: 50000}
+				w := doJSON(newTestRouter(s), http.MethodPost, path, `{"coin":`+tt.coin+`}`)
+
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				assert.JSONEq(t, `{"error":"`+tt.want+`"}`, w.Body.String())
+				assert.Empty(t, s.added)
+				assert.Empty(t, s.removed)
+				assert.Empty(t, s.priceCoin)
+			})
+		}
+	}
+}
+
+func TestGetPriceBeforeFirst(t *testing.T) {
+	cfg := models.Config{QueryConf: models.QueryCfg{RejectBeforeFirst: true}}
+	s := &fakeStorage{price: 50000, source: storage.SourceDB, earliest: 1736400000}
+
+	t.Run("before tracking", func(t *testing.T) {
+		r := newTestRouterWithConfig(s, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736300000}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":"no data before the first stored price","earliest":1736400000}`, w.Body.String())
+	})
+
+	t.Run("after first price", func(t *testing.T) {
+		r := newTestRouterWithConfig(s, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("nothing stored", func(t *testing.T) {
+		r := newTestRouterWithConfig(&fakeStorage{err: storage.ErrNotFound}, cfg)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736300000}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":"price not found"}`, w.Body.String())
+	})
+
+	t.Run("storage down", func(t *testing.T) {
+		down := &fakeStorage{price: 50000, earliestErr: fmt.Errorf("storage.CheckEarliest: %w: connection refused", storage.ErrBackend)}
+		w := doJSON(newTestRouterWithConfig(down, cfg), http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736300000}`)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"error":"storage unavailable"}`, w.Body.String())
+		assert.Empty(t, down.priceCoin)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := newTestRouter(s)
+		w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736300000}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestGetPriceMatch(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		s := &fakeStorage{price: 50000, source: storage.SourceDB}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, storage.NearestMatch{}, s.match)
+	})
+
+	t.Run("selected", func(t *testing.T) {
+		s := &fakeStorage{price: 50000, source: storage.SourceDB}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490,"match":"interpolate"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, storage.Interpolate{}, s.match)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		s := &fakeStorage{price: 50000, source: storage.SourceDB}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/price", `{"coin":"BTC","timestamp":1736500490,"match":"closest"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Nil(t, s.match)
+	})
+}
+
+func TestGetPriceDecimals(t *testing.T) {
+	useAssetPairs(t, `{"error":[],"result":{
+		"XXBTZUSD":{"wsname":"XBT/USD","status":"online","pair_decimals":1},
+		"SHIBUSD":{"wsname":"SHIB/USD","status":"online","pair_decimals":8}}}`)
+
+	tests := []struct {
+		name string
+		coin string
+		cfg  models.Config
+		want float64
+	}{
+		{"BTC", "BTC", models.Config{}, 48523.5},
+		{"SHIB", "SHIB", models.Config{}, 0.00001235},
+		{"configured", "BTC", models.Config{QueryConf: models.QueryCfg{PriceDecimals: map[string]int{"BTC": 3}}}, 48523.457},
+		{"unknown coin", "ABC", models.Config{}, 1.23456789},
+	}
+	prices := map[string]float64{"BTC": 48523.4567, "SHIB": 0.0000123456789, "ABC": 1.23456789}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRouterWithConfig(&fakeStorage{price: prices[tt.coin], source: storage.SourceDB}, tt.cfg)
+			w := doJSON(r, http.MethodPost, "/currency/price", `{"coin":"`+tt.coin+`","timestamp":1736500490}`)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			var resp models.PriceResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.want, resp.Price)
+		})
+	}
+}
+
+func TestAddBatch(t *testing.T) {
+	useAssetPairs(t, `{"error":[],"result":{
+		"XXBTZUSD":{"wsname":"XBT/USD","status":"online"},
+		"XETHZUSD":{"wsname":"ETH/USD","status":"online"},
+		"SOLUSD":{"wsname":"SOL/USD","status":"online"}}}`)
+
+	t.Run("partial success", func(t *testing.T) {
+		s := &fakeStorage{tracked: []string{"ETH"}}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-batch", `{"coins":["BTC","ETH","FOO","SOL","BTC"]}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"added":["BTC","SOL"],"already_tracked":["ETH"],"unsupported":["FOO"],"rejected":[]}`, w.Body.String())
+		assert.Equal(t, []string{"BTC", "SOL"}, s.added)
+	})
+
+	t.Run("any casing", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-batch", `{"coins":["btc"," BTC ","b-t-c"]}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"added":["BTC"],"already_tracked":[],"unsupported":["b-t-c"],"rejected":[]}`, w.Body.String())
+	})
+
+	t.Run("nothing supported", func(t *testing.T) {
+		s := &fakeStorage{}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-batch", `{"coins":["FOO"]}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"added":[],"already_tracked":[],"unsupported":["FOO"],"rejected":[]}`, w.Body.String())
+	})
+
+	t.Run("storage down", func(t *testing.T) {
+		s := &fakeStorage{addErr: storage.ErrUnhealthy}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-batch", `{"coins":["BTC"]}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"added":[],"already_tracked":[],"unsupported":[],
+			"rejected":[{"coin":"BTC","reason":"storage unavailable"}]}`, w.Body.String())
+	})
+
+	// Coins added before the cap was reached stay added and are reported
+	t.Run("cap reached", func(t *testing.T) {
+		s := &fakeStorage{maxAdds: 1}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/add-batch", `{"coins":["BTC","ETH","SOL"]}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"added":["BTC"],"already_tracked":[],"unsupported":[],"rejected":[
+			{"coin":"ETH","reason":"too many tracked coins"},{"coin":"SOL","reason":"too many tracked coins"}]}`, w.Body.String())
+		assert.Equal(t, []string{"BTC"}, s.added)
+	})
+
+	t.Run("empty list", func(t *testing.T) {
+		w := doJSON(newTestRouter(&fakeStorage{}), http.MethodPost, "/currency/add-batch", `{"coins":[]}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// Test every endpoint taking a coin accepts it in any casing and rejects invalid symbols
+func TestCoinNormalized(t *testing.T) {
+	bodies := map[string]string{
+		"/currency/depth":      `{"coin":" btc ","timestamp":1736500490}`,
+		"/currency/twap-decay": `{"coin":" btc ","window":"1h"}`,
+		"/currency/compare":    `{"coin":" btc ","timestamp":1736500490}`,
+		"/currency/range":      `{"coin":" btc ","from":1736500000,"to":1736500490}`,
+		"/currency/ohlc":       `{"coin":" btc ","from":1736496890,"to":1736500490,"interval":"1h"}`,
+	}
+	for path, body := range bodies {
+		s := &fakeStorage{price: 50000}
+		r := newTestRouter(s)
+
+		w := doJSON(r, http.MethodPost, path, body)
+		assert.NotEqual(t, http.StatusBadRequest, w.Code, path)
+		assert.Equal(t, "BTC", s.priceCoin, path)
+
+		w = doJSON(r, http.MethodPost, path, strings.Replace(body, " btc ", "BTC;ETH", 1))
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+
+	t.Run("portfolio", func(t *testing.T) {
+		s := &fakeStorage{price: 50000}
+		w := doJSON(newTestRouter(s), http.MethodPost, "/currency/portfolio", `{"holdings":{"btc":0.5,"BTC":0.5},"timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"quote":"USD","timestamp":1736500490,"total":50000,"holdings":[
+			{"coin":"BTC","amount":1,"price":50000,"value":50000,"timestamp":1736500490}],"missing":[]}`, w.Body.String())
+	})
+}
+
+func TestHotCoins(t *testing.T) {
+	s := &fakeStorage{hot: []models.HotCoin{{Coin: "BTC", Accesses: 12}, {Coin: "ETH", Accesses: 3}}}
+	r := newTestRouter(s)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/currency/hot", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"coins":[{"coin":"BTC","accesses":12},{"coin":"ETH","accesses":3}]}`, w.Body.String())
+	assert.Equal(t, 10, s.hotLimit)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/currency/hot?limit=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, s.hotLimit)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/currency/hot?limit=1000", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetPriceRange(t *testing.T) {
+	r := newTestRouter(&fakeStorage{points: []models.PricePoint{
+		{Timestamp: 1736500000, Price: 50000},
+		{Timestamp: 1736500060, Price: 50010.5},
+	}})
+
+	w := doJSON(r, http.MethodPost, "/currency/range", `{"coin":"BTC","from":1736500000,"to":1736510000}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"coin":"BTC","quote":"USD","from":1736500000,"to":1736510000,"points":[
+		{"timestamp":1736500000,"price":50000},
+		{"timestamp":1736500060,"price":50010.5}]}`, w.Body.String())
+
+	// Paged
+	w = doJSON(r, http.MethodPost, "/currency/range", `{"coin":"BTC","from":1736500000,"to":1736510000,"limit":1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"coin":"BTC","quote":"USD","from":1736500000,"to":1736510000,"points":[
+		{"timestamp":1736500000,"price":50000}],"next":1}`, w.Body.String())
+
+	w = doJSON(r, http.MethodPost, "/currency/range", `{"coin":"BTC","from":1736500000,"to":1736510000,"limit":1,"offset":1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"coin":"BTC","quote":"USD","from":1736500000,"to":1736510000,"points":[
+		{"timestamp":1736500060,"price":50010.5}]}`, w.Body.String())
+
+	w = doJSON(r, http.MethodPost, "/currency/range", `{"coin":"BTC","from":1736510000,"to":1736500000}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doJSON(r, http.MethodPost, "/currency/range", `{"coin":"BTC","from":1736500000,"to":1736510000,"offset":-1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doJSON(newTestRouter(&fakeStorage{err: errors.New("db down")}), http.MethodPost, "/currency/range",
+		`{"coin":"BTC","from":1736500000,"to":1736510000}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGetOHLC(t *testing.T) {
+	s := &fakeStorage{candles: []models.Candle{
+		{Timestamp: 1736500200, Open: 50000, High: 50100, Low: 49900, Close: 50050},
+	}}
+	r := newTestRouter(s)
+
+	w := doJSON(r, http.MethodPost, "/currency/ohlc", `{"coin":"BTC","from":1736500200,"to":1736503800,"interval":"5m"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"coin":"BTC","quote":"USD","from":1736500200,"to":1736503800,"interval":"5m0s","candles":[
+		{"timestamp":1736500200,"open":50000,"high":50100,"low":49900,"close":50050}]}`, w.Body.String())
+	assert.Equal(t, int64(300), s.interval)
+
+	// No data is an empty list
+	w = doJSON(newTestRouter(&fakeStorage{candles: []models.Candle{}}), http.MethodPost, "/currency/ohlc",
+		`{"coin":"BTC","from":1736500200,"to":1736503800,"interval":"60s"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"candles":[]`)
+
+	for _, body := range []string{
+		`{"coin":"BTC","from":1736500200,"to":1736503800,"interval":"0s"}`,
+		`{"coin":"BTC","from":1736500200,"to":1736503800,"interval":"-5m"}`,
+		`{"coin":"BTC","from":1736500200,"to":1736503800,"interval":"1500ms"}`,
+		`{"coin":"BTC","from":1736500200,"to":1736503800,"interval":"7m"}`,
+		`{"coin":"BTC","from":1736500200,"to":1736503800,"interval":"1s"}`,
+		`{"coin":"BTC","from":1736503800,"to":1736500200,"interval":"5m"}`,
+	} {
+		w = doJSON(r, http.MethodPost, "/currency/ohlc", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestPortfolio(t *testing.T) {
+	s := &fakeStorage{prices: map[string]float64{"BTC": 50000, "ETH": 3000}}
+	r := newTestRouter(s)
+
+	t.Run("full", func(t *testing.T) {
+		w := doJSON(r, http.MethodPost, "/currency/portfolio", `{"holdings":{"ETH":3,"BTC":0.5},"timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"quote":"USD","timestamp":1736500490,"total":34000,"holdings":[
+			{"coin":"BTC","amount":0.5,"price":50000,"value":25000,"timestamp":1736500490},
+			{"coin":"ETH","amount":3,"price":3000,"value":9000,"timestamp":1736500490}],"missing":[]}`, w.Body.String())
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		w := doJSON(r, http.MethodPost, "/currency/portfolio", `{"holdings":{"BTC":0.5,"SOL":10},"timestamp":1736500490}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"quote":"USD","timestamp":1736500490,"total":25000,"holdings":[
+			{"coin":"BTC","amount":0.5,"price":50000,"value":25000,"timestamp":1736500490}],"missing":["SOL"]}`, w.Body.String())
+	})
+
+	t.Run("partial strict", func(t *testing.T) {
+		w := doJSON(r, http.MethodPost, "/currency/portfolio", `{"holdings":{"BTC":0.5,"SOL":10},"strict":true}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":"price not found for SOL"}`, w.Body.String())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, body := range []string{`{"holdings":{}}`, `{"holdings":{"BTC":-1}}`, `{}`} {
+			w := doJSON(r, http.MethodPost, "/currency/portfolio", body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+	})
+}
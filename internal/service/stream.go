@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"test-task1/internal/storage"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"test-task1/models"
+)
+
+// streamWriteTimeout bounds sending one update to a streaming client.
+const streamWriteTimeout = 10 * time.Second
+
+// StreamStore publishes the prices collected for tracked coins.
+type StreamStore interface {
+	Subscribe(coin string) (<-chan models.PriceUpdate, func(), error)
+	LastPriceUpdate(coin string) (models.PriceUpdate, bool)
+}
+
+type StreamHandler struct {
+	storage  StreamStore
+	upgrader websocket.Upgrader
+}
+
+func NewStreamHandler(storage StreamStore) *StreamHandler {
+	return &StreamHandler{storage: storage}
+}
+
+// Stream godoc
+// @Summary Stream live prices
+// @Description Upgrades to a WebSocket that receives the latest collected price of the coin right away and
+// @Description then every new one as a models.PriceUpdate JSON message. The client needs to send nothing.
+// @Description If the coin is not tracked the connection is closed with code 1008 and the reason; once the
+// @Description coin is removed or the server shuts down it is closed with code 1001.
+// @Description With change=true every update also carries its change from the previous one.
+// @Tags currency
+// @Param coin query string true "Coin symbol" example(BTC)
+// @Param change query bool false "Include change and change_percent" example(true)
+// @Success 101 {object} models.PriceUpdate
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /currency/stream [get]
+func (h *StreamHandler) Stream(c *gin.Context) {
+	coin, err := normalizeSymbol(c.Query("coin"))
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	withChange := false
+	if v := c.Query("change"); v != "" {
+		if withChange, err = strconv.ParseBool(v); err != nil {
+			respond(c, http.StatusBadRequest, models.ErrorResponse{Error: "change must be true or false"})
+			return
+		}
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // the upgrader has answered with an HTTP error
+	}
+	defer conn.Close()
+
+	updates, unsubscribe, err := h.storage.Subscribe(coin)
+	if errors.Is(err, storage.ErrNotTracked) {
+		closeStream(conn, websocket.ClosePolicyViolation, "coin is not tracked")
+		return
+	}
+	if err != nil {
+		closeStream(conn, websocket.CloseInternalServerErr, "failed to subscribe")
+		return
+	}
+	defer unsubscribe()
+
+	// Clients send nothing; reading only notices when they go away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if update, ok := h.storage.LastPriceUpdate(coin); ok {
+		if err := h.send(c, conn, update, withChange); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				closeStream(conn, websocket.CloseGoingAway, "coin is no longer tracked")
+				return
+			}
+			if err := h.send(c, conn, update, withChange); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// send writes update as a JSON message in the configured casing, leaving
+// out its change unless the client subscribed to it.
+func (h *StreamHandler) send(c *gin.Context, conn *websocket.Conn, update models.PriceUpdate, withChange bool) error {
+	if !withChange {
+		update.Change, update.ChangePercent = nil, nil
+	}
+	v, err := casedValue(c, update)
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	return conn.WriteJSON(v)
+}
+
+// closeStream sends a close frame with code and reason.
+func closeStream(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteTimeout))
+}
@@ -0,0 +1,156 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	handlers "test-task1/internal/service"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// fakeStream publishes the updates sent to its channel for the tracked coin
+type fakeStream struct {
+	coin    string
+	last    *models.PriceUpdate
+	updates chan models.PriceUpdate
+}
+
+func (f *fakeStream) Subscribe(coin string) (<-chan models.PriceUpdate, func(), error) {
+	if coin != f.coin {
+		return nil, nil, fmt.Errorf("storage.Subscribe: %w: %s", storage.ErrNotTracked, coin)
+	}
+	return f.updates, func() {}, nil
+}
+
+func (f *fakeStream) LastPriceUpdate(coin string) (models.PriceUpdate, bool) {
+	if f.last == nil || coin != f.coin {
+		return models.PriceUpdate{}, false
+	}
+	return *f.last, true
+}
+
+func newStreamServer(t *testing.T, s handlers.StreamStore, cfg models.Config) string {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(handlers.JSONCase(cfg.ServConf.JSONCase))
+	r.GET("/currency/stream", handlers.NewStreamHandler(s).Stream)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/currency/stream"
+}
+
+func dialStream(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
+
+func TestStream(t *testing.T) {
+	t.Run("updates", func(t *testing.T) {
+		change := 23.42
+		s := &fakeStream{
+			coin:    "BTC",
+			last:    &models.PriceUpdate{Coin: "BTC", Price: 48500, Timestamp: 1736500485},
+			updates: make(chan models.PriceUpdate, 1),
+		}
+		conn := dialStream(t, newStreamServer(t, s, models.Config{})+"?coin=btc&change=true")
+
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"coin":"BTC","price":48500,"timestamp":1736500485}`, string(msg))
+
+		s.updates <- models.PriceUpdate{Coin: "BTC", Price: 48523.42, Timestamp: 1736500490, Change: &change}
+		_, msg, err = conn.ReadMessage()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"coin":"BTC","price":48523.42,"timestamp":1736500490,"change":23.42}`, string(msg))
+
+		// The coin is removed
+		close(s.updates)
+		_, _, err = conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+		assert.Equal(t, "coin is no longer tracked", closeErr.Text)
+	})
+
+	t.Run("camel case", func(t *testing.T) {
+		percent := 0.048
+		s := &fakeStream{coin: "BTC", updates: make(chan models.PriceUpdate, 1)}
+		s.updates <- models.PriceUpdate{Coin: "BTC", Price: 48523.42, Timestamp: 1736500490, ChangePercent: &percent}
+		cfg := models.Config{ServConf: models.ServerCfg{JSONCase: models.JSONCaseCamel}}
+		conn := dialStream(t, newStreamServer(t, s, cfg)+"?coin=BTC&change=1")
+
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"coin":"BTC","price":48523.42,"timestamp":1736500490,"changePercent":0.048}`, string(msg))
+	})
+
+	t.Run("without change", func(t *testing.T) {
+		change, percent := 23.42, 0.048
+		s := &fakeStream{coin: "BTC", updates: make(chan models.PriceUpdate, 1)}
+		s.updates <- models.PriceUpdate{Coin: "BTC", Price: 48523.42, Timestamp: 1736500490, Change: &change, ChangePercent: &percent}
+		conn := dialStream(t, newStreamServer(t, s, models.Config{})+"?coin=BTC")
+
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"coin":"BTC","price":48523.42,"timestamp":1736500490}`, string(msg))
+	})
+
+	t.Run("not tracked", func(t *testing.T) {
+		s := &fakeStream{coin: "BTC"}
+		conn := dialStream(t, newStreamServer(t, s, models.Config{})+"?coin=ETH")
+
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+		assert.Equal(t, "coin is not tracked", closeErr.Text)
+	})
+
+	t.Run("compressed route", func(t *testing.T) {
+		s := &fakeStream{coin: "BTC", last: &models.PriceUpdate{Coin: "BTC", Price: 48500, Timestamp: 1736500485}}
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.Use(handlers.Compress([]string{models.EncodingGzip}, 0))
+		r.GET("/currency/stream", handlers.NewStreamHandler(s).Stream)
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		header := http.Header{"Accept-Encoding": []string{"gzip"}}
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/currency/stream?coin=BTC", header)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"coin":"BTC","price":48500,"timestamp":1736500485}`, string(msg))
+	})
+
+	t.Run("invalid coin", func(t *testing.T) {
+		s := &fakeStream{coin: "BTC"}
+		_, resp, err := websocket.DefaultDialer.Dial(newStreamServer(t, s, models.Config{})+"?coin=BTC;ETH", nil)
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("invalid change", func(t *testing.T) {
+		s := &fakeStream{coin: "BTC"}
+		_, resp, err := websocket.DefaultDialer.Dial(newStreamServer(t, s, models.Config{})+"?coin=BTC&change=maybe", nil)
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
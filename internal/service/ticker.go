@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"test-task1/models"
+	kraken_api "test-task1/pkg/kraken-api"
+)
+
+// TickerSource fetches the live ticker of the coin in quote, e.g. kraken_api.GetTicker.
+type TickerSource func(ctx context.Context, coin, quote string) (models.Ticker, error)
+
+type TickerHandler struct {
+	ticker TickerSource
+}
+
+func NewTickerHandler(ticker TickerSource) *TickerHandler {
+	return &TickerHandler{ticker: ticker}
+}
+
+// Ticker godoc
+// @Summary Get the live ticker of a cryptocurrency
+// @Description Returns the last trade price, the best bid and ask, their spread and the 24-hour volume as Kraken reports them now.
+// @Description quote selects the pair, by default the configured quote currency.
+// @Description 503 is returned while the Kraken pairs could not be loaded yet.
+// @Tags currency
+// @Accept json
+// @Produce json
+// @Param input body models.TickerRequest true "Request parameters"
+// @Success 200 {object} models.TickerResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /currency/ticker [post]
+func (h *TickerHandler) Ticker(c *gin.Context) {
+	var req models.TickerRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !requirePairs(c) {
+		return
+	}
+	coin, err := normalizeCoin(req.Coin)
+	if err != nil {
+		respond(c, http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	symbol := kraken_api.Symbol(coin, req.Quote)
+	if !kraken_api.IsSupported(symbol) {
+		respond(c, http.StatusNotFound, models.ErrorResponse{Error: "currency not supported"})
+		return
+	}
+
+	ticker, err := h.ticker(c.Request.Context(), coin, req.Quote)
+	if err != nil {
+		respond(c, http.StatusBadGateway, models.ErrorResponse{Error: "failed to fetch ticker"})
+		return
+	}
+
+	_, quote := kraken_api.SplitSymbol(symbol)
+	respond(c, http.StatusOK, models.TickerResponse{
+		Coin:   coin,
+		Quote:  quote,
+		Last:   ticker.Last,
+		Bid:    ticker.Bid,
+		Ask:    ticker.Ask,
+		Spread: ticker.Ask - ticker.Bid,
+		Volume: ticker.Volume,
+	})
+}
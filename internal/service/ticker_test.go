@@ -0,0 +1,71 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	handlers "test-task1/internal/service"
+	"test-task1/models"
+)
+
+func newTickerRouter(ticker handlers.TickerSource) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/currency/ticker", handlers.NewTickerHandler(ticker).Ticker)
+	return r
+}
+
+func TestTicker(t *testing.T) {
+	useAssetPairs(t, `{"error":[],"result":{
+		"XXBTZUSD":{"wsname":"XBT/USD","status":"online"},
+		"XXBTZEUR":{"wsname":"XBT/EUR","status":"online"}}}`)
+
+	var gotCoin, gotQuote string
+	ticker := func(_ context.Context, coin, quote string) (models.Ticker, error) {
+		gotCoin, gotQuote = coin, quote
+		return models.Ticker{Last: 50000, Bid: 49999.5, Ask: 50000.5, Volume: 1200}, nil
+	}
+
+	t.Run("default quote", func(t *testing.T) {
+		w := doJSON(newTickerRouter(ticker), http.MethodPost, "/currency/ticker", `{"coin":" btc "}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"coin":"BTC","quote":"USD","last":50000,"bid":49999.5,"ask":50000.5,"spread":1,"volume":1200}`, w.Body.String())
+		assert.Equal(t, "BTC", gotCoin)
+		assert.Equal(t, "", gotQuote)
+	})
+
+	t.Run("other quote", func(t *testing.T) {
+		w := doJSON(newTickerRouter(ticker), http.MethodPost, "/currency/ticker", `{"coin":"BTC","quote":"EUR"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"quote":"EUR"`)
+		assert.Equal(t, "EUR", gotQuote)
+	})
+
+	t.Run("unsupported coin", func(t *testing.T) {
+		w := doJSON(newTickerRouter(ticker), http.MethodPost, "/currency/ticker", `{"coin":"DOGE"}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("invalid coin", func(t *testing.T) {
+		w := doJSON(newTickerRouter(ticker), http.MethodPost, "/currency/ticker", `{"coin":"BTC-USD"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("kraken unavailable", func(t *testing.T) {
+		failing := func(context.Context, string, string) (models.Ticker, error) {
+			return models.Ticker{}, errors.New("connection refused")
+		}
+		w := doJSON(newTickerRouter(failing), http.MethodPost, "/currency/ticker", `{"coin":"BTC"}`)
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.JSONEq(t, `{"error":"failed to fetch ticker"}`, w.Body.String())
+	})
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// timeoutBody is the ErrorResponse sent when a request exceeds its deadline.
+const timeoutBody = `{"error":"request timed out"}`
+
+// TimeoutHandler bounds every request to d. The deadline is visible to the
+// handlers through the request context; if the handler has not finished when
+// it expires, the client gets 503 with a JSON ErrorResponse even when a
+// downstream call ignores the context. Requests whose path starts with one of
+// skipPrefixes (long-lived streaming routes) are not bounded. A non-positive
+// d disables the timeout.
+func TimeoutHandler(h http.Handler, d time.Duration, skipPrefixes ...string) http.Handler {
+	if d <= 0 {
+		return h
+	}
+
+	bounded := http.TimeoutHandler(h, d, timeoutBody)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range skipPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// Only survives when the timeout fires: on success the handler's
+		// own headers replace it
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		bounded.ServeHTTP(w, r)
+	})
+}
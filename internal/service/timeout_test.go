@@ -0,0 +1,53 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	handlers "test-task1/internal/service"
+)
+
+func TestTimeoutHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	slow := func(c *gin.Context) {
+		time.Sleep(200 * time.Millisecond) // ignores the request context
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+	r.GET("/slow", slow)
+	r.GET("/stream/slow", slow)
+	r.GET("/fast", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	h := handlers.TimeoutHandler(r, 50*time.Millisecond, "/stream")
+
+	serve := func(path string) (*httptest.ResponseRecorder, time.Duration) {
+		w := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w, time.Since(start)
+	}
+
+	t.Run("slow handler times out", func(t *testing.T) {
+		w, elapsed := serve("/slow")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"error":"request timed out"}`, w.Body.String())
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+		assert.Less(t, elapsed, 150*time.Millisecond)
+	})
+
+	t.Run("fast handler", func(t *testing.T) {
+		w, _ := serve("/fast")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"ok":true}`, w.Body.String())
+	})
+
+	t.Run("streaming routes are excluded", func(t *testing.T) {
+		w, _ := serve("/stream/slow")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
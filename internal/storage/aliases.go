@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// MergeCoins re-labels the historical rows of oldCoin as newCoin in one
+// transaction and records oldCoin as an alias, so queries for either symbol
+// return the merged history.
+// Returns the number of re-labeled price rows.
+func (s *Storage) MergeCoins(oldCoin, newCoin string) (int64, error) {
+	const op = "storage.MergeCoins"
+	if s.cacheOnly() {
+		return 0, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE currencies SET coin = $1 WHERE coin = $2", newCoin, oldCoin)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+
+	if _, err = tx.Exec("UPDATE depth_snapshots SET coin = $1 WHERE coin = $2", newCoin, oldCoin); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	if _, err = tx.Exec("UPDATE exchange_prices SET coin = $1 WHERE coin = $2", newCoin, oldCoin); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+
+	// Aliases of the old symbol follow it to the new one
+	if _, err = tx.Exec("UPDATE coin_aliases SET coin = $1 WHERE coin = $2", newCoin, oldCoin); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	if _, err = tx.Exec(`
+		INSERT INTO coin_aliases (alias, coin) VALUES ($1, $2)
+		ON CONFLICT (alias) DO UPDATE SET coin = EXCLUDED.coin`,
+		oldCoin, newCoin,
+	); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+
+	s.mutex.Lock()
+	if s.aliases == nil {
+		s.aliases = make(map[string]string)
+	}
+	for alias, coin := range s.aliases {
+		if coin == oldCoin {
+			s.aliases[alias] = newCoin
+		}
+	}
+	s.aliases[oldCoin] = newCoin
+	s.mutex.Unlock()
+
+	// Cached points of the old symbol would shadow the merged history
+	if !s.cacheDisabled() {
+		s.Redis.Del(context.Background(), fmt.Sprintf("token:%s", oldCoin))
+	}
+	s.purgeMemCache()
+
+	return rows, nil
+}
+
+// loadAliases reads the persisted coin aliases into memory.
+func (s *Storage) loadAliases() error {
+	rows, err := s.DB.Query("SELECT alias, coin FROM coin_aliases")
+	if err != nil {
+		return fmt.Errorf("storage.loadAliases: %v", err)
+	}
+	defer rows.Close()
+
+	aliases := make(map[string]string)
+	for rows.Next() {
+		var alias, coin string
+		if err := rows.Scan(&alias, &coin); err != nil {
+			return fmt.Errorf("storage.loadAliases: %v", err)
+		}
+		aliases[alias] = coin
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("storage.loadAliases: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.aliases = aliases
+	s.mutex.Unlock()
+	return nil
+}
+
+// resolveCoin returns the symbol the coin's history is stored under.
+func (s *Storage) resolveCoin(coin string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if target, ok := s.aliases[coin]; ok {
+		return target
+	}
+	return coin
+}
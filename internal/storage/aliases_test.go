@@ -0,0 +1,80 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+)
+
+func TestMergeCoins(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{DB: db, Redis: rdb}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE currencies SET coin = $1 WHERE coin = $2").
+		WithArgs("BTC", "XBT").
+		WillReturnResult(sqlmock.NewResult(0, 42))
+	mock.ExpectExec("UPDATE depth_snapshots SET coin = $1 WHERE coin = $2").
+		WithArgs("BTC", "XBT").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE exchange_prices SET coin = $1 WHERE coin = $2").
+		WithArgs("BTC", "XBT").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE coin_aliases SET coin = $1 WHERE coin = $2").
+		WithArgs("BTC", "XBT").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`
+		INSERT INTO coin_aliases (alias, coin) VALUES ($1, $2)
+		ON CONFLICT (alias) DO UPDATE SET coin = EXCLUDED.coin`).
+		WithArgs("XBT", "BTC").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rows, err := mockStorage.MergeCoins("XBT", "BTC")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), rows)
+
+	// Queries for the old symbol now read the history stored under the new one
+	testTime := time.Now().Unix()
+	mock.ExpectQuery(`
+		SELECT price, timestamp 
+		FROM currencies 
+		WHERE coin = $1 
+		ORDER BY ABS(timestamp - $2) 
+		LIMIT 1`).
+		WithArgs("BTC", testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, testTime))
+
+	point, _, err := mockStorage.GetPrice(context.Background(), "XBT", testTime)
+	require.NoError(t, err)
+	assert.Equal(t, 50000.0, point.Price)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMergeCoinsRollback(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE currencies SET coin = $1 WHERE coin = $2").
+		WithArgs("BTC", "XBT").
+		WillReturnError(errors.New("deadlock detected"))
+	mock.ExpectRollback()
+
+	_, err = mockStorage.MergeCoins("XBT", "BTC")
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"test-task1/internal/metrics"
+	"test-task1/models"
+)
+
+// getRange returns the coin's stored prices in [from, to] ordered by time.
+func (s *Storage) getRange(coin string, from, to int64) ([]models.PricePoint, error) {
+	if s.cacheOnly() {
+		return s.getRangeFromCache(coin, from, to)
+	}
+	defer metrics.TimeDBQuery(metrics.QueryRange).ObserveDuration()
+	rows, err := s.DB.Query(`
+		SELECT timestamp, price
+		FROM currencies
+		WHERE coin = $1 AND timestamp BETWEEN $2 AND $3
+		ORDER BY timestamp`,
+		coin, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []models.PricePoint
+	for rows.Next() {
+		var p models.PricePoint
+		if err := rows.Scan(&p.Timestamp, &p.Price); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// ErrNoDataBefore is returned by CheckEarliest for timestamps before the
+// coin's first stored price.
+var ErrNoDataBefore = errors.New("no data before the first stored price")
+
+// CheckEarliest returns the timestamp of the coin's first stored price and
+// ErrNoDataBefore if the timestamp precedes it, i.e. no data could exist
+// for it. sql.ErrNoRows is returned if nothing of the coin is stored and
+// ErrBackend if the database could not be queried.
+func (s *Storage) CheckEarliest(ctx context.Context, coin string, timestamp int64) (int64, error) {
+	const op = "storage.CheckEarliest"
+	if s.cacheOnly() {
+		return 0, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+	coin = s.resolveCoin(coin)
+
+	defer metrics.TimeDBQuery(metrics.QueryEarliest).ObserveDuration()
+	var earliest sql.NullInt64
+	err := s.DB.QueryRowContext(ctx, "SELECT MIN(timestamp) FROM currencies WHERE coin = $1", coin).Scan(&earliest)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w: %v", op, ErrBackend, err)
+	}
+	if !earliest.Valid {
+		return 0, sql.ErrNoRows
+	}
+	if timestamp < earliest.Int64 {
+		return earliest.Int64, ErrNoDataBefore
+	}
+	return earliest.Int64, nil
+}
+
+// defaultMaxRangePoints caps GetPriceRange when query.max_range_points is unset.
+const defaultMaxRangePoints = 1000
+
+// GetPriceRange returns a page of the coin's stored prices in [from, to]
+// ordered by time.
+// Parameters:
+// - coin: the symbolic code of the cryptocurrency
+// - from, to: the window in the configured timestamp precision
+// - limit: the page size; 0 or more than query.max_range_points selects
+// query.max_range_points
+// - offset: how many points of the window to skip
+// Returns:
+// - points: the page of prices
+// - more: whether the window has points after the page
+// - error: error if the query failed
+func (s *Storage) GetPriceRange(coin string, from, to int64, limit, offset int) ([]models.PricePoint, bool, error) {
+	const op = "storage.GetPriceRange"
+	maxPoints := s.Config.QueryConf.MaxRangePoints
+	if maxPoints <= 0 {
+		maxPoints = defaultMaxRangePoints
+	}
+	if limit <= 0 || limit > maxPoints {
+		limit = maxPoints
+	}
+	coin = s.resolveCoin(coin)
+
+	if s.cacheOnly() {
+		points, err := s.getRangeFromCache(coin, from, to)
+		if err != nil {
+			return nil, false, err
+		}
+		points = points[min(offset, len(points)):]
+		if len(points) > limit {
+			return points[:limit], true, nil
+		}
+		return points, false, nil
+	}
+
+	// One extra row tells whether there is a next page
+	defer metrics.TimeDBQuery(metrics.QueryRange).ObserveDuration()
+	rows, err := s.DB.Query(`
+		SELECT timestamp, price
+		FROM currencies
+		WHERE coin = $1 AND timestamp BETWEEN $2 AND $3
+		ORDER BY timestamp
+		LIMIT $4 OFFSET $5`,
+		coin, from, to, limit+1, offset,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %v", op, err)
+	}
+	defer rows.Close()
+
+	points := make([]models.PricePoint, 0)
+	for rows.Next() {
+		var p models.PricePoint
+		if err := rows.Scan(&p.Timestamp, &p.Price); err != nil {
+			return nil, false, fmt.Errorf("%s: %v", op, err)
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("%s: %v", op, err)
+	}
+	if len(points) > limit {
+		return points[:limit], true, nil
+	}
+	return points, false, nil
+}
+
+// GetOHLC aggregates the coin's stored prices in [from, to] into candles of
+// interval, aligned to multiples of it, in time order. Buckets without prices
+// are left out, so an empty window returns an empty slice.
+// Parameters:
+// - coin: the symbolic code of the cryptocurrency
+// - from, to: the window in the configured timestamp precision
+// - interval: the candle length in the configured timestamp precision
+func (s *Storage) GetOHLC(coin string, from, to, interval int64) ([]models.Candle, error) {
+	const op = "storage.GetOHLC"
+	if interval <= 0 {
+		return nil, fmt.Errorf("%s: interval must be positive, got %d", op, interval)
+	}
+	if s.cacheOnly() {
+		return nil, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+	coin = s.resolveCoin(coin)
+
+	defer metrics.TimeDBQuery(metrics.QueryOHLC).ObserveDuration()
+	rows, err := s.DB.Query(`
+		SELECT timestamp / $4 * $4,
+			(ARRAY_AGG(price ORDER BY timestamp))[1],
+			MAX(price),
+			MIN(price),
+			(ARRAY_AGG(price ORDER BY timestamp DESC))[1]
+		FROM currencies
+		WHERE coin = $1 AND timestamp BETWEEN $2 AND $3
+		GROUP BY timestamp / $4
+		ORDER BY 1`,
+		coin, from, to, interval,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	defer rows.Close()
+
+	candles := make([]models.Candle, 0)
+	for rows.Next() {
+		var c models.Candle
+		if err := rows.Scan(&c.Timestamp, &c.Open, &c.High, &c.Low, &c.Close); err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+		candles = append(candles, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	return candles, nil
+}
+
+// GetDecayedAverage returns the exponentially time-weighted average price
+// over [from, to]. A point's weight halves every halfLife before to.
+// Parameters:
+// - coin: the symbolic code of the cryptocurrency
+// - from, to: the window in the configured timestamp precision
+// - halfLife: the half-life in the configured timestamp precision
+// Returns:
+// - price: the decayed average
+// - points: the number of points averaged
+// - error: sql.ErrNoRows if the window holds no data
+func (s *Storage) GetDecayedAverage(coin string, from, to, halfLife int64) (float64, int, error) {
+	points, err := s.getRange(s.resolveCoin(coin), from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(points) == 0 {
+		return 0, 0, sql.ErrNoRows
+	}
+	return decayedAverage(points, to, halfLife), len(points), nil
+}
+
+// decayedAverage weights each point by 0.5^((end-timestamp)/halfLife).
+func decayedAverage(points []models.PricePoint, end, halfLife int64) float64 {
+	var sum, weights float64
+	for _, p := range points {
+		w := math.Pow(0.5, float64(end-p.Timestamp)/float64(halfLife))
+		sum += w * p.Price
+		weights += w
+	}
+	return sum / weights
+}
@@ -0,0 +1,173 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+const rangeQuery = `
+		SELECT timestamp, price
+		FROM currencies
+		WHERE coin = $1 AND timestamp BETWEEN $2 AND $3
+		ORDER BY timestamp`
+
+func TestGetDecayedAverage(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{DB: db}
+
+	t.Run("hand-computed", func(t *testing.T) {
+		// With a half-life of 10s the weights are 0.25, 0.5 and 1:
+		// (0.25*100 + 0.5*200 + 1*400) / 1.75 = 300
+		mock.ExpectQuery(rangeQuery).
+			WithArgs("BTC", int64(980), int64(1000)).
+			WillReturnRows(sqlmock.NewRows([]string{"timestamp", "price"}).
+				AddRow(int64(980), 100.0).
+				AddRow(int64(990), 200.0).
+				AddRow(int64(1000), 400.0))
+
+		price, points, err := mockStorage.GetDecayedAverage("BTC", 980, 1000, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 3, points)
+		assert.InDelta(t, 300.0, price, 1e-9)
+	})
+
+	t.Run("empty window", func(t *testing.T) {
+		mock.ExpectQuery(rangeQuery).
+			WithArgs("BTC", int64(0), int64(10)).
+			WillReturnRows(sqlmock.NewRows([]string{"timestamp", "price"}))
+
+		_, _, err := mockStorage.GetDecayedAverage("BTC", 0, 10, 10)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPriceRange(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{
+		Config: models.Config{QueryConf: models.QueryCfg{MaxRangePoints: 2}},
+		DB:     db,
+	}
+
+	const pageQuery = rangeQuery + `
+		LIMIT $4 OFFSET $5`
+
+	// The limit is capped at max_range_points
+	mock.ExpectQuery(pageQuery).
+		WithArgs("BTC", int64(1000), int64(2000), 3, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"timestamp", "price"}).
+			AddRow(int64(1000), 100.0).
+			AddRow(int64(1060), 110.0).
+			AddRow(int64(1120), 120.0))
+
+	points, more, err := mockStorage.GetPriceRange("BTC", 1000, 2000, 50, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []models.PricePoint{{Timestamp: 1000, Price: 100}, {Timestamp: 1060, Price: 110}}, points)
+	assert.True(t, more)
+
+	// The last page
+	mock.ExpectQuery(pageQuery).
+		WithArgs("BTC", int64(1000), int64(2000), 2, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"timestamp", "price"}).
+			AddRow(int64(1120), 120.0))
+
+	points, more, err = mockStorage.GetPriceRange("BTC", 1000, 2000, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []models.PricePoint{{Timestamp: 1120, Price: 120}}, points)
+	assert.False(t, more)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOHLC(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{DB: db}
+	const ohlcQuery = `
+		SELECT timestamp / $4 * $4,
+			(ARRAY_AGG(price ORDER BY timestamp))[1],
+			MAX(price),
+			MIN(price),
+			(ARRAY_AGG(price ORDER BY timestamp DESC))[1]
+		FROM currencies
+		WHERE coin = $1 AND timestamp BETWEEN $2 AND $3
+		GROUP BY timestamp / $4
+		ORDER BY 1`
+	columns := []string{"bucket", "open", "high", "low", "close"}
+
+	mock.ExpectQuery(ohlcQuery).
+		WithArgs("BTC", int64(1200), int64(1800), int64(300)).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(int64(1200), 100.0, 110.0, 95.0, 105.0).
+			AddRow(int64(1500), 105.0, 106.0, 101.0, 102.0))
+
+	candles, err := mockStorage.GetOHLC("BTC", 1200, 1800, 300)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Candle{
+		{Timestamp: 1200, Open: 100, High: 110, Low: 95, Close: 105},
+		{Timestamp: 1500, Open: 105, High: 106, Low: 101, Close: 102},
+	}, candles)
+
+	// An empty window is not an error
+	mock.ExpectQuery(ohlcQuery).
+		WithArgs("BTC", int64(0), int64(600), int64(300)).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	candles, err = mockStorage.GetOHLC("BTC", 0, 600, 300)
+	require.NoError(t, err)
+	assert.Empty(t, candles)
+	assert.NotNil(t, candles)
+
+	_, err = mockStorage.GetOHLC("BTC", 0, 600, 0)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckEarliest(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{DB: db}
+	const earliestQuery = "SELECT MIN(timestamp) FROM currencies WHERE coin = $1"
+
+	// A timestamp before the coin was first tracked
+	mock.ExpectQuery(earliestQuery).WithArgs("BTC").
+		WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(int64(1736400000)))
+	earliest, err := mockStorage.CheckEarliest(context.Background(), "BTC", 1736300000)
+	assert.ErrorIs(t, err, storage.ErrNoDataBefore)
+	assert.Equal(t, int64(1736400000), earliest)
+
+	mock.ExpectQuery(earliestQuery).WithArgs("BTC").
+		WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(int64(1736400000)))
+	_, err = mockStorage.CheckEarliest(context.Background(), "BTC", 1736400000)
+	assert.NoError(t, err)
+
+	// Nothing stored for the coin
+	mock.ExpectQuery(earliestQuery).WithArgs("SOL").
+		WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(nil))
+	_, err = mockStorage.CheckEarliest(context.Background(), "SOL", 1736300000)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// The database is down
+	mock.ExpectQuery(earliestQuery).WithArgs("BTC").WillReturnError(errors.New("connection refused"))
+	_, err = mockStorage.CheckEarliest(context.Background(), "BTC", 1736300000)
+	assert.ErrorIs(t, err, storage.ErrBackend)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"test-task1/models"
+	"time"
+)
+
+const (
+	defaultBackfillConcurrency = 2
+	defaultBackfillJobTTL      = time.Hour
+)
+
+// HistorySource fetches historical candles of a coin from an exchange.
+type HistorySource interface {
+	GetOHLC(ctx context.Context, coin string, since int64) ([]models.Candle, error)
+}
+
+// Backfill stores the close price of every candle the history source returns
+// for the coin since the given timestamp, in a single transaction. Candles
+// whose timestamp is already stored for the coin are skipped, so running a
+// backfill twice does not duplicate prices. The fetch is cancelled on shutdown.
+// Parameters:
+// - coin: cryptocurrency symbol (e.g. "BTC")
+// - since: Unix timestamp in the configured precision; 0 fetches the most recent candles
+// Returns the number of rows written.
+func (s *Storage) Backfill(coin string, since int64) (int64, error) {
+	const op = "storage.Backfill"
+	if s.cacheOnly() {
+		return 0, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	sinceSec := since
+	if s.Config.CollConf.Milliseconds() {
+		sinceSec = since / 1000
+	}
+	candles, err := s.history().GetOHLC(s.rootContext(), coin, sinceSec)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	defer tx.Rollback()
+
+	var rows int64
+	for _, candle := range candles {
+		res, err := tx.Exec(`
+			INSERT INTO currencies (coin, price, timestamp, synthetic)
+			SELECT $1, $2, $3, $4
+			WHERE NOT EXISTS (SELECT 1 FROM currencies WHERE coin = $1 AND timestamp = $3)`,
+			coin, s.roundPrice(candle.Close), s.Config.CollConf.FromUnixSeconds(candle.Timestamp),
+			s.Config.KrakenConf.Synthetic,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", op, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", op, err)
+		}
+		rows += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	// Backfilled points may be nearer to past queries than what was returned
+	s.purgeMemCache()
+	return rows, nil
+}
+
+// StartBackfill backfills the coins in the background, at most
+// backfill_concurrency of them at a time, and returns the new job.
+// Progress can be followed with BackfillStatus until backfill_job_ttl after
+// the job finished.
+func (s *Storage) StartBackfill(coins []string, since int64) models.BackfillJob {
+	job := &models.BackfillJob{
+		ID:     newJobID(),
+		Status: models.BackfillRunning,
+		Since:  since,
+		Coins:  make([]models.BackfillCoin, len(coins)),
+	}
+	for i, coin := range coins {
+		job.Coins[i] = models.BackfillCoin{Coin: coin, Status: models.BackfillPending}
+	}
+
+	s.mutex.Lock()
+	if s.backfills == nil {
+		s.backfills = make(map[string]*models.BackfillJob)
+	}
+	s.backfills[job.ID] = job
+	snapshot := copyJob(job)
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.runBackfill(job)
+	}()
+	return snapshot
+}
+
+// BackfillStatus returns a snapshot of the job with the given ID.
+func (s *Storage) BackfillStatus(id string) (models.BackfillJob, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	job, ok := s.backfills[id]
+	if !ok {
+		return models.BackfillJob{}, false
+	}
+	return copyJob(job), true
+}
+
+// runBackfill processes the coins of the job with bounded concurrency.
+// Coins not yet started when the storage shuts down are marked as failed.
+func (s *Storage) runBackfill(job *models.BackfillJob) {
+	sem := make(chan struct{}, s.backfillConcurrency())
+	var wg sync.WaitGroup
+
+	for i := range job.Coins {
+		select {
+		case sem <- struct{}{}:
+		case <-s.Shutdwn:
+			s.setBackfillCoin(job, i, models.BackfillFailed, 0, "cancelled by shutdown")
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			s.setBackfillCoin(job, i, models.BackfillRunning, 0, "")
+			rows, err := s.Backfill(job.Coins[i].Coin, job.Since)
+			if err != nil {
+				s.setBackfillCoin(job, i, models.BackfillFailed, 0, err.Error())
+				return
+			}
+			s.setBackfillCoin(job, i, models.BackfillDone, rows, "")
+		}(i)
+	}
+	wg.Wait()
+
+	s.mutex.Lock()
+	job.Status = models.BackfillDone
+	s.mutex.Unlock()
+
+	time.AfterFunc(s.backfillJobTTL(), func() {
+		s.mutex.Lock()
+		delete(s.backfills, job.ID)
+		s.mutex.Unlock()
+	})
+}
+
+func (s *Storage) setBackfillCoin(job *models.BackfillJob, i int, status string, rows int64, errMsg string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job.Coins[i].Status = status
+	job.Coins[i].Rows = rows
+	job.Coins[i].Error = errMsg
+}
+
+// backfillConcurrency returns the configured number of parallel coin fetches.
+func (s *Storage) backfillConcurrency() int {
+	if n := s.Config.CollConf.BackfillConcurrency; n > 0 {
+		return n
+	}
+	return defaultBackfillConcurrency
+}
+
+// backfillJobTTL returns how long a finished job is kept.
+func (s *Storage) backfillJobTTL() time.Duration {
+	if ttl := s.Config.CollConf.BackfillJobTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultBackfillJobTTL
+}
+
+func copyJob(job *models.BackfillJob) models.BackfillJob {
+	snapshot := *job
+	snapshot.Coins = append([]models.BackfillCoin(nil), job.Coins...)
+	return snapshot
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,144 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// fakeHistory serves fixed candles per coin and records the peak number
+// of concurrent fetches.
+type fakeHistory struct {
+	candles map[string][]models.Candle
+	delay   time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	calls    int32
+}
+
+func (f *fakeHistory) GetOHLC(ctx context.Context, coin string, since int64) ([]models.Candle, error) {
+	atomic.AddInt32(&f.calls, 1)
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.peak {
+		f.peak = f.inFlight
+	}
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+
+	candles, ok := f.candles[coin]
+	if !ok {
+		return nil, errors.New("unknown pair")
+	}
+	return candles, nil
+}
+
+const backfillQuery = `
+			INSERT INTO currencies (coin, price, timestamp, synthetic)
+			SELECT $1, $2, $3, $4
+			WHERE NOT EXISTS (SELECT 1 FROM currencies WHERE coin = $1 AND timestamp = $3)`
+
+func TestBackfill(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	history := &fakeHistory{candles: map[string][]models.Candle{
+		"BTC": {{Timestamp: 1736500440, Close: 50000}, {Timestamp: 1736500500, Close: 50010}},
+	}}
+	mockStorage := &storage.Storage{
+		DB:      db,
+		History: history,
+		Config:  models.Config{CollConf: models.CollectorCfg{TimestampPrecision: models.PrecisionMilliseconds}},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(backfillQuery).
+		WithArgs("BTC", 50000.0, int64(1736500440000), false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(backfillQuery).
+		WithArgs("BTC", 50010.0, int64(1736500500000), false).
+		WillReturnResult(sqlmock.NewResult(0, 0)) // already stored
+	mock.ExpectCommit()
+
+	rows, err := mockStorage.Backfill("BTC", 1736500000000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartBackfill(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	candle := []models.Candle{{Timestamp: 1736500440, Close: 100}}
+	history := &fakeHistory{
+		candles: map[string][]models.Candle{"BTC": candle, "ETH": candle, "SOL": candle, "ADA": candle},
+		delay:   20 * time.Millisecond,
+	}
+	mockStorage := &storage.Storage{
+		DB:      db,
+		History: history,
+		Shutdwn: make(chan struct{}),
+		Config: models.Config{CollConf: models.CollectorCfg{
+			BackfillConcurrency: 2,
+			BackfillJobTTL:      50 * time.Millisecond,
+		}},
+	}
+
+	for _, coin := range []string{"BTC", "ETH", "SOL", "ADA"} {
+		mock.ExpectBegin()
+		mock.ExpectExec(backfillQuery).
+			WithArgs(coin, 100.0, int64(1736500440), false).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+
+	job := mockStorage.StartBackfill([]string{"BTC", "ETH", "SOL", "ADA", "NOPE"}, 0)
+	assert.Equal(t, models.BackfillRunning, job.Status)
+	require.Len(t, job.Coins, 5)
+
+	require.Eventually(t, func() bool {
+		status, ok := mockStorage.BackfillStatus(job.ID)
+		return ok && status.Status == models.BackfillDone
+	}, 2*time.Second, 10*time.Millisecond)
+
+	status, _ := mockStorage.BackfillStatus(job.ID)
+	for _, coin := range status.Coins[:4] {
+		assert.Equal(t, models.BackfillDone, coin.Status, coin.Coin)
+		assert.Equal(t, int64(1), coin.Rows, coin.Coin)
+	}
+	assert.Equal(t, models.BackfillFailed, status.Coins[4].Status)
+	assert.NotEmpty(t, status.Coins[4].Error)
+
+	assert.Equal(t, int32(5), atomic.LoadInt32(&history.calls))
+	assert.LessOrEqual(t, history.peak, 2)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, ok := mockStorage.BackfillStatus("missing")
+	assert.False(t, ok)
+
+	// The finished job is dropped after backfill_job_ttl
+	assert.Eventually(t, func() bool {
+		_, ok := mockStorage.BackfillStatus(job.ID)
+		return !ok
+	}, 2*time.Second, 10*time.Millisecond)
+}
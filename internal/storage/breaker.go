@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"test-task1/internal/metrics"
+	"test-task1/models"
+	"time"
+)
+
+// ErrBreakerOpen is returned instead of calling the price source while the breaker is open.
+var ErrBreakerOpen = errors.New("price source circuit breaker is open")
+
+// Breaker is a PriceSource shared by all collectors that stops calling the
+// wrapped source once the error rate over the last window requests exceeds
+// the threshold. While open, fetches are short-circuited for the cooldown
+// and reads are served from cache/DB only. After the cooldown the window is
+// cleared and requests flow again.
+type Breaker struct {
+	source    PriceSource
+	threshold float64
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	outcomes  []bool // ring buffer of the last requests, true on failure
+	next      int
+	observed  int
+	openUntil time.Time
+}
+
+// NewBreaker wraps source with a circuit breaker.
+// Parameters:
+// - threshold: error rate (0..1] that trips the breaker
+// - window: number of most recent requests the rate is computed over
+// - cooldown: how long fetches are skipped once tripped
+func NewBreaker(source PriceSource, threshold float64, window int, cooldown time.Duration) *Breaker {
+	if window < 1 {
+		window = 1
+	}
+	return &Breaker{
+		source:    source,
+		threshold: threshold,
+		cooldown:  cooldown,
+		outcomes:  make([]bool, window),
+	}
+}
+
+// newBreakerFromConfig wraps source with a breaker unless it is disabled in config.
+func newBreakerFromConfig(source PriceSource, c models.CollectorCfg) PriceSource {
+	if c.BreakerThreshold <= 0 {
+		return source
+	}
+	return NewBreaker(source, c.BreakerThreshold, c.BreakerWindow, c.BreakerCooldown)
+}
+
+// GetPrice fetches the price through the wrapped source unless the breaker
+// is open. Fetches cancelled through ctx do not count as failures.
+func (b *Breaker) GetPrice(ctx context.Context, coin string) (float64, error) {
+	if !b.allow() {
+		return 0, ErrBreakerOpen
+	}
+
+	price, err := b.source.GetPrice(ctx, coin)
+	if ctx.Err() == nil {
+		b.record(err)
+	}
+	return price, err
+}
+
+// GetPriceAt fetches the price with its trade time through the wrapped source
+// unless the breaker is open. The time is zero when the source reports none.
+func (b *Breaker) GetPriceAt(ctx context.Context, coin string) (float64, time.Time, error) {
+	if !b.allow() {
+		return 0, time.Time{}, ErrBreakerOpen
+	}
+
+	price, at, err := fetchPrice(ctx, b.source, coin)
+	if ctx.Err() == nil {
+		b.record(err)
+	}
+	return price, at, err
+}
+
+// SupportedCoins returns the coins of the wrapped source; it is not guarded
+// by the breaker.
+func (b *Breaker) SupportedCoins() []string {
+	return b.source.SupportedCoins()
+}
+
+// Open reports whether fetches are currently short-circuited.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().Before(b.openUntil)
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(b.openUntil) {
+		return false
+	}
+
+	// Cooldown is over: start counting from scratch
+	b.openUntil = time.Time{}
+	b.next, b.observed = 0, 0
+	metrics.BreakerOpen.Set(0)
+	slog.Info("price source circuit breaker closed")
+	return true
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.outcomes[b.next] = err != nil
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.observed < len(b.outcomes) {
+		b.observed++
+	}
+
+	// Wait for a full window and ignore results of requests that were in
+	// flight when the breaker tripped
+	if b.observed < len(b.outcomes) || !b.openUntil.IsZero() {
+		return
+	}
+
+	failures := 0
+	for _, failed := range b.outcomes {
+		if failed {
+			failures++
+		}
+	}
+	if float64(failures)/float64(len(b.outcomes)) >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		metrics.BreakerOpen.Set(1)
+		metrics.BreakerTrips.Inc()
+		slog.Warn("price source circuit breaker opened", "cooldown", b.cooldown, "failures", failures, "requests", len(b.outcomes))
+	}
+}
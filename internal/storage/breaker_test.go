@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"test-task1/internal/storage"
+)
+
+// countingSource is a PriceSource that counts calls and fails on demand.
+type countingSource struct {
+	calls atomic.Int32
+	fail  atomic.Bool
+}
+
+func (c *countingSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	c.calls.Add(1)
+	if c.fail.Load() {
+		return 0, errors.New("kraken unavailable")
+	}
+	return 50000, nil
+}
+
+func (*countingSource) SupportedCoins() []string { return nil }
+
+func TestBreakerShortCircuitsDuringCooldown(t *testing.T) {
+	src := &countingSource{}
+	src.fail.Store(true)
+	breaker := storage.NewBreaker(src, 0.5, 4, 100*time.Millisecond)
+
+	// Drive the error rate past the threshold
+	for i := 0; i < 4; i++ {
+		_, err := breaker.GetPrice(context.Background(), "BTC")
+		assert.Error(t, err)
+	}
+	assert.True(t, breaker.Open())
+
+	// Fetches are short-circuited without reaching the source
+	for i := 0; i < 10; i++ {
+		_, err := breaker.GetPrice(context.Background(), "ETH")
+		assert.ErrorIs(t, err, storage.ErrBreakerOpen)
+	}
+	assert.Equal(t, int32(4), src.calls.Load())
+
+	// After the cooldown requests flow again
+	src.fail.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	price, err := breaker.GetPrice(context.Background(), "BTC")
+	assert.NoError(t, err)
+	assert.Equal(t, 50000.0, price)
+	assert.False(t, breaker.Open())
+	assert.Equal(t, int32(5), src.calls.Load())
+}
+
+func TestBreakerStaysClosedBelowThreshold(t *testing.T) {
+	src := &countingSource{}
+	breaker := storage.NewBreaker(src, 0.5, 4, time.Minute)
+
+	src.fail.Store(true)
+	breaker.GetPrice(context.Background(), "BTC")
+	src.fail.Store(false)
+	for i := 0; i < 5; i++ {
+		_, err := breaker.GetPrice(context.Background(), "BTC")
+		assert.NoError(t, err)
+	}
+	assert.False(t, breaker.Open())
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"test-task1/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrCacheOnly is returned by operations that need PostgreSQL when
+// database.cache_only is set.
+var ErrCacheOnly = errors.New("not available in cache-only mode")
+
+// cacheOnly reports whether PostgreSQL is disabled and Redis is the only store.
+func (s *Storage) cacheOnly() bool {
+	return s.Config.DBConf.CacheOnly
+}
+
+// getRangeFromCache returns the coin's cached prices in [from, to] ordered by time.
+func (s *Storage) getRangeFromCache(coin string, from, to int64) ([]models.PricePoint, error) {
+	key := fmt.Sprintf("token:%s", coin)
+	members, err := s.Redis.ZRangeByScore(context.Background(), key, &redis.ZRangeBy{
+		Min: strconv.FormatInt(from, 10),
+		Max: strconv.FormatInt(to, 10),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("storage.getRangeFromCache: %v", err)
+	}
+
+	points := make([]models.PricePoint, 0, len(members))
+	for _, member := range members {
+		point, err := parseMember(member)
+		if err != nil {
+			skipMalformed(key, err)
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
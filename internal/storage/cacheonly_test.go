@@ -0,0 +1,63 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// Test cache-only mode never touches the database: DB is nil, so any
+// query would panic
+func TestCacheOnly(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:    models.DatabaseCfg{CacheOnly: true},
+			CollConf:  models.CollectorCfg{Interval: 10 * time.Millisecond, WarmupPoints: 10},
+			QueryConf: models.QueryCfg{VerifyCacheHits: true},
+		},
+		Source:      fixedSource{price: 50000},
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+
+	// Collected prices end up in Redis only
+	mockStorage.AddCurrency("BTC")
+	assert.Eventually(t, func() bool {
+		count, _ := rdb.ZCard(context.Background(), "token:BTC").Result()
+		return count > 0
+	}, 2*time.Second, 5*time.Millisecond)
+	mockStorage.RemoveCurrency("BTC")
+
+	now := time.Now().Unix()
+	require.NoError(t, mockStorage.SaveCurrency(context.Background(), "ETH", 3000, now))
+	mockStorage.UpdateCache(context.Background(), "ETH", 3000, now)
+
+	point, source, err := mockStorage.GetPrice(context.Background(), "ETH", now)
+	require.NoError(t, err)
+	assert.Equal(t, 3000.0, point.Price)
+	assert.Equal(t, storage.SourceCache, source)
+
+	// A cache miss has nowhere else to go
+	_, _, err = mockStorage.GetPrice(context.Background(), "SOL", now)
+	assert.ErrorIs(t, err, storage.ErrUnsupportedCoin)
+
+	avg, points, err := mockStorage.GetDecayedAverage("ETH", now-60, now, 30)
+	require.NoError(t, err)
+	assert.Equal(t, 3000.0, avg)
+	assert.Equal(t, 1, points)
+
+	_, err = mockStorage.MergeCoins("XBT", "BTC")
+	assert.True(t, errors.Is(err, storage.ErrCacheOnly))
+	_, _, err = mockStorage.GetDepth("BTC", now)
+	assert.True(t, errors.Is(err, storage.ErrCacheOnly))
+
+	mockStorage.Shutdown()
+}
@@ -0,0 +1,20 @@
+package storage
+
+import "time"
+
+// Clock tells the collectors the current time; tests replace it with a fake.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// clock returns the configured clock, falling back to the system clock.
+func (s *Storage) clock() Clock {
+	if s.Clock == nil {
+		return systemClock{}
+	}
+	return s.Clock
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"test-task1/internal/metrics"
+	"test-task1/models"
+)
+
+// collectComparisons fetches the coin from every comparison source and
+// stores the prices next to the primary one.
+func (s *Storage) collectComparisons(ctx context.Context, coin string, timestamp int64) {
+	if s.cacheOnly() {
+		return
+	}
+	for name, src := range s.CompareSources {
+		price, err := src.GetPrice(ctx, coin)
+		if err != nil {
+			s.logger().Error("failed to get comparison price", "coin", coin, "source", name, "err", err)
+			continue
+		}
+		if err := s.SaveExchangePrice(coin, name, price, timestamp); err != nil {
+			s.logger().Error("failed to save comparison price", "coin", coin, "source", name, "err", err)
+		}
+	}
+}
+
+// SaveExchangePrice stores a price of the coin collected from a comparison source.
+func (s *Storage) SaveExchangePrice(coin, source string, price float64, timestamp int64) error {
+	defer metrics.TimeDBQuery(metrics.QueryInsert).ObserveDuration()
+	_, err := s.DB.Exec(
+		"INSERT INTO exchange_prices (coin, source, price, timestamp) VALUES ($1, $2, $3, $4)",
+		coin, source, s.roundPrice(price), timestamp,
+	)
+	if err != nil {
+		return fmt.Errorf("storage.SaveExchangePrice: %v", err)
+	}
+	return nil
+}
+
+// ComparePrices returns the price nearest to the timestamp from the primary
+// source and every comparison source. Sources without data for the coin are
+// listed in missing; sql.ErrNoRows is returned if no source has any.
+func (s *Storage) ComparePrices(coin string, timestamp int64) (prices []models.ExchangePrice, missing []string, err error) {
+	const op = "storage.ComparePrices"
+	if s.cacheOnly() {
+		return nil, nil, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+	coin = s.resolveCoin(coin)
+
+	price, ts, err := s.getFromDB(context.Background(), coin, timestamp)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		missing = append(missing, s.primarySource())
+	case err != nil:
+		return nil, nil, fmt.Errorf("%s: %v", op, err)
+	default:
+		prices = append(prices, models.ExchangePrice{Source: s.primarySource(), Price: price, Timestamp: ts})
+	}
+
+	names := make([]string, 0, len(s.CompareSources))
+	for name := range s.CompareSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var p models.ExchangePrice
+		timer := metrics.TimeDBQuery(metrics.QueryNearest)
+		err := s.DB.QueryRow(`
+			SELECT price, timestamp
+			FROM exchange_prices
+			WHERE coin = $1 AND source = $2
+			ORDER BY ABS(timestamp - $3)
+			LIMIT 1`,
+			coin, name, timestamp,
+		).Scan(&p.Price, &p.Timestamp)
+		timer.ObserveDuration()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			missing = append(missing, name)
+		case err != nil:
+			return nil, nil, fmt.Errorf("%s: %v", op, err)
+		default:
+			p.Source = name
+			prices = append(prices, p)
+		}
+	}
+
+	if len(prices) == 0 {
+		return nil, missing, sql.ErrNoRows
+	}
+	return prices, missing, nil
+}
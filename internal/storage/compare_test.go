@@ -0,0 +1,181 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+	coinbase "test-task1/pkg/coinbase-api"
+)
+
+// fixedSource is a PriceSource that always answers with the same price or error.
+type fixedSource struct {
+	price float64
+	err   error
+}
+
+func (f fixedSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	return f.price, f.err
+}
+
+func (fixedSource) SupportedCoins() []string { return nil }
+
+// Test the collector stores the prices of the comparison sources next to the primary one
+func TestCollectComparisons(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{CollConf: models.CollectorCfg{Interval: 10 * time.Millisecond}},
+		Source: fixedSource{price: 50000},
+		CompareSources: map[string]storage.PriceSource{
+			"coinbase": fixedSource{price: 50010},
+			"broken":   fixedSource{err: errors.New("unavailable")},
+		},
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	mock.ExpectExec("INSERT INTO currencies (coin, price, timestamp, synthetic) VALUES ($1, $2, $3, $4)").
+		WithArgs("BTC", 50000.0, sqlmock.AnyArg(), false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO exchange_prices (coin, source, price, timestamp) VALUES ($1, $2, $3, $4)").
+		WithArgs("BTC", "coinbase", 50010.0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("INSERT INTO tracked_coins (coin) VALUES ($1) ON CONFLICT DO NOTHING").
+		WithArgs("BTC").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	assert.Eventually(t, func() bool {
+		return mock.ExpectationsWereMet() == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	mockStorage.RemoveCurrency("BTC")
+}
+
+// Test the exchanges of collector.compare_sources are reported by ComparePrices
+func TestNewCompareSources(t *testing.T) {
+	assert.Nil(t, storage.NewCompareSources(models.CollectorCfg{}))
+
+	sources := storage.NewCompareSources(models.CollectorCfg{CompareSources: []string{models.PriceSourceCoinbase}})
+	require.Contains(t, sources, models.PriceSourceCoinbase)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mockStorage := &storage.Storage{DB: db, CompareSources: sources}
+
+	mock.ExpectQuery("FROM currencies").
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, int64(1736500490)))
+	mock.ExpectQuery("FROM exchange_prices").WithArgs("BTC", models.PriceSourceCoinbase, int64(1736500490)).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}))
+
+	prices, missing, err := mockStorage.ComparePrices("BTC", 1736500490)
+	require.NoError(t, err)
+	assert.Len(t, prices, 1)
+	assert.Equal(t, []string{models.PriceSourceCoinbase}, missing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test a Coinbase comparison source is collected into exchange_prices
+func TestCollectCoinbaseComparisons(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"amount":"50123.45","base":"BTC","currency":"USD"}}`))
+	}))
+	defer srv.Close()
+	coinbase.Configure(models.CoinbaseCfg{BaseURL: srv.URL})
+	defer coinbase.Configure(models.CoinbaseCfg{BaseURL: coinbase.DefaultBaseURL})
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config:         models.Config{CollConf: models.CollectorCfg{Interval: 10 * time.Millisecond}},
+		Source:         fixedSource{price: 50000},
+		CompareSources: storage.NewCompareSources(models.CollectorCfg{CompareSources: []string{models.PriceSourceCoinbase}}),
+		DB:             db,
+		Redis:          rdb,
+		ActiveCoins:    make(map[string]struct{}),
+		Shutdwn:        make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	mock.ExpectExec("INSERT INTO tracked_coins (coin) VALUES ($1) ON CONFLICT DO NOTHING").
+		WithArgs("BTC").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO currencies (coin, price, timestamp, synthetic) VALUES ($1, $2, $3, $4)").
+		WithArgs("BTC", 50000.0, sqlmock.AnyArg(), false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO exchange_prices (coin, source, price, timestamp) VALUES ($1, $2, $3, $4)").
+		WithArgs("BTC", models.PriceSourceCoinbase, 50123.45, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	assert.Eventually(t, func() bool {
+		return mock.ExpectationsWereMet() == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	mockStorage.RemoveCurrency("BTC")
+}
+
+func TestComparePrices(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{
+		DB: db,
+		CompareSources: map[string]storage.PriceSource{
+			"binance":  fixedSource{},
+			"coinbase": fixedSource{},
+		},
+	}
+
+	testTime := int64(1736500490)
+	mock.ExpectQuery(`
+		SELECT price, timestamp 
+		FROM currencies 
+		WHERE coin = $1 
+		ORDER BY ABS(timestamp - $2) 
+		LIMIT 1`).
+		WithArgs("BTC", testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, testTime-2))
+	exchangeQuery := `
+			SELECT price, timestamp
+			FROM exchange_prices
+			WHERE coin = $1 AND source = $2
+			ORDER BY ABS(timestamp - $3)
+			LIMIT 1`
+	// binance has not collected the coin yet
+	mock.ExpectQuery(exchangeQuery).
+		WithArgs("BTC", "binance", testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}))
+	mock.ExpectQuery(exchangeQuery).
+		WithArgs("BTC", "coinbase", testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50010.0, testTime-1))
+
+	prices, missing, err := mockStorage.ComparePrices("BTC", testTime)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ExchangePrice{
+		{Source: models.PriceSourceKraken, Price: 50000, Timestamp: testTime - 2},
+		{Source: "coinbase", Price: 50010, Timestamp: testTime - 1},
+	}, prices)
+	assert.Equal(t, []string{"binance"}, missing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
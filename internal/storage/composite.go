@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"test-task1/models"
+	"time"
+)
+
+// ErrNoSourcePrice is returned by CompositeSource when none of its sources
+// returned a price in time.
+var ErrNoSourcePrice = errors.New("no price source returned a price")
+
+// NamedSource is a PriceSource with the name CompositeSource logs it under.
+type NamedSource struct {
+	Name string
+	PriceSource
+}
+
+// CompositeSource is a PriceSource that queries all its sources concurrently
+// and combines their prices, so a single exchange's bad print does not end
+// up in the stored data. Sources that fail or do not answer within the
+// timeout are left out.
+type CompositeSource struct {
+	sources   []NamedSource
+	timeout   time.Duration
+	aggregate string
+}
+
+// NewCompositeSource combines sources.
+// Parameters:
+// - sources: the sources queried on every fetch
+// - timeout: how long a fetch waits for the sources; 0 waits for all of them
+// - aggregate: models.AggregateMedian (default when empty) or models.AggregateMean
+func NewCompositeSource(sources []NamedSource, timeout time.Duration, aggregate string) *CompositeSource {
+	if aggregate == "" {
+		aggregate = models.AggregateMedian
+	}
+	return &CompositeSource{sources: sources, timeout: timeout, aggregate: aggregate}
+}
+
+// GetPrice returns the median or mean price of the sources that answered.
+// ErrNoSourcePrice, joined with the errors of the sources, is returned if
+// none did.
+func (c *CompositeSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		name  string
+		price float64
+		err   error
+	}
+	// Buffered so that sources answering after the timeout do not block
+	results := make(chan result, len(c.sources))
+	for _, src := range c.sources {
+		go func(src NamedSource) {
+			price, err := src.GetPrice(ctx, coin)
+			results <- result{name: src.Name, price: price, err: err}
+		}(src)
+	}
+
+	prices := make([]float64, 0, len(c.sources))
+	contributed := make([]string, 0, len(c.sources))
+	errs := []error{ErrNoSourcePrice}
+collect:
+	for range c.sources {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				slog.Warn("price source failed", "coin", coin, "source", r.name, "err", r.err)
+				errs = append(errs, fmt.Errorf("%s: %v", r.name, r.err))
+				continue
+			}
+			prices = append(prices, r.price)
+			contributed = append(contributed, r.name)
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			break collect
+		}
+	}
+
+	if len(prices) == 0 {
+		return 0, errors.Join(errs...)
+	}
+	price := c.combine(prices)
+	slog.Debug("aggregated price", "coin", coin, "price", price, "sources", contributed)
+	return price, nil
+}
+
+// combine returns the median or mean of prices, which must not be empty.
+func (c *CompositeSource) combine(prices []float64) float64 {
+	if c.aggregate == models.AggregateMean {
+		var sum float64
+		for _, p := range prices {
+			sum += p
+		}
+		return sum / float64(len(prices))
+	}
+
+	sort.Float64s(prices)
+	mid := len(prices) / 2
+	if len(prices)%2 == 0 {
+		return (prices[mid-1] + prices[mid]) / 2
+	}
+	return prices[mid]
+}
+
+// SupportedCoins returns the coins at least one of the sources supports in
+// alphabetical order.
+func (c *CompositeSource) SupportedCoins() []string {
+	seen := make(map[string]struct{})
+	for _, src := range c.sources {
+		for _, coin := range src.SupportedCoins() {
+			seen[coin] = struct{}{}
+		}
+	}
+	coins := make([]string, 0, len(seen))
+	for coin := range seen {
+		coins = append(coins, coin)
+	}
+	sort.Strings(coins)
+	return coins
+}
@@ -0,0 +1,81 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// coinsSource is a fixedSource supporting the listed coins.
+type coinsSource struct {
+	fixedSource
+	coins []string
+}
+
+func (s coinsSource) SupportedCoins() []string { return s.coins }
+
+func TestCompositeSource(t *testing.T) {
+	sources := []storage.NamedSource{
+		{Name: "a", PriceSource: fixedSource{price: 100}},
+		{Name: "b", PriceSource: fixedSource{price: 101}},
+		{Name: "c", PriceSource: fixedSource{price: 249}}, // a bad print
+		{Name: "d", PriceSource: fixedSource{err: errors.New("down")}},
+	}
+
+	t.Run("median", func(t *testing.T) {
+		price, err := storage.NewCompositeSource(sources, time.Second, "").GetPrice(context.Background(), "BTC")
+		require.NoError(t, err)
+		assert.Equal(t, 101.0, price)
+	})
+
+	t.Run("median of an even count", func(t *testing.T) {
+		price, err := storage.NewCompositeSource(sources[:2], time.Second, models.AggregateMedian).GetPrice(context.Background(), "BTC")
+		require.NoError(t, err)
+		assert.Equal(t, 100.5, price)
+	})
+
+	t.Run("mean", func(t *testing.T) {
+		price, err := storage.NewCompositeSource(sources, time.Second, models.AggregateMean).GetPrice(context.Background(), "BTC")
+		require.NoError(t, err)
+		assert.Equal(t, 150.0, price)
+	})
+
+	t.Run("all failing", func(t *testing.T) {
+		_, err := storage.NewCompositeSource(sources[3:], time.Second, "").GetPrice(context.Background(), "BTC")
+		assert.ErrorIs(t, err, storage.ErrNoSourcePrice)
+		assert.ErrorContains(t, err, "d: down")
+	})
+}
+
+// Test a slow source does not stall the fetch beyond the timeout
+func TestCompositeSourceTimeout(t *testing.T) {
+	sources := []storage.NamedSource{
+		{Name: "fast", PriceSource: fixedSource{price: 100}},
+		{Name: "slow", PriceSource: slowSource{delay: time.Second}},
+	}
+
+	start := time.Now()
+	price, err := storage.NewCompositeSource(sources, 50*time.Millisecond, "").GetPrice(context.Background(), "BTC")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, price)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	_, err = storage.NewCompositeSource(sources[1:], 50*time.Millisecond, "").GetPrice(context.Background(), "BTC")
+	assert.ErrorIs(t, err, storage.ErrNoSourcePrice)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCompositeSourceSupportedCoins(t *testing.T) {
+	src := storage.NewCompositeSource([]storage.NamedSource{
+		{Name: "a", PriceSource: coinsSource{coins: []string{"ETH", "BTC"}}},
+		{Name: "b", PriceSource: coinsSource{coins: []string{"SOL", "BTC"}}},
+	}, time.Second, "")
+
+	assert.Equal(t, []string{"BTC", "ETH", "SOL"}, src.SupportedCoins())
+}
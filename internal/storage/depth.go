@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"test-task1/internal/metrics"
+	"test-task1/models"
+	kraken "test-task1/pkg/kraken-api"
+	"time"
+)
+
+const (
+	defaultDepthLevels   = 10
+	maxDepthLevels       = 500 // Kraken returns at most 500 levels per side
+	defaultDepthInterval = 30 * time.Second
+	minDepthInterval     = 5 * time.Second
+)
+
+// AddDepth starts periodic order-book snapshots for the coin.
+// If depth is already collected for the coin, does nothing.
+// Parameters:
+// - coin: cryptocurrency symbol (e.g. "BTC")
+func (s *Storage) AddDepth(coin string) {
+	if s.cacheOnly() {
+		s.logger().Warn("depth is not collected in cache-only mode", "coin", coin)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.depthCoins == nil {
+		s.depthCoins = make(map[string]context.CancelFunc)
+	}
+	if _, exists := s.depthCoins[coin]; exists {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.rootContext())
+	s.depthCoins[coin] = cancel
+
+	s.goCollector("depth:"+coin, func() {
+		s.startDepthCollecting(ctx, coin)
+	})
+}
+
+// removeDepth stops the depth collector of the coin. Caller must hold s.mutex.
+func (s *Storage) removeDepth(coin string) {
+	if cancel, exists := s.depthCoins[coin]; exists {
+		cancel()
+		delete(s.depthCoins, coin)
+	}
+}
+
+// startDepthCollecting periodically snapshots the coin's order book within
+// the collector.schedule windows until ctx is cancelled: when depth of the
+// coin is removed or the storage shuts down.
+func (s *Storage) startDepthCollecting(ctx context.Context, coin string) {
+	ticker := time.NewTicker(s.depthInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !s.scheduled() {
+				continue
+			}
+			book, err := kraken.GetDepth(ctx, coin, s.depthLevels())
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				s.logger().Error("failed to get depth", "coin", coin, "err", err)
+				continue
+			}
+			if err := s.SaveDepth(coin, book, s.Config.CollConf.Now()); err != nil {
+				s.logger().Error("failed to save depth", "coin", coin, "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SaveDepth stores an order-book snapshot of the coin.
+func (s *Storage) SaveDepth(coin string, book models.OrderBook, timestamp int64) error {
+	const op = "storage.SaveDepth"
+	if s.cacheOnly() {
+		return fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	bids, err := json.Marshal(book.Bids)
+	if err != nil {
+		return fmt.Errorf("%s: %v", op, err)
+	}
+	asks, err := json.Marshal(book.Asks)
+	if err != nil {
+		return fmt.Errorf("%s: %v", op, err)
+	}
+
+	_, err = s.DB.Exec(
+		"INSERT INTO depth_snapshots (coin, timestamp, bids, asks) VALUES ($1, $2, $3, $4)",
+		coin, timestamp, bids, asks,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %v", op, err)
+	}
+	return nil
+}
+
+// depthSnapshot is a stored order-book snapshot before decoding.
+type depthSnapshot struct {
+	timestamp  int64
+	bids, asks []byte
+}
+
+// GetDepth returns the order-book snapshot nearest to the specified time.
+// The latest snapshot at or before and the earliest at or after the time are
+// looked up separately, so each query is served by the (coin, timestamp)
+// index, and the closer one is returned; on a tie the earlier one.
+// Returns:
+// - book: bid and ask levels of the snapshot
+// - timestamp: Unix timestamp of the snapshot
+// - error: sql.ErrNoRows if the coin has no snapshot
+func (s *Storage) GetDepth(coin string, timestamp int64) (models.OrderBook, int64, error) {
+	const op = "storage.GetDepth"
+	if s.cacheOnly() {
+		return models.OrderBook{}, 0, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+	coin = s.resolveCoin(coin)
+	defer metrics.TimeDBQuery(metrics.QueryDepth).ObserveDuration()
+
+	var book models.OrderBook
+	before, err := s.depthSnapshot(`
+		SELECT timestamp, bids, asks
+		FROM depth_snapshots
+		WHERE coin = $1 AND timestamp <= $2
+		ORDER BY timestamp DESC
+		LIMIT 1`,
+		coin, timestamp,
+	)
+	if err != nil {
+		return book, 0, err
+	}
+	after, err := s.depthSnapshot(`
+		SELECT timestamp, bids, asks
+		FROM depth_snapshots
+		WHERE coin = $1 AND timestamp >= $2
+		ORDER BY timestamp ASC
+		LIMIT 1`,
+		coin, timestamp,
+	)
+	if err != nil {
+		return book, 0, err
+	}
+
+	snap := before
+	if snap == nil || (after != nil && after.timestamp-timestamp < timestamp-snap.timestamp) {
+		snap = after
+	}
+	if snap == nil {
+		return book, 0, sql.ErrNoRows
+	}
+
+	if err = json.Unmarshal(snap.bids, &book.Bids); err != nil {
+		return book, 0, fmt.Errorf("%s: %v", op, err)
+	}
+	if err = json.Unmarshal(snap.asks, &book.Asks); err != nil {
+		return book, 0, fmt.Errorf("%s: %v", op, err)
+	}
+	return book, snap.timestamp, nil
+}
+
+// depthSnapshot runs a query selecting at most one snapshot of the coin
+// relative to the timestamp. Returns nil without an error if there is none.
+func (s *Storage) depthSnapshot(query, coin string, timestamp int64) (*depthSnapshot, error) {
+	var snap depthSnapshot
+	err := s.DB.QueryRow(query, coin, timestamp).Scan(&snap.timestamp, &snap.bids, &snap.asks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetDepth: %v", err)
+	}
+	return &snap, nil
+}
+
+// depthLevels returns the configured number of levels, bounded by Kraken's limit.
+func (s *Storage) depthLevels() int {
+	levels := s.Config.CollConf.DepthLevels
+	switch {
+	case levels <= 0:
+		return defaultDepthLevels
+	case levels > maxDepthLevels:
+		return maxDepthLevels
+	}
+	return levels
+}
+
+// depthInterval returns the configured snapshot interval, never below minDepthInterval.
+func (s *Storage) depthInterval() time.Duration {
+	interval := s.Config.CollConf.DepthInterval
+	switch {
+	case interval <= 0:
+		return defaultDepthInterval
+	case interval < minDepthInterval:
+		return minDepthInterval
+	}
+	return interval
+}
@@ -0,0 +1,125 @@
+package storage_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+func TestSaveDepth(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{DB: db}
+	book := models.OrderBook{
+		Bids: []models.DepthLevel{{Price: 100, Volume: 1.5}},
+		Asks: []models.DepthLevel{{Price: 101, Volume: 2}},
+	}
+
+	mock.ExpectExec("INSERT INTO depth_snapshots (coin, timestamp, bids, asks) VALUES ($1, $2, $3, $4)").
+		WithArgs("BTC", int64(1736500490),
+			[]byte(`[{"price":100,"volume":1.5}]`),
+			[]byte(`[{"price":101,"volume":2}]`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	require.NoError(t, mockStorage.SaveDepth("BTC", book, 1736500490))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDepth(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{DB: db}
+	beforeQuery := `
+		SELECT timestamp, bids, asks
+		FROM depth_snapshots
+		WHERE coin = $1 AND timestamp <= $2
+		ORDER BY timestamp DESC
+		LIMIT 1`
+	afterQuery := `
+		SELECT timestamp, bids, asks
+		FROM depth_snapshots
+		WHERE coin = $1 AND timestamp >= $2
+		ORDER BY timestamp ASC
+		LIMIT 1`
+	snapshot := func(ts int64, bid float64) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"timestamp", "bids", "asks"}).
+			AddRow(ts, []byte(fmt.Sprintf(`[{"price":%g,"volume":1.5}]`, bid)), []byte(`[{"price":101,"volume":2}]`))
+	}
+	noSnapshot := sqlmock.NewRows([]string{"timestamp", "bids", "asks"})
+
+	t.Run("nearest snapshot before", func(t *testing.T) {
+		mock.ExpectQuery(beforeQuery).
+			WithArgs("BTC", int64(1736500490)).
+			WillReturnRows(snapshot(1736500480, 100))
+		mock.ExpectQuery(afterQuery).
+			WithArgs("BTC", int64(1736500490)).
+			WillReturnRows(snapshot(1736500510, 99))
+
+		book, ts, err := mockStorage.GetDepth("BTC", 1736500490)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1736500480), ts)
+		assert.Equal(t, []models.DepthLevel{{Price: 100, Volume: 1.5}}, book.Bids)
+		assert.Equal(t, []models.DepthLevel{{Price: 101, Volume: 2}}, book.Asks)
+	})
+
+	t.Run("nearest snapshot after", func(t *testing.T) {
+		mock.ExpectQuery(beforeQuery).
+			WithArgs("BTC", int64(1736500490)).
+			WillReturnRows(snapshot(1736500460, 100))
+		mock.ExpectQuery(afterQuery).
+			WithArgs("BTC", int64(1736500490)).
+			WillReturnRows(snapshot(1736500495, 99))
+
+		book, ts, err := mockStorage.GetDepth("BTC", 1736500490)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1736500495), ts)
+		assert.Equal(t, []models.DepthLevel{{Price: 99, Volume: 1.5}}, book.Bids)
+	})
+
+	t.Run("only later snapshots", func(t *testing.T) {
+		mock.ExpectQuery(beforeQuery).
+			WithArgs("BTC", int64(1736500490)).
+			WillReturnRows(noSnapshot)
+		mock.ExpectQuery(afterQuery).
+			WithArgs("BTC", int64(1736500490)).
+			WillReturnRows(snapshot(1736500600, 99))
+
+		_, ts, err := mockStorage.GetDepth("BTC", 1736500490)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1736500600), ts)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		mock.ExpectQuery(beforeQuery).
+			WithArgs("ETH", int64(1736500490)).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(afterQuery).
+			WithArgs("ETH", int64(1736500490)).
+			WillReturnError(sql.ErrNoRows)
+
+		_, _, err := mockStorage.GetDepth("ETH", 1736500490)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("database down", func(t *testing.T) {
+		mock.ExpectQuery(beforeQuery).
+			WithArgs("ETH", int64(1736500490)).
+			WillReturnError(errors.New("connection refused"))
+
+		_, _, err := mockStorage.GetDepth("ETH", 1736500490)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, sql.ErrNoRows)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	downsampleInterval      = time.Hour
+	defaultDownsampleBucket = time.Minute
+	// downsampleSlice bounds the span of history rewritten by one statement,
+	// so a long backlog (e.g. on the first run) is processed in steps.
+	downsampleSlice = 24 * time.Hour
+)
+
+// Downsample replaces every price older than downsample_after by one average
+// per coin and downsample_bucket, keeping recent data at full resolution.
+// Rows between the previous and the current cutoff are moved one
+// downsampleSlice at a time; each slice is moved and recorded in
+// downsample_watermark in one transaction, so a failure or a restart resumes
+// after the last finished slice. Without a watermark the oldest price is the
+// starting point.
+// Parameters:
+// - now: the current Unix timestamp in the configured precision
+// Returns the number of buckets written.
+func (s *Storage) Downsample(now int64) (int64, error) {
+	const op = "storage.Downsample"
+	if s.cacheOnly() {
+		return 0, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	bucket := s.Config.CollConf.Units(s.downsampleBucket())
+	if bucket <= 0 {
+		return 0, fmt.Errorf("%s: bucket is smaller than the timestamp precision", op)
+	}
+	cutoff := now - s.Config.CollConf.Units(s.Config.DBConf.DownsampleAfter)
+	cutoff -= cutoff % bucket
+	slice := s.Config.CollConf.Units(downsampleSlice)
+	slice -= slice % bucket
+	if slice < bucket {
+		slice = bucket
+	}
+
+	from, err := s.downsampleWatermark(cutoff, bucket)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+
+	var buckets int64
+	defer func() {
+		if buckets > 0 {
+			s.purgeMemCache()
+		}
+	}()
+	for from < cutoff {
+		select {
+		case <-s.Shutdwn:
+			return buckets, nil
+		default:
+		}
+
+		to := min(from+slice, cutoff)
+		n, err := s.downsampleRange(from, to, bucket)
+		if err != nil {
+			return buckets, fmt.Errorf("%s: %v", op, err)
+		}
+		buckets += n
+
+		s.mutex.Lock()
+		s.downsampledUntil = to
+		s.mutex.Unlock()
+		from = to
+	}
+	return buckets, nil
+}
+
+// downsampleWatermark returns the timestamp below which rows are already
+// downsampled, reading it from downsample_watermark on the first call. If
+// none was recorded yet, the oldest price rounded down to bucket is
+// returned, or cutoff when there are no prices.
+func (s *Storage) downsampleWatermark(cutoff, bucket int64) (int64, error) {
+	s.mutex.RLock()
+	from, loaded := s.downsampledUntil, s.downsampleLoaded
+	s.mutex.RUnlock()
+	if loaded {
+		return from, nil
+	}
+
+	err := s.DB.QueryRow("SELECT downsampled_until FROM downsample_watermark").Scan(&from)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = s.DB.QueryRow("SELECT COALESCE(MIN(timestamp), $1) FROM currencies", cutoff).Scan(&from)
+		from -= from % bucket
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	s.downsampledUntil, s.downsampleLoaded = from, true
+	s.mutex.Unlock()
+	return from, nil
+}
+
+// downsampleRange moves the rows in [from, to) into buckets and records to
+// as the new watermark in the same transaction.
+// Returns the number of buckets written.
+func (s *Storage) downsampleRange(from, to, bucket int64) (int64, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		WITH moved AS (
+			DELETE FROM currencies
+			WHERE timestamp >= $1 AND timestamp < $2
+			RETURNING coin, price, timestamp, synthetic
+		)
+		INSERT INTO currencies (coin, price, timestamp, synthetic)
+		SELECT coin, AVG(price), timestamp / $3 * $3, synthetic
+		FROM moved
+		GROUP BY coin, synthetic, timestamp / $3`,
+		from, to, bucket,
+	)
+	if err != nil {
+		return 0, err
+	}
+	buckets, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO downsample_watermark (id, downsampled_until) VALUES (TRUE, $1)
+		ON CONFLICT (id) DO UPDATE SET downsampled_until = EXCLUDED.downsampled_until`,
+		to,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return buckets, tx.Commit()
+}
+
+// startDownsampling runs Downsample every downsampleInterval until shutdown.
+func (s *Storage) startDownsampling() {
+	ticker := time.NewTicker(downsampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			buckets, err := s.Downsample(s.Config.CollConf.Now())
+			if err != nil {
+				s.logger().Error("downsampling failed", "err", err)
+				continue
+			}
+			s.logger().Info("downsampled old prices", "buckets", buckets)
+		case <-s.Shutdwn:
+			return
+		}
+	}
+}
+
+func (s *Storage) downsampleBucket() time.Duration {
+	if s.Config.DBConf.DownsampleBucket <= 0 {
+		return defaultDownsampleBucket
+	}
+	return s.Config.DBConf.DownsampleBucket
+}
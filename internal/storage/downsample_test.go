@@ -0,0 +1,135 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+const downsampleQuery = `
+		WITH moved AS (
+			DELETE FROM currencies
+			WHERE timestamp >= $1 AND timestamp < $2
+			RETURNING coin, price, timestamp, synthetic
+		)
+		INSERT INTO currencies (coin, price, timestamp, synthetic)
+		SELECT coin, AVG(price), timestamp / $3 * $3, synthetic
+		FROM moved
+		GROUP BY coin, synthetic, timestamp / $3`
+
+const (
+	watermarkQuery       = "SELECT downsampled_until FROM downsample_watermark"
+	oldestPriceQuery     = "SELECT COALESCE(MIN(timestamp), $1) FROM currencies"
+	updateWatermarkQuery = `
+		INSERT INTO downsample_watermark (id, downsampled_until) VALUES (TRUE, $1)
+		ON CONFLICT (id) DO UPDATE SET downsampled_until = EXCLUDED.downsampled_until`
+)
+
+// expectDownsampleSlice expects the rows in [from, to) to be moved and to to
+// be recorded as the watermark in one transaction.
+func expectDownsampleSlice(mock sqlmock.Sqlmock, from, to int64, buckets int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(downsampleQuery).
+		WithArgs(from, to, int64(60)).
+		WillReturnResult(sqlmock.NewResult(0, buckets))
+	mock.ExpectExec(updateWatermarkQuery).
+		WithArgs(to).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func newDownsampleStorage(t *testing.T) (*storage.Storage, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return &storage.Storage{
+		Config: models.Config{DBConf: models.DatabaseCfg{
+			DownsampleAfter:  time.Hour,
+			DownsampleBucket: time.Minute,
+		}},
+		DB: db,
+	}, mock
+}
+
+func TestDownsample(t *testing.T) {
+	mockStorage, mock := newDownsampleStorage(t)
+
+	// Without a watermark the run starts at the oldest price and moves the
+	// backlog a day at a time; only rows older than an hour (aligned to the
+	// minute) are aggregated, the last hour stays at full resolution
+	now := int64(1736500490)
+	cutoff := int64(1736496840) // (now - 3600) rounded down to a minute
+	oldest := int64(1736400000)
+	mock.ExpectQuery(watermarkQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"downsampled_until"}))
+	mock.ExpectQuery(oldestPriceQuery).
+		WithArgs(cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(oldest + 7))
+	expectDownsampleSlice(mock, oldest, oldest+86400, 100)
+	expectDownsampleSlice(mock, oldest+86400, cutoff, 20)
+
+	buckets, err := mockStorage.Downsample(now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(120), buckets)
+
+	// Within the same minute there is nothing new to aggregate
+	buckets, err = mockStorage.Downsample(now + 5)
+	require.NoError(t, err)
+	assert.Zero(t, buckets)
+
+	// The next run continues where the previous one stopped
+	expectDownsampleSlice(mock, cutoff, cutoff+60, 3)
+
+	buckets, err = mockStorage.Downsample(now + 60)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), buckets)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDownsampleResumesFromWatermark(t *testing.T) {
+	mockStorage, mock := newDownsampleStorage(t)
+
+	// After a restart only the rows after the persisted watermark are moved
+	now := int64(1736500490)
+	cutoff := int64(1736496840)
+	mock.ExpectQuery(watermarkQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"downsampled_until"}).AddRow(cutoff - 120))
+	expectDownsampleSlice(mock, cutoff-120, cutoff, 2)
+
+	buckets, err := mockStorage.Downsample(now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), buckets)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDownsampleFailedSlice(t *testing.T) {
+	mockStorage, mock := newDownsampleStorage(t)
+
+	now := int64(1736500490)
+	cutoff := int64(1736496840)
+	mock.ExpectQuery(watermarkQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"downsampled_until"}).AddRow(cutoff - 60))
+	mock.ExpectBegin()
+	mock.ExpectExec(downsampleQuery).
+		WithArgs(cutoff-60, cutoff, int64(60)).
+		WillReturnError(errors.New("statement timeout"))
+	mock.ExpectRollback()
+
+	_, err := mockStorage.Downsample(now)
+	assert.Error(t, err)
+
+	// The failed slice is rolled back and retried by the next run
+	expectDownsampleSlice(mock, cutoff-60, cutoff, 1)
+
+	buckets, err := mockStorage.Downsample(now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), buckets)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"test-task1/models"
+	"time"
+)
+
+// touch counts a price query of the coin.
+func (s *Storage) touch(coin string) {
+	s.hotMu.Lock()
+	defer s.hotMu.Unlock()
+	if s.hotness == nil {
+		s.hotness = make(map[string]int64)
+	}
+	s.hotness[coin]++
+}
+
+// persistHotness reports whether access counts are mirrored to PostgreSQL.
+func (s *Storage) persistHotness() bool {
+	return !s.cacheOnly() && s.Config.DBConf.HotnessFlush > 0
+}
+
+// FlushHotness adds the access counts gathered since the previous flush to
+// the coin_hotness table. Counts that could not be written are kept for the
+// next flush.
+func (s *Storage) FlushHotness() error {
+	const op = "storage.FlushHotness"
+	if s.cacheOnly() {
+		return fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	s.hotMu.Lock()
+	pending := s.hotness
+	s.hotness = nil
+	s.hotMu.Unlock()
+
+	for coin, accesses := range pending {
+		_, err := s.DB.Exec(`
+			INSERT INTO coin_hotness (coin, accesses)
+			VALUES ($1, $2)
+			ON CONFLICT (coin) DO UPDATE SET accesses = coin_hotness.accesses + EXCLUDED.accesses`,
+			coin, accesses,
+		)
+		if err != nil {
+			s.hotMu.Lock()
+			if s.hotness == nil {
+				s.hotness = make(map[string]int64)
+			}
+			for coin, accesses := range pending {
+				s.hotness[coin] += accesses
+			}
+			s.hotMu.Unlock()
+			return fmt.Errorf("%s: %v", op, err)
+		}
+		delete(pending, coin)
+	}
+	return nil
+}
+
+// HotCoins returns the most queried coins, most accessed first. With
+// database.hotness_flush set the counts survive restarts and Redis flushes;
+// otherwise they are counted since the start of the process.
+func (s *Storage) HotCoins(limit int) ([]models.HotCoin, error) {
+	const op = "storage.HotCoins"
+	if !s.persistHotness() {
+		return s.memoryHotCoins(limit), nil
+	}
+
+	if err := s.FlushHotness(); err != nil {
+		s.logger().Error("hotness flush failed", "op", op, "err", err)
+	}
+	rows, err := s.DB.Query(`
+		SELECT coin, accesses
+		FROM coin_hotness
+		ORDER BY accesses DESC, coin
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	defer rows.Close()
+
+	coins := make([]models.HotCoin, 0, limit)
+	for rows.Next() {
+		var c models.HotCoin
+		if err := rows.Scan(&c.Coin, &c.Accesses); err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+		coins = append(coins, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	return coins, nil
+}
+
+// memoryHotCoins ranks the access counts held in process.
+func (s *Storage) memoryHotCoins(limit int) []models.HotCoin {
+	s.hotMu.Lock()
+	coins := make([]models.HotCoin, 0, len(s.hotness))
+	for coin, accesses := range s.hotness {
+		coins = append(coins, models.HotCoin{Coin: coin, Accesses: accesses})
+	}
+	s.hotMu.Unlock()
+
+	sort.Slice(coins, func(i, j int) bool {
+		if coins[i].Accesses != coins[j].Accesses {
+			return coins[i].Accesses > coins[j].Accesses
+		}
+		return coins[i].Coin < coins[j].Coin
+	})
+	if len(coins) > limit {
+		coins = coins[:limit]
+	}
+	return coins
+}
+
+// startHotnessFlush runs FlushHotness every database.hotness_flush until
+// shutdown, flushing once more on the way out.
+func (s *Storage) startHotnessFlush() {
+	ticker := time.NewTicker(s.Config.DBConf.HotnessFlush)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.FlushHotness(); err != nil {
+				s.logger().Error("hotness flush failed", "err", err)
+			}
+		case <-s.Shutdwn:
+			if err := s.FlushHotness(); err != nil {
+				s.logger().Error("hotness flush failed", "err", err)
+			}
+			return
+		}
+	}
+}
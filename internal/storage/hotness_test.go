@@ -0,0 +1,85 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+const hotnessUpsert = `
+			INSERT INTO coin_hotness (coin, accesses)
+			VALUES ($1, $2)
+			ON CONFLICT (coin) DO UPDATE SET accesses = coin_hotness.accesses + EXCLUDED.accesses`
+
+// Test every price query counts as an access of the coin
+func TestHotCoinsCountsAccesses(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{DBConf: models.DatabaseCfg{CacheOnly: true}},
+		Redis:  rdb,
+	}
+
+	now := time.Now().Unix()
+	mockStorage.UpdateCache(context.Background(), "BTC", 50000, now)
+	mockStorage.UpdateCache(context.Background(), "ETH", 3000, now)
+	for i := 0; i < 3; i++ {
+		_, _, err := mockStorage.GetPrice(context.Background(), "BTC", now)
+		require.NoError(t, err)
+	}
+	_, _, err := mockStorage.GetPrice(context.Background(), "ETH", now)
+	require.NoError(t, err)
+
+	coins, err := mockStorage.HotCoins(10)
+	require.NoError(t, err)
+	assert.Equal(t, []models.HotCoin{{Coin: "BTC", Accesses: 3}, {Coin: "ETH", Accesses: 1}}, coins)
+
+	coins, err = mockStorage.HotCoins(1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.HotCoin{{Coin: "BTC", Accesses: 3}}, coins)
+}
+
+// Test persisted counts are flushed before they are read and kept on failure
+func TestHotCoinsPersisted(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{DBConf: models.DatabaseCfg{HotnessFlush: time.Minute}},
+		DB:     db,
+		Redis:  rdb,
+	}
+
+	now := time.Now().Unix()
+	mockStorage.UpdateCache(context.Background(), "BTC", 50000, now)
+	_, _, err = mockStorage.GetPrice(context.Background(), "BTC", now)
+	require.NoError(t, err)
+
+	mock.ExpectExec(hotnessUpsert).WithArgs("BTC", int64(1)).WillReturnError(errors.New("connection reset"))
+	require.Error(t, mockStorage.FlushHotness())
+
+	_, _, err = mockStorage.GetPrice(context.Background(), "BTC", now)
+	require.NoError(t, err)
+
+	mock.ExpectExec(hotnessUpsert).WithArgs("BTC", int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`
+		SELECT coin, accesses
+		FROM coin_hotness
+		ORDER BY accesses DESC, coin
+		LIMIT $1`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"coin", "accesses"}).AddRow("BTC", int64(42)))
+
+	coins, err := mockStorage.HotCoins(5)
+	require.NoError(t, err)
+	assert.Equal(t, []models.HotCoin{{Coin: "BTC", Accesses: 42}}, coins)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
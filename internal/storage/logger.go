@@ -0,0 +1,11 @@
+package storage
+
+import "log/slog"
+
+// logger returns Logger, or the default logger when it is not set.
+func (s *Storage) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const lruKey = "token:lru"
+
+// PruneLRU removes coins from the LRU set that are neither tracked nor
+// cached anymore. Coins read from the DB are added to the set by GetPrice;
+// once their price key expires nothing else would remove them.
+// Returns the number of removed members.
+func (s *Storage) PruneLRU(ctx context.Context) (int, error) {
+	if s.cacheDisabled() {
+		return 0, nil
+	}
+	members, err := s.Redis.ZRange(ctx, lruKey, 0, -1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("storage.PruneLRU: %v", err)
+	}
+
+	removed := 0
+	for _, coin := range members {
+		s.mutex.RLock()
+		_, active := s.ActiveCoins[coin]
+		s.mutex.RUnlock()
+		if active {
+			continue
+		}
+
+		exists, err := s.Redis.Exists(ctx, fmt.Sprintf("token:%s", coin)).Result()
+		if err != nil {
+			return removed, fmt.Errorf("storage.PruneLRU: %v", err)
+		}
+		if exists > 0 {
+			continue
+		}
+
+		if err := s.Redis.ZRem(ctx, lruKey, coin).Err(); err != nil {
+			return removed, fmt.Errorf("storage.PruneLRU: %v", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// startLRUReconcile prunes stale LRU members every redis.cache_ttl until shutdown.
+func (s *Storage) startLRUReconcile() {
+	ticker := time.NewTicker(s.cacheTTL())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := s.PruneLRU(context.Background())
+			if err != nil {
+				s.logger().Error("LRU reconciliation failed", "err", err)
+				continue
+			}
+			if removed > 0 {
+				s.logger().Info("LRU reconciliation removed stale coins", "removed", removed)
+			}
+		case <-s.Shutdwn:
+			return
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"test-task1/internal/metrics"
+	"test-task1/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Names of the match strategies accepted by ParseMatchStrategy.
+const (
+	MatchNearest     = "nearest"
+	MatchLastBefore  = "last_before"
+	MatchFirstAfter  = "first_after"
+	MatchInterpolate = "interpolate"
+)
+
+// MatchStrategy decides which stored price answers a query for a timestamp.
+// Stores look up the two points around the timestamp, before (the latest
+// point at or before it) and after (the earliest point at or after it),
+// either of which may be nil, and the strategy picks or derives the answer.
+type MatchStrategy interface {
+	Name() string
+	// Match returns the answer for timestamp and the timestamp of the point
+	// it is taken from; ok is false if the neighbors cannot answer it.
+	Match(timestamp int64, before, after *models.PricePoint) (point models.PricePoint, ok bool)
+}
+
+// ParseMatchStrategy returns the strategy with the given name; an empty name
+// selects NearestMatch.
+func ParseMatchStrategy(name string) (MatchStrategy, error) {
+	switch name {
+	case "", MatchNearest:
+		return NearestMatch{}, nil
+	case MatchLastBefore:
+		return LastBefore{}, nil
+	case MatchFirstAfter:
+		return FirstAfter{}, nil
+	case MatchInterpolate:
+		return Interpolate{}, nil
+	}
+	return nil, fmt.Errorf("unknown match strategy %q", name)
+}
+
+// NearestMatch answers with the point closest in time, the earlier one on a tie.
+type NearestMatch struct{}
+
+func (NearestMatch) Name() string { return MatchNearest }
+
+func (NearestMatch) Match(timestamp int64, before, after *models.PricePoint) (models.PricePoint, bool) {
+	switch {
+	case before == nil && after == nil:
+		return models.PricePoint{}, false
+	case after == nil:
+		return *before, true
+	case before == nil:
+		return *after, true
+	case after.Timestamp-timestamp < timestamp-before.Timestamp:
+		return *after, true
+	}
+	return *before, true
+}
+
+// LastBefore answers with the last price known at the timestamp, as a trader
+// at that moment would have seen it.
+type LastBefore struct{}
+
+func (LastBefore) Name() string { return MatchLastBefore }
+
+func (LastBefore) Match(timestamp int64, before, after *models.PricePoint) (models.PricePoint, bool) {
+	if before == nil {
+		return models.PricePoint{}, false
+	}
+	return *before, true
+}
+
+// FirstAfter answers with the first price collected at or after the timestamp.
+type FirstAfter struct{}
+
+func (FirstAfter) Name() string { return MatchFirstAfter }
+
+func (FirstAfter) Match(timestamp int64, before, after *models.PricePoint) (models.PricePoint, bool) {
+	if after == nil {
+		return models.PricePoint{}, false
+	}
+	return *after, true
+}
+
+// Interpolate answers with the price linearly interpolated between the points
+// on both sides of the timestamp. It needs both of them.
+type Interpolate struct{}
+
+func (Interpolate) Name() string { return MatchInterpolate }
+
+func (Interpolate) Match(timestamp int64, before, after *models.PricePoint) (models.PricePoint, bool) {
+	if before == nil || after == nil {
+		return models.PricePoint{}, false
+	}
+	if after.Timestamp == before.Timestamp {
+		return *before, true
+	}
+	weight := float64(timestamp-before.Timestamp) / float64(after.Timestamp-before.Timestamp)
+	return models.PricePoint{
+		Timestamp: timestamp,
+		Price:     before.Price + (after.Price-before.Price)*weight,
+	}, true
+}
+
+// storedPoint reports whether the strategy answers with stored points rather
+// than prices derived from them.
+func storedPoint(match MatchStrategy) bool {
+	_, interpolated := match.(Interpolate)
+	return !interpolated
+}
+
+// matchCache answers the query from the cached points within cacheWindow of
+// the timestamp. Both neighbors are read in one round trip.
+func (s *Storage) matchCache(ctx context.Context, key string, timestamp int64, match MatchStrategy) (models.PricePoint, error) {
+	if s.cacheDisabled() {
+		return models.PricePoint{}, errors.New("no cached data: cache disabled")
+	}
+	window := s.Config.CollConf.Units(cacheWindow)
+	ts := strconv.FormatInt(timestamp, 10)
+
+	pipe := s.Redis.Pipeline()
+	beforeCmd := pipe.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   strconv.FormatInt(timestamp-window, 10),
+		Max:   ts,
+		Count: memberScanCount,
+	})
+	afterCmd := pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   ts,
+		Max:   strconv.FormatInt(timestamp+window, 10),
+		Count: memberScanCount,
+	})
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return models.PricePoint{}, fmt.Errorf("no cached data: %v", err)
+	}
+
+	before := firstMember(key, beforeCmd.Val())
+	after := firstMember(key, afterCmd.Val())
+
+	point, ok := match.Match(timestamp, before, after)
+	if !ok {
+		return models.PricePoint{}, errors.New("no cached data")
+	}
+	return point, nil
+}
+
+// firstMember parses the first well-formed of the cache members of key,
+// skipping malformed ones, or returns nil if there is none.
+func firstMember(key string, members []string) *models.PricePoint {
+	for _, member := range members {
+		point, err := parseMember(member)
+		if err != nil {
+			skipMalformed(key, err)
+			continue
+		}
+		return &point
+	}
+	return nil
+}
+
+// matchDB answers the query from PostgreSQL. NearestMatch keeps its single
+// query; the other strategies read the neighbors with two index lookups.
+// sql.ErrNoRows is returned if the neighbors cannot answer the query.
+func (s *Storage) matchDB(ctx context.Context, coin string, timestamp int64, match MatchStrategy) (models.PricePoint, error) {
+	if _, nearest := match.(NearestMatch); nearest {
+		price, ts, err := s.getFromDB(ctx, coin, timestamp)
+		return models.PricePoint{Timestamp: ts, Price: price}, err
+	}
+	if s.cacheOnly() {
+		return models.PricePoint{}, sql.ErrNoRows
+	}
+
+	defer metrics.TimeDBQuery(metrics.QueryNeighbors).ObserveDuration()
+	before, err := s.neighborFromDB(ctx, `
+		SELECT price, timestamp
+		FROM currencies
+		WHERE coin = $1 AND timestamp <= $2
+		ORDER BY timestamp DESC
+		LIMIT 1`, coin, timestamp)
+	if err != nil {
+		return models.PricePoint{}, err
+	}
+	after, err := s.neighborFromDB(ctx, `
+		SELECT price, timestamp
+		FROM currencies
+		WHERE coin = $1 AND timestamp >= $2
+		ORDER BY timestamp
+		LIMIT 1`, coin, timestamp)
+	if err != nil {
+		return models.PricePoint{}, err
+	}
+
+	point, ok := match.Match(timestamp, before, after)
+	if !ok {
+		return models.PricePoint{}, sql.ErrNoRows
+	}
+	return point, nil
+}
+
+// neighborFromDB runs a query for one neighbor, returning nil if there is none.
+func (s *Storage) neighborFromDB(ctx context.Context, query, coin string, timestamp int64) (*models.PricePoint, error) {
+	var p models.PricePoint
+	err := s.DB.QueryRowContext(ctx, query, coin, timestamp).Scan(&p.Price, &p.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,134 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// Test each strategy against the neighbors of a timestamp
+func TestMatchStrategies(t *testing.T) {
+	before := &models.PricePoint{Timestamp: 100, Price: 10}
+	after := &models.PricePoint{Timestamp: 140, Price: 20}
+
+	tests := []struct {
+		name          string
+		match         storage.MatchStrategy
+		before, after *models.PricePoint
+		want          models.PricePoint
+		ok            bool
+	}{
+		{"nearest picks the closer point", storage.NearestMatch{}, before, after, *after, true},
+		{"nearest with one side", storage.NearestMatch{}, before, nil, *before, true},
+		{"nearest without points", storage.NearestMatch{}, nil, nil, models.PricePoint{}, false},
+		{"last before", storage.LastBefore{}, before, after, *before, true},
+		{"last before without earlier point", storage.LastBefore{}, nil, after, models.PricePoint{}, false},
+		{"first after", storage.FirstAfter{}, before, after, *after, true},
+		{"first after without later point", storage.FirstAfter{}, before, nil, models.PricePoint{}, false},
+		{"interpolate", storage.Interpolate{}, before, after, models.PricePoint{Timestamp: 130, Price: 17.5}, true},
+		{"interpolate on a point", storage.Interpolate{}, after, after, *after, true},
+		{"interpolate needs both sides", storage.Interpolate{}, before, nil, models.PricePoint{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.match.Match(130, tt.before, tt.after)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseMatchStrategy(t *testing.T) {
+	for _, name := range []string{"nearest", "last_before", "first_after", "interpolate"} {
+		match, err := storage.ParseMatchStrategy(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, match.Name())
+	}
+
+	match, err := storage.ParseMatchStrategy("")
+	require.NoError(t, err)
+	assert.Equal(t, storage.NearestMatch{}, match)
+
+	_, err = storage.ParseMatchStrategy("closest")
+	assert.Error(t, err)
+}
+
+// Test every strategy answers from a seeded cache
+func TestGetPriceWithCache(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{DBConf: models.DatabaseCfg{CacheOnly: true}},
+		Redis:  rdb,
+	}
+	t0 := time.Now().Unix() - 600
+	mockStorage.UpdateCache(context.Background(), "BTC", 100, t0)
+	mockStorage.UpdateCache(context.Background(), "BTC", 160, t0+60)
+	mockStorage.UpdateCache(context.Background(), "BTC", 130, t0+120)
+
+	tests := []struct {
+		match     storage.MatchStrategy
+		timestamp int64
+		want      float64
+	}{
+		{storage.NearestMatch{}, t0 + 15, 100},
+		{storage.NearestMatch{}, t0 + 45, 160},
+		{storage.LastBefore{}, t0 + 45, 100},
+		{storage.LastBefore{}, t0 + 60, 160},
+		{storage.FirstAfter{}, t0 + 15, 160},
+		{storage.Interpolate{}, t0 + 15, 115},
+		{storage.Interpolate{}, t0 + 90, 145},
+	}
+	for _, tt := range tests {
+		point, source, err := mockStorage.GetPriceWith(context.Background(), "BTC", tt.timestamp, tt.match)
+		require.NoError(t, err, tt.match.Name())
+		assert.Equal(t, tt.want, point.Price, "%s at t0+%d", tt.match.Name(), tt.timestamp-t0)
+		assert.Equal(t, storage.SourceCache, source)
+	}
+
+	// Nothing is cached after the last point
+	for _, match := range []storage.MatchStrategy{storage.FirstAfter{}, storage.Interpolate{}} {
+		_, _, err := mockStorage.GetPriceWith(context.Background(), "BTC", t0+150, match)
+		assert.ErrorIs(t, err, storage.ErrNotFound, match.Name())
+	}
+}
+
+// Test strategies other than nearest read both neighbors from PostgreSQL
+func TestGetPriceWithDB(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{DB: db, Redis: rdb}
+
+	columns := []string{"price", "timestamp"}
+	mock.ExpectQuery(`
+		SELECT price, timestamp
+		FROM currencies
+		WHERE coin = $1 AND timestamp <= $2
+		ORDER BY timestamp DESC
+		LIMIT 1`).
+		WithArgs("BTC", int64(1736500490)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(48000.0, int64(1736500400)))
+	mock.ExpectQuery(`
+		SELECT price, timestamp
+		FROM currencies
+		WHERE coin = $1 AND timestamp >= $2
+		ORDER BY timestamp
+		LIMIT 1`).
+		WithArgs("BTC", int64(1736500490)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(49000.0, int64(1736500500)))
+
+	point, source, err := mockStorage.GetPriceWith(context.Background(), "BTC", 1736500490, storage.Interpolate{})
+	require.NoError(t, err)
+	assert.Equal(t, 48900.0, point.Price)
+	assert.Equal(t, int64(1736500490), point.Timestamp)
+	assert.Equal(t, storage.SourceDB, source)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
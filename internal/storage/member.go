@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"test-task1/internal/metrics"
+	"test-task1/models"
+	"time"
+)
+
+const (
+	// memberScanCount is how many cache members on each side of a queried
+	// timestamp are read, so a few malformed ones can be skipped.
+	memberScanCount = 8
+	// malformedLogInterval is the minimum time between two logged warnings
+	// about malformed cache members.
+	malformedLogInterval = time.Minute
+)
+
+var (
+	malformedMu         sync.Mutex
+	malformedLast       time.Time
+	malformedSuppressed int
+)
+
+// cacheMember is a price point in a coin's cache, stored as a JSON sorted set
+// member such as {"t":1736500490,"p":48523.42} scored by its timestamp.
+type cacheMember struct {
+	Timestamp int64   `json:"t"`
+	Price     float64 `json:"p"`
+}
+
+// formatMember encodes a price point as a cache member.
+func formatMember(timestamp int64, price float64) string {
+	b, _ := json.Marshal(cacheMember{Timestamp: timestamp, Price: price})
+	return string(b)
+}
+
+// parseMember parses a cache member. Members cached before the JSON encoding
+// are "timestamp:price" and are still read until they expire.
+func parseMember(member string) (models.PricePoint, error) {
+	if !strings.HasPrefix(member, "{") {
+		return parseLegacyMember(member)
+	}
+	var m struct {
+		Timestamp *int64   `json:"t"`
+		Price     *float64 `json:"p"`
+	}
+	if err := json.Unmarshal([]byte(member), &m); err != nil {
+		return models.PricePoint{}, fmt.Errorf("malformed cache member %q: %v", member, err)
+	}
+	if m.Timestamp == nil || m.Price == nil {
+		return models.PricePoint{}, fmt.Errorf("malformed cache member %q", member)
+	}
+	return models.PricePoint{Timestamp: *m.Timestamp, Price: *m.Price}, nil
+}
+
+// parseLegacyMember parses a "timestamp:price" cache member.
+func parseLegacyMember(member string) (models.PricePoint, error) {
+	parts := strings.Split(member, ":")
+	if len(parts) != 2 {
+		return models.PricePoint{}, fmt.Errorf("malformed cache member %q", member)
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return models.PricePoint{}, fmt.Errorf("malformed cache member %q: %v", member, err)
+	}
+	price, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return models.PricePoint{}, fmt.Errorf("malformed cache member %q: %v", member, err)
+	}
+	return models.PricePoint{Timestamp: timestamp, Price: price}, nil
+}
+
+// skipMalformed counts a cache member of key that could not be parsed and
+// logs a warning about it, at most one per malformedLogInterval.
+func skipMalformed(key string, err error) {
+	metrics.CacheMalformedMembers.Inc()
+
+	malformedMu.Lock()
+	defer malformedMu.Unlock()
+	if time.Since(malformedLast) < malformedLogInterval {
+		malformedSuppressed++
+		return
+	}
+	malformedLast = time.Now()
+	suppressed := malformedSuppressed
+	malformedSuppressed = 0
+	slog.Warn("skipping malformed cache member", "key", key, "err", err, "suppressed", suppressed)
+}
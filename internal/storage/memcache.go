@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"test-task1/models"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// SourceMemory is reported by GetPrice for results of the in-process cache.
+const SourceMemory = "memory"
+
+type memKey struct {
+	coin      string
+	timestamp int64
+	match     string // MatchStrategy name
+}
+
+// memCache returns the in-process cache of historical GetPrice results,
+// or nil if query.memory_cache_size is 0.
+func (s *Storage) memCache() *lru.Cache[memKey, models.PricePoint] {
+	size := s.Config.QueryConf.MemoryCacheSize
+	if size <= 0 {
+		return nil
+	}
+	s.memOnce.Do(func() {
+		s.mem, _ = lru.New[memKey, models.PricePoint](size)
+	})
+	return s.mem
+}
+
+// rememberPrice stores the result of a query for timestamp if it can no
+// longer change: points are collected at the current time, so once the
+// matched point is closer to the query than the current time is, no future
+// point can become the nearest one. Interpolated results lie between two
+// stored points and are final as well.
+func (s *Storage) rememberPrice(coin string, timestamp int64, match MatchStrategy, point models.PricePoint) {
+	cache := s.memCache()
+	if cache == nil {
+		return
+	}
+	if abs(timestamp-point.Timestamp) >= s.Config.CollConf.Now()-timestamp {
+		return
+	}
+	cache.Add(memKey{coin: coin, timestamp: timestamp, match: match.Name()}, point)
+}
+
+// purgeMemCache drops remembered results after stored history was rewritten.
+func (s *Storage) purgeMemCache() {
+	if cache := s.memCache(); cache != nil {
+		cache.Purge()
+	}
+}
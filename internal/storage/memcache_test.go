@@ -0,0 +1,97 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// Test repeated historical queries are answered in process
+func TestMemoryCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{QueryConf: models.QueryCfg{MemoryCacheSize: 10}},
+		DB:     db,
+		Redis:  rdb,
+	}
+
+	historical := time.Now().Unix() - 3600
+	mock.ExpectQuery("SELECT price, timestamp FROM currencies").
+		WithArgs("BTC", historical).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, historical-2))
+
+	point, source, err := mockStorage.GetPrice(context.Background(), "BTC", historical)
+	require.NoError(t, err)
+	assert.Equal(t, historical-2, point.Timestamp)
+	assert.Equal(t, storage.SourceDB, source)
+
+	// Neither Redis nor the database is asked again
+	require.NoError(t, rdb.FlushAll(rdb.Context()).Err())
+	for i := 0; i < 3; i++ {
+		cached, source, err := mockStorage.GetPrice(context.Background(), "BTC", historical)
+		require.NoError(t, err)
+		assert.Equal(t, storage.SourceMemory, source)
+		assert.Equal(t, point, cached)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test results that a newly collected point could still change are not remembered
+func TestMemoryCacheSkipsRecent(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{QueryConf: models.QueryCfg{MemoryCacheSize: 10}},
+		DB:     db,
+		Redis:  rdb,
+	}
+
+	// The nearest point is 100s away but the query is only 10s old
+	recent := time.Now().Unix() - 10
+	mockStorage.UpdateCache(context.Background(), "BTC", 50000, recent-100)
+
+	for i := 0; i < 2; i++ {
+		_, source, err := mockStorage.GetPrice(context.Background(), "BTC", recent)
+		require.NoError(t, err)
+		assert.Equal(t, storage.SourceCache, source)
+	}
+}
+
+func BenchmarkGetPriceHistorical(b *testing.B) {
+	historical := time.Now().Unix() - 3600
+
+	for _, size := range []int{0, 1024} {
+		name := "redis"
+		if size > 0 {
+			name = "memory"
+		}
+		b.Run(name, func(b *testing.B) {
+			_, rdb := newTestRedis(b)
+			mockStorage := &storage.Storage{
+				Config: models.Config{QueryConf: models.QueryCfg{MemoryCacheSize: size}},
+				Redis:  rdb,
+			}
+			mockStorage.UpdateCache(context.Background(), "BTC", 50000, historical)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := mockStorage.GetPrice(context.Background(), "BTC", historical); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
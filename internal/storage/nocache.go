@@ -0,0 +1,14 @@
+package storage
+
+import "errors"
+
+// ErrCacheDisabled is returned by operations that only work on the Redis
+// cache when Redis could not be reached on startup.
+var ErrCacheDisabled = errors.New("not available without the Redis cache")
+
+// cacheDisabled reports whether Redis was unreachable on startup and the
+// instance runs from PostgreSQL only. Cache reads then miss and cache writes
+// do nothing.
+func (s *Storage) cacheDisabled() bool {
+	return s.Redis == nil
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// partitionCurrencies turns currencies into a table partitioned by range of
+// timestamp. Existing rows, and rows of months without their own partition,
+// are kept in currencies_default; the primary key becomes (id, timestamp)
+// because Postgres requires it to include the partition key. The columns are
+// copied from the old table, so they follow whatever the migrations made of it.
+const partitionCurrencies = `
+	ALTER TABLE currencies RENAME TO currencies_default;
+	ALTER TABLE currencies_default DROP CONSTRAINT currencies_pkey;
+	ALTER INDEX idx_currencies_coin_timestamp RENAME TO currencies_default_coin_timestamp_idx;
+
+	CREATE TABLE currencies (LIKE currencies_default INCLUDING DEFAULTS) PARTITION BY RANGE (timestamp);
+	ALTER TABLE currencies ADD PRIMARY KEY (id, timestamp);
+
+	ALTER SEQUENCE currencies_id_seq OWNED BY currencies.id;
+	ALTER TABLE currencies_default ALTER COLUMN id DROP DEFAULT;
+
+	CREATE INDEX idx_currencies_coin_timestamp ON currencies (coin, timestamp);
+	ALTER TABLE currencies ATTACH PARTITION currencies_default DEFAULT;`
+
+// EnsurePartitioned converts currencies into a partitioned table unless it
+// is one already. The conversion runs in one transaction holding an
+// exclusive lock on currencies, which blocks reads and writes of prices,
+// and attaching the old table as the default partition scans all its rows,
+// so it takes a while on a large history. It is only needed once.
+// Returns whether the table was converted.
+func (s *Storage) EnsurePartitioned() (bool, error) {
+	const op = "storage.EnsurePartitioned"
+	if s.cacheOnly() {
+		return false, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	var partitioned bool
+	err := s.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'currencies'::regclass)",
+	).Scan(&partitioned)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", op, err)
+	}
+	if partitioned {
+		return false, nil
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", op, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(partitionCurrencies); err != nil {
+		return false, fmt.Errorf("%s: %v", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("%s: %v", op, err)
+	}
+	return true, nil
+}
+
+const (
+	partitionInterval = time.Hour
+	// partitionsAhead is the number of months after the current one whose
+	// partitions are created in advance.
+	partitionsAhead = 2
+	// partitionLayout names the monthly partitions of currencies.
+	partitionLayout = "currencies_2006_01"
+)
+
+// MaintainPartitions creates the partitions of currencies for the months
+// after now and, with partition_retention, drops the partitions whose month
+// ended more than the retention before now. Rows of the default partition
+// older than the retention are deleted as well.
+// The partition of the current month is never created: rows of that month
+// may already be in the default partition, which Postgres refuses to split.
+// Parameters:
+// - now: the current time
+// Returns the names of the created and the dropped partitions.
+func (s *Storage) MaintainPartitions(now time.Time) (created, dropped []string, err error) {
+	const op = "storage.MaintainPartitions"
+	if s.cacheOnly() {
+		return nil, nil, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	month := monthStart(now)
+	for i := 1; i <= partitionsAhead; i++ {
+		from := month.AddDate(0, i, 0)
+		name := from.Format(partitionLayout)
+		_, err := s.DB.Exec(fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s PARTITION OF currencies FOR VALUES FROM (%d) TO (%d)",
+			name, s.Config.CollConf.Timestamp(from), s.Config.CollConf.Timestamp(from.AddDate(0, 1, 0)),
+		))
+		if err != nil {
+			return created, nil, fmt.Errorf("%s (create %s): %v", op, name, err)
+		}
+		created = append(created, name)
+	}
+
+	retention := s.Config.DBConf.PartitionRetention
+	if retention <= 0 {
+		return created, nil, nil
+	}
+	cutoff := now.Add(-retention)
+
+	names, err := s.partitions()
+	if err != nil {
+		return created, nil, fmt.Errorf("%s: %v", op, err)
+	}
+	for _, name := range names {
+		from, err := time.Parse(partitionLayout, name)
+		if err != nil {
+			// not a monthly partition, e.g. currencies_default
+			continue
+		}
+		if from.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+		if _, err := s.DB.Exec("DROP TABLE IF EXISTS " + name); err != nil {
+			return created, dropped, fmt.Errorf("%s (drop %s): %v", op, name, err)
+		}
+		dropped = append(dropped, name)
+	}
+
+	res, err := s.DB.Exec("DELETE FROM currencies_default WHERE timestamp < $1", s.Config.CollConf.Timestamp(cutoff))
+	if err != nil {
+		return created, dropped, fmt.Errorf("%s: %v", op, err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return created, dropped, fmt.Errorf("%s: %v", op, err)
+	}
+	if rows > 0 {
+		s.logger().Info("deleted expired prices from currencies_default", "rows", rows, "before", cutoff.Format(time.RFC3339))
+	}
+
+	if len(dropped) > 0 || rows > 0 {
+		s.purgeMemCache()
+	}
+	return created, dropped, nil
+}
+
+// partitions returns the names of the partitions of currencies.
+func (s *Storage) partitions() ([]string, error) {
+	rows, err := s.DB.Query(`
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'currencies'::regclass
+		ORDER BY c.relname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// startPartitioning runs MaintainPartitions at start and then every
+// partitionInterval until shutdown.
+func (s *Storage) startPartitioning() {
+	ticker := time.NewTicker(partitionInterval)
+	defer ticker.Stop()
+
+	for {
+		_, dropped, err := s.MaintainPartitions(time.Now())
+		if err != nil {
+			s.logger().Error("partition maintenance failed", "err", err)
+		} else if len(dropped) > 0 {
+			s.logger().Info("dropped partitions", "partitions", dropped)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.Shutdwn:
+			return
+		}
+	}
+}
+
+// monthStart returns the start of the month of t in UTC.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,171 @@
+package storage_test
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+const partitionsQuery = `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'currencies'::regclass
+		ORDER BY c.relname`
+
+func createPartition(name string, from, to int64) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF currencies FOR VALUES FROM (%d) TO (%d)", name, from, to)
+}
+
+func TestMaintainPartitionsCreate(t *testing.T) {
+	now := time.Date(2025, time.January, 10, 9, 14, 50, 0, time.UTC)
+	// A price collected in February must fall into the February partition
+	february := time.Date(2025, time.February, 28, 23, 59, 59, 0, time.UTC)
+
+	for _, tc := range []struct {
+		precision string
+		scale     int64
+	}{
+		{models.PrecisionSeconds, 1},
+		{models.PrecisionMilliseconds, 1000},
+	} {
+		t.Run(tc.precision, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer db.Close()
+
+			cfg := models.CollectorCfg{TimestampPrecision: tc.precision}
+			mockStorage := &storage.Storage{
+				Config: models.Config{CollConf: cfg, DBConf: models.DatabaseCfg{PartitionByMonth: true}},
+				DB:     db,
+			}
+
+			from, to := int64(1738368000)*tc.scale, int64(1740787200)*tc.scale
+			mock.ExpectExec(createPartition("currencies_2025_02", from, to)).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(createPartition("currencies_2025_03", to, int64(1743465600)*tc.scale)).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			created, dropped, err := mockStorage.MaintainPartitions(now)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"currencies_2025_02", "currencies_2025_03"}, created)
+			assert.Empty(t, dropped)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			ts := cfg.Timestamp(february)
+			assert.True(t, from <= ts && ts < to, "%d is outside [%d, %d)", ts, from, to)
+			ts = cfg.Timestamp(february.Add(time.Second))
+			assert.False(t, ts < to, "%d belongs to March", ts)
+		})
+	}
+}
+
+func TestMaintainPartitionsDrop(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{
+		Config: models.Config{DBConf: models.DatabaseCfg{
+			PartitionByMonth:   true,
+			PartitionRetention: 30 * 24 * time.Hour,
+		}},
+		DB: db,
+	}
+
+	now := time.Date(2025, time.January, 11, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec(createPartition("currencies_2025_02", 1738368000, 1740787200)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(createPartition("currencies_2025_03", 1740787200, 1743465600)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(partitionsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("currencies_2024_10").
+			AddRow("currencies_2024_11").
+			AddRow("currencies_2024_12").
+			AddRow("currencies_2025_02").
+			AddRow("currencies_2025_03").
+			AddRow("currencies_default"))
+	// The retention ends on 2024-12-12: November ended before it, December did not
+	mock.ExpectExec("DROP TABLE IF EXISTS currencies_2024_10").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS currencies_2024_11").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM currencies_default WHERE timestamp < $1").
+		WithArgs(int64(1733961600)).
+		WillReturnResult(sqlmock.NewResult(0, 42))
+
+	_, dropped, err := mockStorage.MaintainPartitions(now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"currencies_2024_10", "currencies_2024_11"}, dropped)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMaintainPartitionsCacheOnly(t *testing.T) {
+	mockStorage := &storage.Storage{Config: models.Config{DBConf: models.DatabaseCfg{CacheOnly: true}}}
+
+	_, _, err := mockStorage.MaintainPartitions(time.Now())
+	assert.ErrorIs(t, err, storage.ErrCacheOnly)
+}
+
+func TestEnsurePartitioned(t *testing.T) {
+	const partitionedQuery = "SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'currencies'::regclass)"
+
+	t.Run("plain table", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+		mockStorage := &storage.Storage{DB: db}
+
+		mock.ExpectQuery(regexp.QuoteMeta(partitionedQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE currencies RENAME TO currencies_default;.*CREATE TABLE currencies \\(LIKE currencies_default INCLUDING DEFAULTS\\) PARTITION BY RANGE \\(timestamp\\)").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		converted, err := mockStorage.EnsurePartitioned()
+		require.NoError(t, err)
+		assert.True(t, converted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("already partitioned", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+		mockStorage := &storage.Storage{DB: db}
+
+		mock.ExpectQuery(regexp.QuoteMeta(partitionedQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		converted, err := mockStorage.EnsurePartitioned()
+		require.NoError(t, err)
+		assert.False(t, converted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed conversion is rolled back", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+		mockStorage := &storage.Storage{DB: db}
+
+		mock.ExpectQuery(regexp.QuoteMeta(partitionedQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE currencies").WillReturnError(fmt.Errorf("lock timeout"))
+		mock.ExpectRollback()
+
+		_, err = mockStorage.EnsurePartitioned()
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultPruneInterval = time.Hour
+
+// Prune deletes every price older than database.retention from the
+// currencies table.
+// Parameters:
+// - now: the current Unix timestamp in the configured precision
+// Returns the number of deleted rows.
+func (s *Storage) Prune(now int64) (int64, error) {
+	const op = "storage.Prune"
+	if s.cacheOnly() {
+		return 0, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	cutoff := now - s.Config.CollConf.Units(s.Config.DBConf.Retention)
+	res, err := s.DB.Exec("DELETE FROM currencies WHERE timestamp < $1", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	if rows > 0 {
+		// Queries near the pruned prices now resolve to other rows
+		s.purgeMemCache()
+	}
+	return rows, nil
+}
+
+// startPruning runs Prune every database.prune_interval until shutdown.
+func (s *Storage) startPruning() {
+	ticker := time.NewTicker(s.pruneInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rows, err := s.Prune(s.Config.CollConf.Now())
+			if err != nil {
+				s.logger().Error("pruning failed", "err", err)
+				continue
+			}
+			s.logger().Info("pruned old prices", "rows", rows)
+		case <-s.Shutdwn:
+			return
+		}
+	}
+}
+
+func (s *Storage) pruneInterval() time.Duration {
+	if s.Config.DBConf.PruneInterval <= 0 {
+		return defaultPruneInterval
+	}
+	return s.Config.DBConf.PruneInterval
+}
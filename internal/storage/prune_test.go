@@ -0,0 +1,53 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+func TestPrune(t *testing.T) {
+	for _, tc := range []struct {
+		precision string
+		now       int64
+		cutoff    int64
+	}{
+		{models.PrecisionSeconds, 1736500490, 1735895690},
+		{models.PrecisionMilliseconds, 1736500490123, 1735895690123},
+	} {
+		t.Run(tc.precision, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer db.Close()
+
+			mockStorage := &storage.Storage{
+				Config: models.Config{
+					CollConf: models.CollectorCfg{TimestampPrecision: tc.precision},
+					DBConf:   models.DatabaseCfg{Retention: 7 * 24 * time.Hour},
+				},
+				DB: db,
+			}
+
+			mock.ExpectExec("DELETE FROM currencies WHERE timestamp < $1").
+				WithArgs(tc.cutoff).
+				WillReturnResult(sqlmock.NewResult(0, 17))
+
+			rows, err := mockStorage.Prune(tc.now)
+			require.NoError(t, err)
+			assert.Equal(t, int64(17), rows)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPruneCacheOnly(t *testing.T) {
+	mockStorage := &storage.Storage{Config: models.Config{DBConf: models.DatabaseCfg{CacheOnly: true}}}
+
+	_, err := mockStorage.Prune(time.Now().Unix())
+	assert.ErrorIs(t, err, storage.ErrCacheOnly)
+}
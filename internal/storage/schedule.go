@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"test-task1/internal/metrics"
+	"test-task1/models"
+)
+
+// schedule returns the parsed collector.schedule, nil if collection always runs.
+func (s *Storage) schedule() *models.Schedule {
+	s.schedOnce.Do(func() {
+		sched, err := models.ParseSchedule(s.Config.CollConf.Schedule, s.Config.CollConf.ScheduleTimezone)
+		if err != nil {
+			// Validated on load, so this only happens for hand-built configs
+			s.logger().Warn("ignoring collector.schedule", "err", err)
+			return
+		}
+		s.sched = sched
+	})
+	return s.sched
+}
+
+// scheduled reports whether collectors should fetch now. Outside the
+// collector.schedule windows ticks are skipped, saving API quota.
+func (s *Storage) scheduled() bool {
+	if s.schedule().Contains(s.clock().Now()) {
+		metrics.CollectorPaused.Set(0)
+		return true
+	}
+	metrics.CollectorPaused.Set(1)
+	return false
+}
@@ -0,0 +1,102 @@
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// fakeClock is a Clock the test moves by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Test collection pauses outside the schedule and resumes inside it
+func TestCollectSchedule(t *testing.T) {
+	// Saturday, outside the weekday window
+	clock := &fakeClock{now: time.Date(2025, 1, 11, 12, 0, 0, 0, time.UTC)}
+	src := &countingSource{}
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf: models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{
+				Interval:     10 * time.Millisecond,
+				CollectOnAdd: true,
+				Schedule:     []string{"Mon-Fri 09:30-16:00"},
+			},
+		},
+		Source:      src,
+		Clock:       clock,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, src.calls.Load())
+	count, err := rdb.ZCard(context.Background(), "token:BTC").Result()
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	// Monday morning
+	clock.Set(time.Date(2025, 1, 13, 9, 30, 0, 0, time.UTC))
+	assert.Eventually(t, func() bool {
+		return src.calls.Load() > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestScheduleContains(t *testing.T) {
+	sched, err := models.ParseSchedule([]string{"Mon-Fri 09:30-16:00", "Sat 22:00-02:00"}, "America/New_York")
+	require.NoError(t, err)
+
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"weekday open", time.Date(2025, 1, 13, 9, 30, 0, 0, ny), true},
+		{"weekday before open", time.Date(2025, 1, 13, 9, 29, 0, 0, ny), false},
+		{"weekday close", time.Date(2025, 1, 13, 16, 0, 0, 0, ny), false},
+		{"open in UTC", time.Date(2025, 1, 13, 15, 0, 0, 0, time.UTC), true},
+		{"saturday night", time.Date(2025, 1, 11, 23, 0, 0, 0, ny), true},
+		{"past midnight", time.Date(2025, 1, 12, 1, 59, 0, 0, ny), true},
+		{"sunday afternoon", time.Date(2025, 1, 12, 12, 0, 0, 0, ny), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sched.Contains(tt.at))
+		})
+	}
+
+	for _, invalid := range []string{"9:30", "Mon-Fri 09:30", "Funday 09:00-10:00", "10:00-10:00", "09:00-25:00"} {
+		_, err := models.ParseSchedule([]string{invalid}, "UTC")
+		assert.Error(t, err, invalid)
+	}
+	_, err = models.ParseSchedule([]string{"09:00-17:00"}, "Mars/Olympus")
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"test-task1/models"
+	coinbase "test-task1/pkg/coinbase-api"
+	kraken "test-task1/pkg/kraken-api"
+	"time"
+)
+
+// PriceSource fetches the current price of a coin from an exchange.
+// Cancelling ctx aborts the request in flight. SupportedCoins lists the
+// coins the exchange can price. kraken.Source is the default one.
+type PriceSource interface {
+	GetPrice(ctx context.Context, coin string) (float64, error)
+	SupportedCoins() []string
+}
+
+// TimestampedSource is a PriceSource that also reports when the price was
+// traded on the exchange. Collectors store such prices at the trade time
+// instead of the time they were fetched.
+type TimestampedSource interface {
+	PriceSource
+	GetPriceAt(ctx context.Context, coin string) (float64, time.Time, error)
+}
+
+// fetchPrice fetches the current price of the coin from src with its trade
+// time, which is zero when src does not report one.
+func fetchPrice(ctx context.Context, src PriceSource, coin string) (float64, time.Time, error) {
+	if ts, ok := src.(TimestampedSource); ok {
+		return ts.GetPriceAt(ctx, coin)
+	}
+	price, err := src.GetPrice(ctx, coin)
+	return price, time.Time{}, err
+}
+
+// source returns the configured price source, falling back to Kraken.
+func (s *Storage) source() PriceSource {
+	if s.Source == nil {
+		return defaultSource()
+	}
+	return s.Source
+}
+
+// defaultSource is the price source of collectors when none is configured.
+// It is the only place the collectors depend on the Kraken package.
+func defaultSource() PriceSource {
+	return kraken.Source{}
+}
+
+// newPriceSource returns the price source selected by collector.price_source,
+// or a CompositeSource of collector.price_sources when they are set.
+func newPriceSource(c models.CollectorCfg) PriceSource {
+	if len(c.PriceSources) == 0 {
+		return exchangeSource(c.PriceSource)
+	}
+	sources := make([]NamedSource, 0, len(c.PriceSources))
+	for _, name := range c.PriceSources {
+		sources = append(sources, NamedSource{Name: name, PriceSource: exchangeSource(name)})
+	}
+	return NewCompositeSource(sources, c.SourceTimeout, c.Aggregate)
+}
+
+// NewCompareSources returns the comparison sources of
+// collector.compare_sources keyed by exchange name, or nil without any.
+// New assigns them to Storage.CompareSources.
+func NewCompareSources(c models.CollectorCfg) map[string]PriceSource {
+	if len(c.CompareSources) == 0 {
+		return nil
+	}
+	sources := make(map[string]PriceSource, len(c.CompareSources))
+	for _, name := range c.CompareSources {
+		sources[name] = exchangeSource(name)
+	}
+	return sources
+}
+
+// exchangeSource returns the price source of the named exchange, Kraken by
+// default. Coinbase prices bare coins in the same quote currency as Kraken.
+func exchangeSource(name string) PriceSource {
+	if name == models.PriceSourceCoinbase {
+		return coinbase.NewSource("", kraken.Quote())
+	}
+	return defaultSource()
+}
+
+// primarySource returns the name of the exchange prices are collected from,
+// or the names of the combined ones joined by "+".
+func (s *Storage) primarySource() string {
+	if len(s.Config.CollConf.PriceSources) > 0 {
+		return strings.Join(s.Config.CollConf.PriceSources, "+")
+	}
+	if s.Config.CollConf.PriceSource == "" {
+		return models.PriceSourceKraken
+	}
+	return s.Config.CollConf.PriceSource
+}
+
+// SupportedCoins returns the coins the configured price source can price.
+func (s *Storage) SupportedCoins() []string {
+	return s.source().SupportedCoins()
+}
+
+// krakenHistory is the default HistorySource backed by the Kraken OHLC endpoint.
+type krakenHistory struct{}
+
+func (krakenHistory) GetOHLC(ctx context.Context, coin string, since int64) ([]models.Candle, error) {
+	return kraken.GetOHLC(ctx, coin, since)
+}
+
+// history returns the configured history source, falling back to Kraken.
+func (s *Storage) history() HistorySource {
+	if s.History == nil {
+		return krakenHistory{}
+	}
+	return s.History
+}
@@ -9,51 +9,130 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"log"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"log/slog"
+	"math"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"test-task1/internal/metrics"
 	"test-task1/models"
 	kraken "test-task1/pkg/kraken-api"
 	"time"
 )
 
 const (
-	migrationPath = "file://migrations"
-	cacheTTL      = 10 * time.Minute
+	migrationPath   = "file://migrations"
+	defaultCacheTTL = 10 * time.Minute
 	//errorCacheTTL       = 1 * time.Minute
-	priceUpdateInterval = 5 * time.Second
-	dataRetention       = 4 * time.Hour
-	maxTokenCount       = 100
+	priceUpdateInterval  = 5 * time.Second
+	defaultDataRetention = 4 * time.Hour
+	cacheWindow          = 5 * time.Minute // max distance between a query and a cached point
+	maxTokenCount        = 100
+	healthCheckTimeout   = 2 * time.Second
+	pairsRetryInterval   = 5 * time.Second
+)
+
+// ErrUnhealthy is returned by AddCurrency when a store it needs is unreachable.
+var ErrUnhealthy = errors.New("storage dependency unavailable")
+
+// ErrTooManyCoins is returned by AddCurrency once collector.max_coins coins are tracked.
+var ErrTooManyCoins = errors.New("too many tracked coins")
+
+// Price sources reported by GetPrice.
+const (
+	SourceCache = "cache"
+	SourceDB    = "db"
 )
 
 type Storage struct {
+	Config      models.Config
+	Source      PriceSource
+	History     HistorySource
+	Clock       Clock
+	Logger      *slog.Logger // slog.Default() when nil
 	DB          *sql.DB
 	Redis       *redis.Client
-	ActiveCoins map[string]chan struct{}
+	ActiveCoins map[string]struct{}
 	Shutdwn     chan struct{}
-	wg          sync.WaitGroup
-	mutex       sync.RWMutex
+	depthCoins  map[string]context.CancelFunc
+	aliases     map[string]string // old symbol -> symbol its history was merged into
+	backfills   map[string]*models.BackfillJob
+	lastUpdate  map[string]time.Time // coin -> time of its last collected price
+	lastPrices  map[string]models.PriceUpdate
+	lastSaved   map[string]float64 // coin -> last price inserted into the database
+	subscribers map[string]map[chan models.PriceUpdate]struct{}
+
+	// CompareSources are collected alongside Source for /currency/compare, keyed by name
+	CompareSources map[string]PriceSource
+
+	downsampledUntil int64 // rows below this timestamp are already downsampled
+	downsampleLoaded bool  // downsampledUntil was read from downsample_watermark
+	draining         atomic.Bool
+	starting         atomic.Bool // set by New until the Kraken pairs are loaded
+	mem              *lru.Cache[memKey, models.PricePoint]
+	memOnce          sync.Once
+
+	hotness map[string]int64 // coin -> price queries not yet flushed to the database
+	hotMu   sync.Mutex
+
+	sched     *models.Schedule
+	schedOnce sync.Once
+
+	running   map[string]int // collector name -> running goroutines, see goCollector
+	runningMu sync.Mutex
+
+	collectors    map[string]*coinCollector
+	collectorOnce sync.Once
+	added         chan struct{} // wakes the collector loop for added coins
+
+	root       context.Context // cancelled by ShutdownContext, see rootContext
+	cancelRoot context.CancelFunc
+	rootOnce   sync.Once
+
+	wg    sync.WaitGroup
+	mutex sync.RWMutex
 }
 
-func initRedis(config models.Config) (*redis.Client, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     config.RDBConf.RedisAddress,
-		Password: config.RDBConf.RedisPassword,
-		DB:       config.RDBConf.RedisDB,
+// NewRedisClient returns a client of the single Redis node at RedisAddress,
+// or a failover client following the Sentinels when MasterName is set.
+func NewRedisClient(c models.Redis) *redis.Client {
+	if c.MasterName != "" {
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       c.MasterName,
+			SentinelAddrs:    c.SentinelAddresses,
+			SentinelPassword: c.SentinelPassword,
+			Password:         c.RedisPassword,
+			DB:               c.RedisDB,
+			PoolSize:         c.PoolSize,
+			MinIdleConns:     c.MinIdleConns,
+		})
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:         c.RedisAddress,
+		Password:     c.RedisPassword,
+		DB:           c.RedisDB,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
 	})
+}
+
+func initRedis(config models.Config) (*redis.Client, error) {
+	rdb := NewRedisClient(config.RDBConf)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if _, err := rdb.ConfigSet(ctx, "maxmemory", "100mb").Result(); err != nil {
-		log.Printf("Warning: failed to set Redis maxmemory: %v", err)
+		slog.Warn("failed to set Redis maxmemory", "err", err)
 	}
 	if _, err := rdb.ConfigSet(ctx, "maxmemory-policy", "allkeys-lru").Result(); err != nil {
+		rdb.Close()
 		return nil, fmt.Errorf("failed to configure Redis LRU: %v", err)
 	}
 
 	if _, err := rdb.Ping(ctx).Result(); err != nil {
+		rdb.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
 	}
 	return rdb, nil
@@ -81,48 +160,166 @@ func runMigrations(db *sql.DB) error {
 		if err != migrate.ErrNoChange {
 			return fmt.Errorf("%s: %v", op, err)
 		}
-		log.Println("No migrations to apply.")
+		slog.Info("no migrations to apply")
 	} else {
-		log.Println("Database migrations applied successfully.")
+		slog.Info("database migrations applied")
 	}
 	return nil
 }
 
+// MigrationStatus returns the applied schema migration version and whether
+// the last migration failed half-way (dirty), as recorded by golang-migrate.
+// A database without migrations reports version 0.
+func (s *Storage) MigrationStatus() (version uint, dirty bool, err error) {
+	const op = "storage.MigrationStatus"
+	if s.cacheOnly() {
+		return 0, false, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+
+	var v int64
+	err = s.DB.QueryRow("SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&v, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %v", op, err)
+	}
+	return uint(v), dirty, nil
+}
+
 // New create new storage with Redis and Postgres
 func New(c models.Config) (*Storage, error) {
 	const op = "storage.connection"
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		c.DBConf.Host, c.DBConf.Port, c.DBConf.User, c.DBConf.Password, c.DBConf.DBName)
 
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %v", op, err)
-	}
+	var db *sql.DB
+	if !c.DBConf.CacheOnly {
+		var err error
+		db, err = sql.Open("postgres", c.DBConf.ConnString())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+		ConfigurePool(db, c.DBConf)
 
-	//Try to connect DB
-	if err = waitForDB(db, 5, 1*time.Second); err != nil {
-		return nil, fmt.Errorf("%s: %v", op, err)
+		//Try to connect DB
+		if err = waitForDB(db, 5, 1*time.Second); err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
 	}
 
 	rdb, err := initRedis(c)
-	if err != nil {
+	switch {
+	case err != nil && (c.RDBConf.CacheRequired || c.DBConf.CacheOnly):
 		return nil, fmt.Errorf("%s (initRedis): %v", op, err)
+	case err != nil:
+		slog.Warn("Redis is unavailable, running without the cache", "err", err)
 	}
 
 	s := &Storage{
-		DB:          db,
-		Redis:       rdb,
-		ActiveCoins: make(map[string]chan struct{}),
-		Shutdwn:     make(chan struct{}),
+		Config:         c,
+		Source:         newBreakerFromConfig(newPriceSource(c.CollConf), c.CollConf),
+		CompareSources: NewCompareSources(c.CollConf),
+		DB:             db,
+		Redis:          rdb,
+		ActiveCoins:    make(map[string]struct{}),
+		Shutdwn:        make(chan struct{}),
 	}
+	s.starting.Store(true)
+
+	if db != nil {
+		if err = runMigrations(db); err != nil {
+			return nil, fmt.Errorf("failed to make migrations: %v", err)
+		}
 
-	if err = runMigrations(db); err != nil {
-		return nil, fmt.Errorf("failed to make migrations: %v", err)
+		// Converted before any collector writes to the table
+		if c.DBConf.PartitionByMonth {
+			start := time.Now()
+			converted, err := s.EnsurePartitioned()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", op, err)
+			}
+			if converted {
+				s.logger().Info("partitioned currencies by month", "took", time.Since(start))
+			}
+		}
+
+		if err = s.loadAliases(); err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+
+		started, err := s.ReloadTracked()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+		s.logger().Info("restarted collectors of tracked coins", "coins", started)
+	}
+
+	// Migrations are done; requests still fail until the pairs are known
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.AwaitPairs(krakenPairsLoaded, pairsRetryInterval)
+	}()
+
+	if rdb != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.startLRUReconcile()
+		}()
+	}
+
+	if db != nil && c.DBConf.DownsampleAfter > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.startDownsampling()
+		}()
+	}
+
+	if db != nil && c.DBConf.Retention > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.startPruning()
+		}()
+	}
+
+	if db != nil && c.DBConf.PartitionByMonth {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.startPartitioning()
+		}()
+	}
+
+	if db != nil && c.QueryConf.WarmHotInterval > 0 && c.CollConf.WarmupPoints > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.startHotWarming()
+		}()
+	}
+
+	if s.persistHotness() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.startHotnessFlush()
+		}()
 	}
 
 	return s, nil
 }
 
+// ConfigurePool applies the connection pool settings of the config to db.
+// Every collector tick inserts one row per tracked coin, so with more tracked
+// coins than MaxOpenConns the inserts of a tick wait for a free connection.
+func ConfigurePool(db *sql.DB, c models.DatabaseCfg) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+}
+
 // waitForDB attempts to reconnect to the database.
 // This is necessary because when running in Docker,
 // the server might try to connect before the database is fully initialized.
@@ -132,123 +329,564 @@ func waitForDB(db *sql.DB, attempts int, delay time.Duration) error {
 		if err == nil {
 			return nil
 		}
-		log.Printf("Waiting for DB... attempt %d/%d: %v", i+1, attempts, err)
+		slog.Info("waiting for the database", "attempt", i+1, "attempts", attempts, "err", err)
 		time.Sleep(delay)
 	}
 	return fmt.Errorf("database is not reachable after %d attempts", attempts)
 }
 
 // AddCurrency adds cryptocurrency to tracking list and starts data collection.
-// If currency is already tracked, does nothing.
+// If currency is already tracked, does nothing. The check and the registration
+// happen under the same lock, so concurrent calls for one coin start exactly
+// one collector. Before the first collection the cache is warmed with the
+// latest stored prices of the coin (see WarmCache). The coin is recorded in
+// tracked_coins, so its collection resumes after a restart (see ReloadTracked);
+// the write happens after the lock is released, and if it fails the coin is
+// unregistered again and the error returned.
+// With collector.reject_unhealthy_adds a new coin is refused with ErrUnhealthy
+// while Redis or PostgreSQL is unreachable, instead of starting a collector
+// that would fail on every tick. Once collector.max_coins coins are tracked
+// a new coin is refused with ErrTooManyCoins.
 // Parameters:
 // - coin: cryptocurrency symbol (e.g. "BTC")
-func (s *Storage) AddCurrency(coin string) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *Storage) AddCurrency(coin string) error {
+	s.mutex.RLock()
+	_, exists := s.ActiveCoins[coin]
+	s.mutex.RUnlock()
+	if exists {
+		return nil
+	}
 
+	if s.Config.CollConf.RejectUnhealthyAdds {
+		if err := s.checkHealth(); err != nil {
+			return fmt.Errorf("storage.AddCurrency: %w", err)
+		}
+	}
+
+	s.mutex.Lock()
 	if _, exists := s.ActiveCoins[coin]; exists {
-		return
+		s.mutex.Unlock()
+		return nil
+	}
+	if limit := s.maxCoins(); len(s.ActiveCoins) >= limit {
+		s.mutex.Unlock()
+		return fmt.Errorf("storage.AddCurrency: %w (%d)", ErrTooManyCoins, limit)
 	}
+	s.startTracking(coin)
+	s.mutex.Unlock()
 
-	stopChan := make(chan struct{})
-	s.ActiveCoins[coin] = stopChan
+	if err := s.saveTracked(coin); err != nil {
+		s.mutex.Lock()
+		s.stopTracking(coin)
+		s.mutex.Unlock()
+		return fmt.Errorf("storage.AddCurrency: %v", err)
+	}
+	return nil
+}
 
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		s.startCollecting(coin, stopChan)
-	}()
+// maxCoins returns collector.max_coins, falling back to maxTokenCount.
+func (s *Storage) maxCoins() int {
+	if s.Config.CollConf.MaxCoins <= 0 {
+		return maxTokenCount
+	}
+	return s.Config.CollConf.MaxCoins
 }
 
-// startCollecting launches the periodic collection of data on the price of cryptocurrencies.
-// Data is collected every 15 seconds via the Kraken API and stored in the database.
-// Works until a stop signal is received via stopChan.
-// Parameters:
-// - coin: the symbolic code of the cryptocurrency
-// - stopChan: the channel for receiving the stop signal
-func (s *Storage) startCollecting(coin string, stopChan <-chan struct{}) {
-	ticker := time.NewTicker(priceUpdateInterval)
+// Tracked reports whether prices of the coin are being collected.
+func (s *Storage) Tracked(coin string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.ActiveCoins[coin]
+	return ok
+}
+
+// HealthCheck pings PostgreSQL and Redis concurrently, each bounded by
+// healthCheckTimeout, and returns "ok" or the error of each dependency keyed
+// by "postgres" and "redis". PostgreSQL is left out in cache-only mode.
+func (s *Storage) HealthCheck(ctx context.Context) map[string]string {
+	status := func(err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		return "ok"
+	}
+	ping := func(ping func(ctx context.Context) error) string {
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		defer cancel()
+		return status(ping(ctx))
+	}
+
+	var (
+		wg       sync.WaitGroup
+		postgres string
+	)
+	if s.DB != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			postgres = ping(s.DB.PingContext)
+		}()
+	}
+	var redisStatus string
+	if !s.cacheDisabled() {
+		redisStatus = ping(func(ctx context.Context) error {
+			return s.Redis.Ping(ctx).Err()
+		})
+	}
+	wg.Wait()
+
+	health := make(map[string]string)
+	if !s.cacheDisabled() {
+		health["redis"] = redisStatus
+	}
+	if s.DB != nil {
+		health["postgres"] = postgres
+	}
+	return health
+}
+
+// checkHealth pings the stores collectors write to.
+func (s *Storage) checkHealth() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	if !s.cacheDisabled() {
+		if err := s.Redis.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("%w: redis: %v", ErrUnhealthy, err)
+		}
+	}
+	if s.DB != nil {
+		if err := s.DB.PingContext(ctx); err != nil {
+			return fmt.Errorf("%w: postgres: %v", ErrUnhealthy, err)
+		}
+	}
+	return nil
+}
+
+// coinCollector is the collector loop's bookkeeping for one tracked coin.
+type coinCollector struct {
+	last     time.Time // when the previous collection of the coin started
+	inFlight bool      // a collection of the coin has not returned yet
+	resumeAt time.Time // after a panic the coin is not collected before this
+	added    bool      // tracked but not warmed up yet
+
+	ctx    context.Context // cancelled when the coin is removed or on shutdown
+	cancel context.CancelFunc
+}
+
+// defaultCollectorWorkers is used when collector.workers is not set.
+const defaultCollectorWorkers = 8
+
+// collectorWorkers returns collector.workers, falling back to defaultCollectorWorkers.
+func (s *Storage) collectorWorkers() int {
+	if n := s.Config.CollConf.Workers; n > 0 {
+		return n
+	}
+	return defaultCollectorWorkers
+}
+
+// collectJob is a collection of one coin handed to a collector worker.
+// An added job warms the coin's cache first and collects only with
+// collector.collect_on_add.
+type collectJob struct {
+	ctx   context.Context
+	coin  string
+	added bool
+}
+
+// startCollector launches the collector loop unless it is running already.
+// The caller must hold s.mutex.
+func (s *Storage) startCollector() {
+	s.collectorOnce.Do(func() {
+		s.added = make(chan struct{}, 1)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runCollector()
+		}()
+	})
+}
+
+// wakeCollector makes the collector loop pick up added coins without
+// waiting for the next tick. The caller must hold s.mutex.
+func (s *Storage) wakeCollector() {
+	select {
+	case s.added <- struct{}{}:
+	default: // already woken
+	}
+}
+
+// runCollector is the collection of data on the price of cryptocurrencies.
+// Every collector.interval (5s by default) it fetches the price of each
+// tracked coin via the price source (Kraken by default) and stores it in the
+// database. Prices are stored at their trade time when the source reports it
+// (see TimestampedSource); a trade that was already stored is not stored
+// again. Outside the collector.schedule windows nothing is fetched.
+// The coins are fetched by collector.workers goroutines (8 by default), so a
+// slow response delays only the coins behind it; a coin whose previous fetch
+// is still in flight is skipped until the next tick. AddCurrency and
+// RemoveCurrency only change the set of coins the loop iterates. Works until
+// the storage shuts down.
+func (s *Storage) runCollector() {
+	ticker := time.NewTicker(s.interval())
 	defer ticker.Stop()
 
+	metrics.ActiveCollectors.Inc()
+	defer metrics.ActiveCollectors.Dec()
+
+	jobs := make(chan collectJob)
+	defer close(jobs)
+	for i := 0; i < s.collectorWorkers(); i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for job := range jobs {
+				s.runJob(job)
+			}
+		}()
+	}
+
 	for {
+		var due []collectJob
 		select {
 		case <-ticker.C:
-			price, err := kraken.GetPrice(coin)
-			if err != nil {
-				log.Printf("Failed to get price for %s: %v", coin, err)
+			if !s.scheduled() {
 				continue
 			}
+			due = s.dueCoins(time.Now())
+		case <-s.added:
+			due = s.addedCoins()
+		case <-s.Shutdwn:
+			return
+		}
+		for _, job := range due {
+			select {
+			case jobs <- job:
+			case <-s.Shutdwn:
+				return
+			}
+		}
+	}
+}
 
-			timestamp := time.Now().Unix()
-			log.Printf("%s: %f, %d", coin, price, timestamp)
-			s.SaveCurrency(coin, price, timestamp)
-
-			s.UpdateCache(coin, price, timestamp)
+// runJob runs a collection on a collector worker, counted in the
+// collector_goroutines metric and named after the coin in the collectors
+// ShutdownContext reports as still running.
+func (s *Storage) runJob(job collectJob) {
+	metrics.CollectorGoroutines.Inc()
+	defer metrics.CollectorGoroutines.Dec()
+	s.setRunning(job.coin, 1)
+	defer s.setRunning(job.coin, -1)
 
-		case <-stopChan:
+	if job.added {
+		s.warmCache(job.ctx, job.coin)
+		if !s.Config.CollConf.CollectOnAdd || !s.scheduled() {
+			s.finishCollecting(job.coin, false)
 			return
-		case <-s.Shutdwn:
+		}
+	}
+	s.collectCoin(job.ctx, job.coin)
+}
+
+// dueCoins marks the tracked coins that can be collected at now as in flight
+// and returns their jobs. The lag of each is recorded: how much later than
+// one interval after its previous collection this one starts, e.g. because
+// the fetch was slow.
+func (s *Storage) dueCoins(now time.Time) []collectJob {
+	interval := s.interval()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var due []collectJob
+	for coin := range s.ActiveCoins {
+		c := s.collectors[coin]
+		if c == nil || c.inFlight || now.Before(c.resumeAt) {
+			continue
+		}
+		if !c.last.IsZero() {
+			lag := now.Sub(c.last) - interval
+			if lag < 0 {
+				lag = 0
+			}
+			metrics.CollectorLag.WithLabelValues(coin).Set(lag.Seconds())
+		}
+		c.last = now
+		c.inFlight = true
+		due = append(due, collectJob{ctx: c.ctx, coin: coin})
+	}
+	return due
+}
+
+// addedCoins returns the jobs of the coins added since the last call.
+// They are in flight already, see startTracking.
+func (s *Storage) addedCoins() []collectJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var added []collectJob
+	for coin, c := range s.collectors {
+		if !c.added {
+			continue
+		}
+		c.added = false
+		added = append(added, collectJob{ctx: c.ctx, coin: coin, added: true})
+	}
+	return added
+}
+
+// finishCollecting clears the in-flight mark of the coin. After a panic the
+// coin is skipped for collector.restart_backoff.
+func (s *Storage) finishCollecting(coin string, panicked bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := s.collectors[coin]
+	if c == nil {
+		return // removed while the fetch was in flight
+	}
+	c.inFlight = false
+	if panicked {
+		c.resumeAt = time.Now().Add(s.restartBackoff())
+	}
+}
+
+// LastUpdate returns when a price of the coin was last collected by this
+// process. ok is false if the coin is not tracked or nothing was collected yet.
+func (s *Storage) LastUpdate(coin string) (t time.Time, ok bool) {
+	coin = s.resolveCoin(coin)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	t, ok = s.lastUpdate[coin]
+	return t, ok
+}
+
+func (s *Storage) setLastUpdate(coin string, t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, tracked := s.ActiveCoins[coin]; !tracked {
+		return // removed while the fetch was in flight
+	}
+	if s.lastUpdate == nil {
+		s.lastUpdate = make(map[string]time.Time)
+	}
+	s.lastUpdate[coin] = t
+}
+
+// collect fetches the current price of the coin and stores it at the time it
+// was traded when the source reports one, otherwise at the current time.
+// Fetches cancelled through ctx are dropped silently.
+func (s *Storage) collect(ctx context.Context, coin string) {
+	price, tradedAt, err := fetchPrice(ctx, s.source(), coin)
+	if errors.Is(err, ErrBreakerOpen) || ctx.Err() != nil {
+		return
+	}
+	metrics.SourceRequests.Inc()
+	if err != nil {
+		metrics.SourceRequestFailures.Inc()
+		s.logger().Error("failed to get price", "coin", coin, "err", err)
+		return
+	}
+
+	timestamp := s.Config.CollConf.Now()
+	if !tradedAt.IsZero() {
+		timestamp = s.Config.CollConf.Timestamp(tradedAt)
+		if s.collectedAt(coin, timestamp) {
+			// No trade since the previous collection: the price is still current
+			s.setLastUpdate(coin, time.Now())
 			return
 		}
 	}
+	s.logger().Debug("collected price", "coin", coin, "price", price, "timestamp", timestamp)
+	s.store(ctx, coin, price, timestamp)
+	s.setLastUpdate(coin, time.Now())
+	s.recordPrice(coin, price, timestamp)
+	s.collectComparisons(ctx, coin, timestamp)
+}
+
+// store writes a collected price to the database and the cache according to
+// collector.cache_mode. In write_behind mode the database is authoritative:
+// the cache is only updated after a successful insert. With
+// collector.dedup_prices the insert is skipped when the price is within
+// collector.dedup_epsilon of the last one saved; the cache is updated anyway.
+func (s *Storage) store(ctx context.Context, coin string, price float64, timestamp int64) {
+	var err error
+	if s.unchangedPrice(coin, price) {
+		s.logger().Debug("price unchanged, not saved", "coin", coin, "price", price)
+	} else if err = s.SaveCurrency(ctx, coin, price, timestamp); err != nil {
+		s.logger().Error("failed to save price", "coin", coin, "err", err)
+	} else {
+		s.setLastSaved(coin, price)
+	}
+
+	if s.Config.CollConf.CacheMode != models.CacheModeWriteBehind {
+		s.UpdateCache(ctx, coin, price, timestamp)
+		return
+	}
+	if err == nil {
+		s.UpdateCache(ctx, coin, price, timestamp)
+		return
+	}
+	if s.Config.CollConf.InvalidateCacheOnFailure && !s.cacheDisabled() {
+		// Reads fall through to the database until the next successful write
+		s.Redis.Del(ctx, fmt.Sprintf("token:%s", coin))
+	}
+}
+
+// unchangedPrice reports whether price need not be saved because it is within
+// collector.dedup_epsilon of the last price saved for the coin.
+func (s *Storage) unchangedPrice(coin string, price float64) bool {
+	if !s.Config.CollConf.DedupPrices {
+		return false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	last, ok := s.lastSaved[coin]
+	return ok && math.Abs(s.roundPrice(price)-last) <= s.Config.CollConf.DedupEpsilon
+}
+
+func (s *Storage) setLastSaved(coin string, price float64) {
+	if !s.Config.CollConf.DedupPrices || s.cacheOnly() {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, tracked := s.ActiveCoins[coin]; !tracked {
+		return // removed while the insert was in flight
+	}
+	if s.lastSaved == nil {
+		s.lastSaved = make(map[string]float64)
+	}
+	s.lastSaved[coin] = s.roundPrice(price)
+}
+
+// cacheTTL returns redis.cache_ttl, falling back to defaultCacheTTL.
+func (s *Storage) cacheTTL() time.Duration {
+	if s.Config.RDBConf.CacheTTL <= 0 {
+		return defaultCacheTTL
+	}
+	return s.Config.RDBConf.CacheTTL
+}
+
+// dataRetention returns redis.data_retention, falling back to defaultDataRetention.
+func (s *Storage) dataRetention() time.Duration {
+	if s.Config.RDBConf.DataRetention <= 0 {
+		return defaultDataRetention
+	}
+	return s.Config.RDBConf.DataRetention
+}
+
+// interval returns the configured collection interval, falling back to priceUpdateInterval.
+func (s *Storage) interval() time.Duration {
+	if s.Config.CollConf.Interval <= 0 {
+		return priceUpdateInterval
+	}
+	return s.Config.CollConf.Interval
 }
 
 // UpdateCache updates Redis cache with new price data and cleans expired entries.
+// Every failed command of the pipeline is logged and counted in the
+// cache_write_failures_total metric; a point lost to a connection error is
+// retried once.
 // Parameters:
+// - ctx: cancels the Redis commands
 // - coin: cryptocurrency symbol
 // - price: current price
 // - timestamp: Unix timestamp of price
-func (s *Storage) UpdateCache(coin string, price float64, timestamp int64) {
-	ctx := context.Background()
+func (s *Storage) UpdateCache(ctx context.Context, coin string, price float64, timestamp int64) {
+	if s.cacheDisabled() {
+		return
+	}
 	key := fmt.Sprintf("token:%s", coin)
+	price = s.roundPrice(price)
 
-	//
-	pipe := s.Redis.Pipeline()
-	pipe.ZAdd(ctx, key, &redis.Z{
+	point := &redis.Z{
 		Score:  float64(timestamp),
-		Member: fmt.Sprintf("%d:%f", timestamp, price),
-	})
+		Member: formatMember(timestamp, price),
+	}
+	pipe := s.Redis.Pipeline()
+	addPoint := pipe.ZAdd(ctx, key, point)
 
-	//delete old lines (> 4 hour ago)
-	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(time.Now().Add(-dataRetention).Unix(), 10))
+	//delete old lines (> redis.data_retention ago)
+	cutoff := s.Config.CollConf.Now() - s.Config.CollConf.Units(s.dataRetention())
+	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(cutoff, 10))
 
 	//Add token to LRU
-	pipe.Expire(ctx, key, cacheTTL)
-	pipe.ZAdd(ctx, "token:lru", &redis.Z{
+	pipe.Expire(ctx, key, s.cacheTTL())
+	pipe.ZAdd(ctx, lruKey, &redis.Z{
 		Score:  float64(time.Now().Unix()),
 		Member: coin,
 	})
 
 	//Update LRU on len
-	if count, err := pipe.ZCard(ctx, "token:lru").Result(); err == nil && count > maxTokenCount {
-		pipe.ZPopMin(ctx, "token:lru", 1)
+	if count, err := pipe.ZCard(ctx, lruKey).Result(); err == nil && count > maxTokenCount {
+		pipe.ZPopMin(ctx, lruKey, 1)
 	}
 
-	if _, err := pipe.Exec(ctx); err != nil {
-		log.Printf("Cache update failed for %s: %v", coin, err)
+	// Exec only reports the first failure, so every command is checked
+	cmds, err := pipe.Exec(ctx)
+	if err == nil {
+		return
+	}
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			metrics.CacheWriteFailures.WithLabelValues(cmd.Name()).Inc()
+			s.logger().Error("cache update failed", "coin", coin, "command", cmd.Name(), "err", cmd.Err())
+		}
+	}
+
+	// Trimming, expiry and LRU bookkeeping catch up on the next update, but a
+	// lost point stays lost, so it is retried once unless Redis rejected it
+	var redisErr redis.Error
+	if addPoint.Err() != nil && !errors.As(addPoint.Err(), &redisErr) {
+		if err := s.Redis.ZAdd(ctx, key, point).Err(); err != nil {
+			s.logger().Error("cache update retry failed", "coin", coin, "err", err)
+		}
 	}
 }
 
+// GetFromCache returns the cached price nearest to the timestamp within cacheWindow.
 func (s *Storage) GetFromCache(ctx context.Context, key string, timestamp int64) (float64, error) {
+	point, err := s.matchCache(ctx, key, timestamp, NearestMatch{})
+	return point.Price, err
+}
 
-	members, err := s.Redis.ZRangeByScore(ctx, key, &redis.ZRangeBy{
-		Min: strconv.FormatInt(timestamp-300, 10),
-		Max: strconv.FormatInt(timestamp+300, 10),
-	}).Result()
-
-	if err != nil || len(members) == 0 {
-		return 0, errors.New("no cached data")
+// hasStored reports whether any price of the coin is cached or stored. A
+// failed check counts as stored, so the lookup reports ErrNotFound.
+func (s *Storage) hasStored(ctx context.Context, coin string) bool {
+	if !s.cacheDisabled() {
+		if n, err := s.Redis.Exists(ctx, fmt.Sprintf("token:%s", coin)).Result(); err != nil || n > 0 {
+			return true
+		}
+	}
+	if s.cacheOnly() {
+		return false
 	}
+	defer metrics.TimeDBQuery(metrics.QueryExists).ObserveDuration()
+	var exists bool
+	err := s.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM currencies WHERE coin = $1)", coin).Scan(&exists)
+	return err != nil || exists
+}
 
-	parts := splitMember(members[0])
-	return strconv.ParseFloat(parts[1], 64)
+// existsInDB reports whether the point cached for the coin was persisted.
+func (s *Storage) existsInDB(coin string, timestamp int64) (bool, error) {
+	defer metrics.TimeDBQuery(metrics.QueryExists).ObserveDuration()
+	var exists bool
+	err := s.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM currencies WHERE coin = $1 AND timestamp = $2)",
+		coin, timestamp,
+	).Scan(&exists)
+	return exists, err
 }
 
 //getFromDB gets data from DB
-func (s *Storage) getFromDB(coin string, timestamp int64) (float64, int64, error) {
+func (s *Storage) getFromDB(ctx context.Context, coin string, timestamp int64) (float64, int64, error) {
+	if s.cacheOnly() {
+		return 0, 0, sql.ErrNoRows
+	}
+	defer metrics.TimeDBQuery(metrics.QueryNearest).ObserveDuration()
 	var price float64
 	var dbTimestamp int64
-	err := s.DB.QueryRow(`
+	err := s.DB.QueryRowContext(ctx, `
 		SELECT price, timestamp 
 		FROM currencies 
 		WHERE coin = $1 
@@ -260,95 +898,325 @@ func (s *Storage) getFromDB(coin string, timestamp int64) (float64, int64, error
 	return price, dbTimestamp, err
 }
 
-func splitMember(member string) []string {
-	return strings.Split(member, ":")
-}
-
 // SaveCurrency saves data on the price of cryptocurrencies to the database.
-// In case of a saving error, logs the error, but does not interrupt execution.
+// The price is rounded to store_decimals places if rounding is enabled.
 // Parameters:
+// - ctx: cancels the insert
 // - coin: the symbolic code of the cryptocurrency
 // - price: the current price
 // - timestamp: a timestamp in Unix format
-func (s *Storage) SaveCurrency(coin string, price float64, timestamp int64) {
-	_, err := s.DB.Exec(
-		"INSERT INTO currencies (coin, price, timestamp) VALUES ($1, $2, $3)",
-		coin, price, timestamp,
+// Returns an error if the insert failed.
+func (s *Storage) SaveCurrency(ctx context.Context, coin string, price float64, timestamp int64) error {
+	if s.cacheOnly() {
+		return nil
+	}
+	defer metrics.TimeDBQuery(metrics.QueryInsert).ObserveDuration()
+	_, err := s.DB.ExecContext(ctx,
+		"INSERT INTO currencies (coin, price, timestamp, synthetic) VALUES ($1, $2, $3, $4)",
+		coin, s.roundPrice(price), timestamp, s.Config.KrakenConf.Synthetic,
 	)
 	if err != nil {
-		log.Printf("Failed to save currency: %v", err)
+		return fmt.Errorf("storage.SaveCurrency: %v", err)
 	}
+	return nil
+}
+
+// GetPrice returns the price of the cryptocurrency nearest to the specified
+// time. It is GetPriceWith using NearestMatch.
+func (s *Storage) GetPrice(ctx context.Context, coin string, timestamp int64) (models.PricePoint, string, error) {
+	return s.GetPriceWith(ctx, coin, timestamp, NearestMatch{})
 }
 
-// GetPrice returns the price of the cryptocurrency at the specified time.
-// First it checks the cache in Redis, if not, it searches the database for the nearest value.
+// GetPriceWith returns the price of the cryptocurrency at the specified time
+// as chosen by the match strategy (see MatchStrategy).
+// First it checks the cache in Redis, if not, it searches the database.
 // The found value is cached in Redis for 10 minutes.
+// With query.max_cache_age set, queries near the current time ignore cached
+// points older than the bound. With query.memory_cache_size set, results that
+// can no longer change are also kept in process and returned with SourceMemory.
+// With query.verify_cache_hits enabled, every cache hit is checked against the
+// database; points missing there (SaveCurrency failed) are counted in the
+// cache_hits_without_db_total metric but still returned.
 // Parameters:
-// - coin: the symbolic code of the cryptocurrency
-// - timestamp: a timestamp in Unix format
+// - ctx: cancels the Redis and database queries
+// - coin: the symbolic code of the cryptocurrency (aliases of merged coins are resolved)
+// - timestamp: a Unix timestamp in the configured precision
+// - match: how the stored points around the timestamp answer the query
 // Returns:
-// - price: the price of the cryptocurrency
-// - error: error if the price could not be found
-func (s *Storage) GetPrice(coin string, timestamp int64) (float64, error) {
-	ctx := context.Background()
+// - point: the price of the cryptocurrency and the timestamp of the stored
+// point it was taken from, which differs from the requested one unless a
+// price was stored at exactly that time (interpolated prices keep the
+// requested timestamp)
+// - source: where the price came from (SourceMemory, SourceCache or SourceDB)
+// - error: ErrNotFound or ErrUnsupportedCoin if the price could not be
+// found, ErrBackend if the database failed, ErrPriceTooFar if the point is
+// further than query.max_price_gap from the timestamp
+func (s *Storage) GetPriceWith(ctx context.Context, coin string, timestamp int64, match MatchStrategy) (models.PricePoint, string, error) {
+	return s.GetPriceWithin(ctx, coin, timestamp, match, s.Config.QueryConf.MaxPriceGap)
+}
+
+// Errors of the price lookups; the causes are wrapped along with them.
+var (
+	// ErrNotFound is returned when no stored price answers the query.
+	ErrNotFound = errors.New("price not found")
+	// ErrUnsupportedCoin is returned when nothing is stored for a coin that
+	// is not tracked either, e.g. because of a typo in its symbol.
+	ErrUnsupportedCoin = errors.New("coin is not tracked")
+	// ErrBackend is returned when the database could not be queried.
+	ErrBackend = errors.New("price store unavailable")
+	// ErrPriceTooFar is returned by GetPriceWithin when the stored point answering
+	// a query is further from the requested time than the allowed gap.
+	ErrPriceTooFar = errors.New("no price within tolerance")
+)
+
+// GetPriceWithin is GetPriceWith allowing at most maxGap between the requested
+// time and the point answering it instead of query.max_price_gap; a maxGap of
+// 0 allows any gap. ErrPriceTooFar is returned for points further away.
+func (s *Storage) GetPriceWithin(ctx context.Context, coin string, timestamp int64, match MatchStrategy, maxGap time.Duration) (models.PricePoint, string, error) {
+	point, source, err := s.lookupPrice(ctx, coin, timestamp, match)
+	if err != nil {
+		return models.PricePoint{}, "", err
+	}
+	if maxGap > 0 && abs(timestamp-point.Timestamp) > s.Config.CollConf.Units(maxGap) {
+		return models.PricePoint{}, "", fmt.Errorf("%w of %s", ErrPriceTooFar, maxGap)
+	}
+	return point, source, nil
+}
+
+// lookupPrice finds the point answering the query in the memory cache, Redis
+// or the database, see GetPriceWith.
+func (s *Storage) lookupPrice(ctx context.Context, coin string, timestamp int64, match MatchStrategy) (models.PricePoint, string, error) {
+	coin = s.resolveCoin(coin)
+	s.touch(coin)
 	key := fmt.Sprintf("token:%s", coin)
-	t1 := time.Now().UnixNano() //For time tests
+	start := time.Now()
+
+	if cache := s.memCache(); cache != nil {
+		if point, ok := cache.Get(memKey{coin: coin, timestamp: timestamp, match: match.Name()}); ok {
+			metrics.PriceLookups.WithLabelValues(SourceMemory).Inc()
+			return point, SourceMemory, nil
+		}
+	}
 
 	// Try to take data from cache
-	if result, err := s.GetFromCache(ctx, key, timestamp); err == nil {
-		fmt.Printf("Get from cache, time (ns): %d", time.Now().UnixNano()-t1)
-		return result, nil
+	if point, err := s.matchCache(ctx, key, timestamp, match); err == nil && !s.cacheTooOld(timestamp, point.Timestamp) {
+		if s.Config.QueryConf.VerifyCacheHits && storedPoint(match) {
+			s.verifyCacheHit(coin, point.Timestamp)
+		}
+		s.rememberPrice(coin, timestamp, match, point)
+		metrics.PriceLookups.WithLabelValues(SourceCache).Inc()
+		s.logger().Debug("cache hit", "coin", coin, "latency_ns", time.Since(start).Nanoseconds())
+		return point, SourceCache, nil
 	}
 
-	price, dbTimestamp, err := s.getFromDB(coin, timestamp)
-	if err != nil {
-		return 0, err
+	point, err := s.matchDB(ctx, coin, timestamp, match)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && !s.Tracked(coin) && !s.hasStored(ctx, coin):
+		return models.PricePoint{}, "", fmt.Errorf("%w: %s", ErrUnsupportedCoin, coin)
+	case errors.Is(err, sql.ErrNoRows):
+		return models.PricePoint{}, "", fmt.Errorf("%w: %s", ErrNotFound, coin)
+	case err != nil && ctx.Err() != nil:
+		return models.PricePoint{}, "", ctx.Err()
+	case err != nil:
+		return models.PricePoint{}, "", fmt.Errorf("%w: %v", ErrBackend, err)
 	}
 
 	// Update LRU
-	s.Redis.ZAdd(ctx, "token:lru", &redis.Z{
-		Score:  float64(time.Now().Unix()),
-		Member: coin,
-	})
+	if !s.cacheDisabled() {
+		s.Redis.ZAdd(ctx, lruKey, &redis.Z{
+			Score:  float64(time.Now().Unix()),
+			Member: coin,
+		})
+	}
 
 	// Update cache if data actual
-	if abs(timestamp-dbTimestamp) <= 300 {
-		s.UpdateCache(coin, price, dbTimestamp)
+	if storedPoint(match) && abs(timestamp-point.Timestamp) <= s.Config.CollConf.Units(cacheWindow) {
+		s.UpdateCache(ctx, coin, point.Price, point.Timestamp)
+	}
+	s.rememberPrice(coin, timestamp, match, point)
+	metrics.PriceLookups.WithLabelValues(SourceDB).Inc()
+	s.logger().Debug("database read", "coin", coin, "latency_ns", time.Since(start).Nanoseconds())
+	return point, SourceDB, nil
+}
+
+// cacheTooOld reports whether a cached point must not be served for a query
+// near the current time because it is older than query.max_cache_age. Queries
+// further in the past than the bound are always served from the cache.
+func (s *Storage) cacheTooOld(timestamp, cachedTimestamp int64) bool {
+	if s.Config.QueryConf.MaxCacheAge <= 0 {
+		return false
+	}
+	maxAge := s.Config.CollConf.Units(s.Config.QueryConf.MaxCacheAge)
+	now := s.Config.CollConf.Now()
+	if now-timestamp > maxAge {
+		return false
+	}
+	return now-cachedTimestamp > maxAge
+}
+
+// verifyCacheHit records cache hits that have no corresponding DB row.
+func (s *Storage) verifyCacheHit(coin string, timestamp int64) {
+	if s.cacheOnly() {
+		return
+	}
+	exists, err := s.existsInDB(coin, timestamp)
+	if err != nil {
+		s.logger().Error("failed to verify cache hit", "coin", coin, "timestamp", timestamp, "err", err)
+		return
+	}
+	if !exists {
+		metrics.CacheHitsWithoutDB.Inc()
+		s.logger().Warn("cached price is missing in the database", "coin", coin, "timestamp", timestamp)
+	}
+}
+
+// Drain marks the instance as not ready so load balancers stop routing new
+// traffic to it. Requests keep being served and collection keeps running
+// until Shutdown.
+func (s *Storage) Drain() {
+	s.draining.Store(true)
+}
+
+// Ready reports whether the instance should receive traffic: it is neither
+// starting nor drained.
+func (s *Storage) Ready() bool {
+	return !s.starting.Load() && !s.draining.Load()
+}
+
+// Starting reports whether New is done but the Kraken pairs, without which
+// adds and price lookups of new coins fail, were not loaded yet.
+func (s *Storage) Starting() bool {
+	return s.starting.Load()
+}
+
+// AwaitPairs keeps the instance starting until loaded reports the pairs
+// were loaded, asking it again every interval. It returns early on Shutdown.
+func (s *Storage) AwaitPairs(loaded func() bool, interval time.Duration) {
+	s.starting.Store(true)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for !loaded() {
+		select {
+		case <-s.Shutdwn:
+			return
+		case <-ticker.C:
+		}
 	}
+	s.starting.Store(false)
+	s.logger().Info("instance is ready")
+}
 
-	fmt.Printf("Get from PostgresQL, time (ns): %d", time.Now().UnixNano()-t1)
-	return price, nil
+// krakenPairsLoaded reports whether the Kraken pairs were loaded, fetching
+// them if not: without kraken.pairs_refresh nothing else would retry.
+func krakenPairsLoaded() bool {
+	if kraken.PairsLoaded() {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pairsRetryInterval)
+	defer cancel()
+	if err := kraken.RefreshPairs(ctx); err != nil {
+		slog.Warn("failed to load Kraken pairs", "err", err)
+		return false
+	}
+	return true
 }
 
 // Shutdown gracefully stops all background operations.
 func (s *Storage) Shutdown() {
+	s.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is Shutdown giving up on waiting for the background
+// operations once ctx is done. The collectors still running then are logged
+// and the connections are closed regardless; ctx.Err() is returned.
+func (s *Storage) ShutdownContext(ctx context.Context) error {
 	close(s.Shutdwn)
-	s.wg.Wait()
+	s.rootContext()
+	s.cancelRoot()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.logger().Warn("shutdown deadline exceeded", "running_collectors", s.runningCollectors())
+	}
 
-	if err := s.DB.Close(); err != nil {
-		log.Printf("Error closing database: %v", err)
+	s.mutex.Lock()
+	for coin := range s.subscribers {
+		s.closeSubscribers(coin)
+	}
+	s.mutex.Unlock()
+
+	if s.DB != nil {
+		if err := s.DB.Close(); err != nil {
+			s.logger().Error("failed to close the database", "err", err)
+		}
 	}
 
-	if err := s.Redis.Close(); err != nil {
-		log.Printf("Error closing Redis: %v", err)
+	if !s.cacheDisabled() {
+		if err := s.Redis.Close(); err != nil {
+			s.logger().Error("failed to close Redis", "err", err)
+		}
 	}
+	return err
 }
 
-// RemoveCurrency stops tracking cryptocurrency and removes from active list.
+// RemoveCurrency stops tracking cryptocurrency and removes from active list
+// and tracked_coins. Depth snapshots of the coin are stopped as well.
+// Removing a coin that is not tracked does nothing.
+// The coin is deleted from tracked_coins before it is unregistered, so if
+// that fails it stays tracked and the error is returned. Neither the
+// database nor Redis is written under the lock.
 // Parameters:
 // - coin: cryptocurrency symbol to remove
-func (s *Storage) RemoveCurrency(coin string) {
+// Returns whether the coin was tracked.
+func (s *Storage) RemoveCurrency(coin string) (bool, error) {
+	s.mutex.RLock()
+	_, exists := s.ActiveCoins[coin]
+	s.mutex.RUnlock()
+	if exists {
+		if err := s.deleteTracked(coin); err != nil {
+			return false, fmt.Errorf("storage.RemoveCurrency: %v", err)
+		}
+	}
+
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.removeDepth(coin)
+	if !s.stopTracking(coin) {
+		s.mutex.Unlock()
+		return false, nil
+	}
+	delete(s.lastUpdate, coin)
+	delete(s.lastPrices, coin)
+	delete(s.lastSaved, coin)
+	s.closeSubscribers(coin)
+	s.mutex.Unlock()
 
-	if stopChan, exists := s.ActiveCoins[coin]; exists {
-		close(stopChan)
-		delete(s.ActiveCoins, coin)
+	if !s.cacheDisabled() {
 		ctx := context.Background()
 		//delete from redis
-		s.Redis.ZRem(ctx, "token:lru", coin)
+		s.Redis.ZRem(ctx, lruKey, coin)
 		s.Redis.Del(ctx, fmt.Sprintf("token:%s", coin))
 	}
+	return true, nil
+}
+
+// roundPrice rounds price to the configured number of decimal places.
+// Prices are returned unchanged when store_decimals is 0.
+func (s *Storage) roundPrice(price float64) float64 {
+	decimals := s.Config.CollConf.StoreDecimals
+	if decimals <= 0 {
+		return price
+	}
+	p := math.Pow10(decimals)
+	return math.Round(price*p) / p
 }
 
 func abs(n int64) int64 {
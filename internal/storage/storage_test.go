@@ -1,23 +1,46 @@
 package storage_test
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"test-task1/internal/metrics"
 	"test-task1/internal/storage"
+	"test-task1/models"
+	kraken_api "test-task1/pkg/kraken-api"
 )
 
+// newTestRedis starts an in-memory Redis server for the duration of the test
+func newTestRedis(t testing.TB) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
 // Test adding new currency to tracking
 func TestAddCurrency(t *testing.T) {
-	db, _, err := sqlmock.New()
+	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
@@ -25,11 +48,12 @@ func TestAddCurrency(t *testing.T) {
 	mockStorage := &storage.Storage{
 		DB:          db,
 		Redis:       rdb,
-		ActiveCoins: make(map[string]chan struct{}),
+		ActiveCoins: make(map[string]struct{}),
 		Shutdwn:     make(chan struct{}),
 	}
 	// Add currency and verify it's tracked
-	mockStorage.AddCurrency("BTC")
+	mock.ExpectExec("INSERT INTO tracked_coins").WithArgs("BTC").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
 
 	_, exists := mockStorage.ActiveCoins["BTC"]
 	require.True(t, exists, "BTC should be in ActiveCoins")
@@ -38,26 +62,158 @@ func TestAddCurrency(t *testing.T) {
 	mockStorage.RemoveCurrency("BTC")
 }
 
-// Test price retrieval from database
+// slowSource is a PriceSource that takes longer than a collection interval to answer
+type slowSource struct {
+	delay time.Duration
+}
+
+func (s slowSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	time.Sleep(s.delay)
+	return 50000, nil
+}
+
+func (slowSource) SupportedCoins() []string { return nil }
+
+// Test collection lag is recorded when the price source is slower than the interval
+func TestCollectorLag(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	rdb := redis.NewClient(&redis.Options{})
+	mockStorage := &storage.Storage{
+		Config:      models.Config{CollConf: models.CollectorCfg{Interval: 20 * time.Millisecond}},
+		Source:      slowSource{delay: 60 * time.Millisecond},
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+
+	mock.ExpectExec("INSERT INTO tracked_coins").WithArgs("LAG").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, mockStorage.AddCurrency("LAG"))
+	defer mockStorage.Shutdown()
+
+	// Each collection starts ~40ms later than scheduled
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.CollectorLag.WithLabelValues("LAG")) >= 0.03
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// Test the collector records when it last collected a price of the coin
+func TestLastUpdate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config:      models.Config{CollConf: models.CollectorCfg{Interval: 10 * time.Millisecond}},
+		Source:      slowSource{},
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	_, ok := mockStorage.LastUpdate("BTC")
+	assert.False(t, ok)
+
+	start := time.Now()
+	mock.ExpectExec("INSERT INTO tracked_coins").WithArgs("BTC").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	assert.Eventually(t, func() bool {
+		last, ok := mockStorage.LastUpdate("BTC")
+		return ok && !last.Before(start)
+	}, 2*time.Second, 5*time.Millisecond)
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectExec("DELETE FROM tracked_coins").WithArgs("BTC").WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err = mockStorage.RemoveCurrency("BTC")
+	require.NoError(t, err)
+	_, ok = mockStorage.LastUpdate("BTC")
+	assert.False(t, ok)
+}
+
+// Test concurrent adds of overlapping symbols track each coin once and start
+// a single collector loop that runs until shutdown
+func TestAddCurrencyConcurrent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	rdb := redis.NewClient(&redis.Options{})
+	mockStorage := &storage.Storage{
+		Config:      models.Config{CollConf: models.CollectorCfg{Interval: time.Hour}},
+		Source:      slowSource{},
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	baseline := testutil.ToFloat64(metrics.ActiveCollectors)
+
+	coins := []string{"BTC", "ETH", "SOL"}
+	mock.MatchExpectationsInOrder(false)
+	for range coins {
+		mock.ExpectExec("INSERT INTO tracked_coins").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mockStorage.AddCurrency(coins[i%len(coins)])
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, mockStorage.ActiveCoins, len(coins))
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.ActiveCollectors)-baseline == 1
+	}, time.Second, 10*time.Millisecond)
+
+	for _, coin := range coins {
+		mockStorage.RemoveCurrency(coin)
+	}
+	assert.Equal(t, baseline+1, testutil.ToFloat64(metrics.ActiveCollectors))
+
+	mockStorage.Shutdown()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.ActiveCollectors) == baseline
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Test removing a coin stops tracking it and reports whether it was tracked
 func TestRemoveCurrency(t *testing.T) {
-	db, _, err := sqlmock.New()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	require.NoError(t, err)
 	defer db.Close()
 
-	// Test successful price fetch
 	rdb := redis.NewClient(&redis.Options{})
-	stopChan := make(chan struct{})
 	mockStorage := &storage.Storage{
 		DB:          db,
 		Redis:       rdb,
-		ActiveCoins: map[string]chan struct{}{"ETH": stopChan},
+		ActiveCoins: map[string]struct{}{"ETH": {}},
 		Shutdwn:     make(chan struct{}),
 	}
 
-	mockStorage.RemoveCurrency("ETH")
+	mock.ExpectExec("DELETE FROM tracked_coins WHERE coin = $1").
+		WithArgs("ETH").
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
+	removed, err := mockStorage.RemoveCurrency("ETH")
+	require.NoError(t, err)
+	assert.True(t, removed)
 	_, exists := mockStorage.ActiveCoins["ETH"]
 	assert.False(t, exists, "ETH should be removed from ActiveCoins")
+
+	// Removing it again is a no-op that touches nothing
+	removed, err = mockStorage.RemoveCurrency("ETH")
+	require.NoError(t, err)
+	assert.False(t, removed)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestGetPrice(t *testing.T) {
@@ -87,9 +243,10 @@ func TestGetPrice(t *testing.T) {
 			WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).
 				AddRow(expectedPrice, expectedTimestamp)) // Full query omitted for brevity
 
-		price, err := mockStorage.GetPrice("BTC", testTime)
+		point, source, err := mockStorage.GetPrice(context.Background(), "BTC", testTime)
 		assert.NoError(t, err)
-		assert.Equal(t, expectedPrice, price)
+		assert.Equal(t, expectedPrice, point.Price)
+		assert.Equal(t, storage.SourceDB, source)
 	})
 
 	// Test not found case
@@ -104,9 +261,98 @@ func TestGetPrice(t *testing.T) {
 			WithArgs("UNKNOWN", testTime).
 			WillReturnError(sql.ErrNoRows)
 
-		_, err := mockStorage.GetPrice("UNKNOWN", testTime)
+		_, _, err := mockStorage.GetPrice(context.Background(), "UNKNOWN", testTime)
 		assert.Error(t, err)
 	})
+
+	// A cancelled request does not reach the database
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, _, err := mockStorage.GetPrice(ctx, "BTC", time.Now().Unix())
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetPriceErrors(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: map[string]struct{}{"BTC": {}},
+	}
+	nearest := `
+			SELECT price, timestamp 
+			FROM currencies 
+			WHERE coin = $1 
+			ORDER BY ABS(timestamp - $2) 
+			LIMIT 1`
+	testTime := time.Now().Unix()
+
+	t.Run("tracked coin without prices", func(t *testing.T) {
+		mock.ExpectQuery(nearest).WithArgs("BTC", testTime).WillReturnError(sql.ErrNoRows)
+
+		_, _, err := mockStorage.GetPrice(context.Background(), "BTC", testTime)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		assert.NotErrorIs(t, err, storage.ErrUnsupportedCoin)
+	})
+
+	t.Run("unknown coin", func(t *testing.T) {
+		mock.ExpectQuery(nearest).WithArgs("BTCC", testTime).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT EXISTS (SELECT 1 FROM currencies WHERE coin = $1)").
+			WithArgs("BTCC").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		_, _, err := mockStorage.GetPrice(context.Background(), "BTCC", testTime)
+		assert.ErrorIs(t, err, storage.ErrUnsupportedCoin)
+	})
+
+	t.Run("database failure", func(t *testing.T) {
+		mock.ExpectQuery(nearest).WithArgs("BTC", testTime).WillReturnError(errors.New("connection refused"))
+
+		_, _, err := mockStorage.GetPrice(context.Background(), "BTC", testTime)
+		assert.ErrorIs(t, err, storage.ErrBackend)
+		assert.NotErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the pool settings of the config are applied to the connection
+func TestConfigurePool(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage.ConfigurePool(db, models.DatabaseCfg{MaxOpenConns: 7, MaxIdleConns: 2, ConnMaxLifetime: time.Minute})
+	assert.Equal(t, 7, db.Stats().MaxOpenConnections)
+}
+
+// Test the pool settings reach the client, also behind Sentinel
+func TestNewRedisClient(t *testing.T) {
+	mr, _ := newTestRedis(t)
+
+	rdb := storage.NewRedisClient(models.Redis{RedisAddress: mr.Addr(), PoolSize: 4, MinIdleConns: 1})
+	defer rdb.Close()
+	assert.Equal(t, 4, rdb.Options().PoolSize)
+	assert.Equal(t, 1, rdb.Options().MinIdleConns)
+	assert.NoError(t, rdb.Ping(context.Background()).Err())
+
+	failover := storage.NewRedisClient(models.Redis{
+		RedisAddress:      mr.Addr(),
+		MasterName:        "mymaster",
+		SentinelAddresses: []string{"127.0.0.1:1"},
+		PoolSize:          4,
+	})
+	defer failover.Close()
+	assert.Equal(t, 4, failover.Options().PoolSize)
+	assert.NotEqual(t, mr.Addr(), failover.Options().Addr)
 }
 
 func TestSaveCurrency(t *testing.T) {
@@ -123,11 +369,54 @@ func TestSaveCurrency(t *testing.T) {
 	testTime := time.Now().Unix()
 	testPrice := 50000.0
 
-	mock.ExpectExec("INSERT INTO currencies (coin, price, timestamp) VALUES ($1, $2, $3)").
-		WithArgs("BTC", testPrice, testTime).
+	mock.ExpectExec("INSERT INTO currencies (coin, price, timestamp, synthetic) VALUES ($1, $2, $3, $4)").
+		WithArgs("BTC", testPrice, testTime, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(t, mockStorage.SaveCurrency(context.Background(), "BTC", testPrice, testTime))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test prices collected from a non-production host are marked as synthetic
+func TestSaveCurrencySynthetic(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{
+		Config: models.Config{KrakenConf: models.KrakenCfg{Synthetic: true}},
+		DB:     db,
+	}
+
+	testTime := time.Now().Unix()
+	mock.ExpectExec("INSERT INTO currencies (coin, price, timestamp, synthetic) VALUES ($1, $2, $3, $4)").
+		WithArgs("BTC", 50000.0, testTime, true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mockStorage.SaveCurrency(context.Background(), "BTC", 50000, testTime)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveCurrencyRounding(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	rdb := redis.NewClient(&redis.Options{})
+	mockStorage := &storage.Storage{
+		Config: models.Config{CollConf: models.CollectorCfg{StoreDecimals: 2}},
+		DB:     db,
+		Redis:  rdb,
+	}
+
+	testTime := time.Now().Unix()
+
+	// Price must be rounded before it reaches the database
+	mock.ExpectExec("INSERT INTO currencies (coin, price, timestamp, synthetic) VALUES ($1, $2, $3, $4)").
+		WithArgs("BTC", 50000.13, testTime, false).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	mockStorage.SaveCurrency("BTC", testPrice, testTime)
+	mockStorage.SaveCurrency(context.Background(), "BTC", 50000.12987654, testTime)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -142,12 +431,12 @@ func TestShutdown(t *testing.T) {
 	mockStorage := &storage.Storage{
 		DB:          db,
 		Redis:       rdb,
-		ActiveCoins: make(map[string]chan struct{}),
+		ActiveCoins: make(map[string]struct{}),
 		Shutdwn:     make(chan struct{}),
 	}
 
-	mockStorage.ActiveCoins["ETH"] = make(chan struct{})
-	mockStorage.ActiveCoins["BTC"] = make(chan struct{})
+	mockStorage.ActiveCoins["ETH"] = struct{}{}
+	mockStorage.ActiveCoins["BTC"] = struct{}{}
 
 	mockStorage.Shutdown()
 
@@ -161,7 +450,7 @@ func TestCacheOperations(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	rdb := redis.NewClient(&redis.Options{})
+	_, rdb := newTestRedis(t)
 	mockStorage := &storage.Storage{
 		DB:    db,
 		Redis: rdb,
@@ -172,9 +461,9 @@ func TestCacheOperations(t *testing.T) {
 	testPrice := 50000.0
 	coin := "BTC"
 
-	mockStorage.UpdateCache(coin, testPrice, testTime)
+	mockStorage.UpdateCache(context.Background(), coin, testPrice, testTime)
 
-	member := fmt.Sprintf("%d:%f", testTime, testPrice)
+	member := fmt.Sprintf(`{"t":%d,"p":%v}`, testTime, testPrice)
 	key := fmt.Sprintf("token:%s", coin)
 	results, err := rdb.ZRangeByScore(ctx, key, &redis.ZRangeBy{
 		Min: strconv.FormatInt(testTime-1, 10),
@@ -187,3 +476,1201 @@ func TestCacheOperations(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, testPrice, price)
 }
+
+// Test prices round-trip through the cache unchanged and members cached in
+// the old "timestamp:price" encoding are still read
+func TestCacheMemberEncoding(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{Redis: rdb}
+
+	ctx := context.Background()
+	testTime := time.Now().Unix()
+	for i, price := range []float64{50000, 0.000012345678, 1e-9, 123456789.987654321} {
+		timestamp := testTime + int64(i)*1000
+		mockStorage.UpdateCache(ctx, "BTC", price, timestamp)
+
+		got, err := mockStorage.GetFromCache(ctx, "token:BTC", timestamp)
+		require.NoError(t, err)
+		assert.Equal(t, price, got)
+	}
+
+	require.NoError(t, rdb.ZAdd(ctx, "token:ETH", &redis.Z{
+		Score:  float64(testTime),
+		Member: fmt.Sprintf("%d:%f", testTime, 3000.5),
+	}).Err())
+	price, err := mockStorage.GetFromCache(ctx, "token:ETH", testTime)
+	require.NoError(t, err)
+	assert.Equal(t, 3000.5, price)
+
+	// A JSON member missing a field is malformed
+	require.NoError(t, rdb.ZAdd(ctx, "token:SOL", &redis.Z{Score: float64(testTime), Member: `{"t":1}`}).Err())
+	_, err = mockStorage.GetFromCache(ctx, "token:SOL", testTime)
+	assert.Error(t, err)
+}
+
+// Test the cache keeps redis.data_retention of prices and expires after redis.cache_ttl
+func TestCacheRetention(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:  models.DatabaseCfg{CacheOnly: true},
+			RDBConf: models.Redis{CacheTTL: time.Minute, DataRetention: time.Hour},
+		},
+		Redis: rdb,
+	}
+
+	ctx := context.Background()
+	now := time.Now().Unix()
+	mockStorage.UpdateCache(ctx, "BTC", 49000, now-7200)
+	mockStorage.UpdateCache(ctx, "BTC", 49500, now-1800)
+	mockStorage.UpdateCache(ctx, "BTC", 50000, now)
+
+	members, err := rdb.ZRange(ctx, "token:BTC", 0, -1).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf(`{"t":%d,"p":49500}`, now-1800),
+		fmt.Sprintf(`{"t":%d,"p":50000}`, now),
+	}, members)
+	assert.Equal(t, time.Minute, mr.TTL("token:BTC"))
+}
+
+// Test the cached point nearest to the timestamp is returned, not the first of the window
+func TestGetFromCacheNearest(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{Redis: rdb}
+
+	ctx := context.Background()
+	testTime := time.Now().Unix()
+	for offset, price := range map[int64]float64{-290: 1, -120: 2, -10: 3, 30: 4, 250: 5} {
+		mockStorage.UpdateCache(ctx, "BTC", price, testTime+offset)
+	}
+
+	tests := []struct {
+		offset int64
+		price  float64
+	}{
+		{-300, 1},
+		{-200, 2},
+		{-60, 3},
+		{0, 3},
+		{15, 4},
+		{200, 5},
+	}
+	for _, tc := range tests {
+		price, err := mockStorage.GetFromCache(ctx, "token:BTC", testTime+tc.offset)
+		require.NoError(t, err)
+		assert.Equal(t, tc.price, price, "offset %d", tc.offset)
+	}
+}
+
+// Test malformed cache members are skipped instead of failing the lookup
+func TestGetFromCacheMalformedMembers(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{Redis: rdb}
+
+	ctx := context.Background()
+	testTime := time.Now().Unix()
+	key := "token:BTC"
+	require.NoError(t, rdb.ZAdd(ctx, key,
+		&redis.Z{Score: float64(testTime + 2), Member: "garbage"},
+		&redis.Z{Score: float64(testTime - 1), Member: fmt.Sprintf("%d:not-a-price", testTime-1)},
+		&redis.Z{Score: float64(testTime - 2), Member: fmt.Sprintf("%d:%f", testTime-2, 50000.0)},
+		&redis.Z{Score: float64(testTime + 1), Member: "1:2:3"},
+	).Err())
+
+	baseline := testutil.ToFloat64(metrics.CacheMalformedMembers)
+	price, err := mockStorage.GetFromCache(ctx, key, testTime)
+	require.NoError(t, err)
+	assert.Equal(t, 50000.0, price)
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.CacheMalformedMembers)-baseline)
+
+	// A window holding nothing but garbage is a miss, not a panic
+	require.NoError(t, rdb.ZAdd(ctx, "token:ETH",
+		&redis.Z{Score: float64(testTime), Member: "garbage"},
+		&redis.Z{Score: float64(testTime + 1), Member: ":"},
+	).Err())
+	assert.NotPanics(t, func() {
+		_, err = mockStorage.GetFromCache(ctx, "token:ETH", testTime)
+	})
+	assert.Error(t, err)
+}
+
+// Test sub-second points are cached separately and the cache window is scaled to milliseconds
+func TestCacheMillisecondPrecision(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{CollConf: models.CollectorCfg{TimestampPrecision: models.PrecisionMilliseconds}},
+		DB:     db,
+		Redis:  rdb,
+	}
+
+	ctx := context.Background()
+	key := "token:BTC"
+	testTime := time.Now().UnixMilli()
+
+	// Two points within the same second must not collide
+	mockStorage.UpdateCache(context.Background(), "BTC", 50000, testTime)
+	mockStorage.UpdateCache(context.Background(), "BTC", 50001, testTime+400)
+	count, err := rdb.ZCard(ctx, key).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// 200 seconds away is inside the 5 minute window
+	price, err := mockStorage.GetFromCache(ctx, key, testTime+200_000)
+	assert.NoError(t, err)
+	assert.Contains(t, []float64{50000, 50001}, price)
+
+	// 400 seconds away is outside of it
+	_, err = mockStorage.GetFromCache(ctx, key, testTime+400_000)
+	assert.Error(t, err)
+}
+
+// Test LRU members that are neither tracked nor cached are pruned
+func TestPruneLRU(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mr, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: map[string]struct{}{"BTC": {}},
+	}
+
+	// BTC is tracked, ETH was queried recently and is still cached,
+	// DOGE was queried long ago and its price key has expired
+	for _, coin := range []string{"BTC", "ETH", "DOGE"} {
+		_, err := mr.ZAdd("token:lru", float64(time.Now().Unix()), coin)
+		require.NoError(t, err)
+	}
+	_, err = mr.ZAdd("token:ETH", float64(time.Now().Unix()), "1:1.0")
+	require.NoError(t, err)
+
+	removed, err := mockStorage.PruneLRU(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	members, err := mr.ZMembers("token:lru")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"BTC", "ETH"}, members)
+}
+
+// Test a cache hit without a DB row is detected when verification is enabled
+func TestGetPriceCacheWithoutDB(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{QueryConf: models.QueryCfg{VerifyCacheHits: true}},
+		DB:     db,
+		Redis:  rdb,
+	}
+
+	testTime := time.Now().Unix()
+	mockStorage.UpdateCache(context.Background(), "BTC", 50000, testTime)
+	before := testutil.ToFloat64(metrics.CacheHitsWithoutDB)
+
+	// SaveCurrency failed for this point, so the row does not exist
+	mock.ExpectQuery("SELECT EXISTS (SELECT 1 FROM currencies WHERE coin = $1 AND timestamp = $2)").
+		WithArgs("BTC", testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	point, source, err := mockStorage.GetPrice(context.Background(), "BTC", testTime)
+	require.NoError(t, err)
+	assert.Equal(t, 50000.0, point.Price)
+	assert.Equal(t, storage.SourceCache, source)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.CacheHitsWithoutDB))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test a cached point older than max_cache_age is bypassed for "now" queries
+func TestGetPriceMaxCacheAge(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{QueryConf: models.QueryCfg{MaxCacheAge: 30 * time.Second}},
+		DB:     db,
+		Redis:  rdb,
+	}
+
+	now := time.Now().Unix()
+	aged := now - 120
+	mockStorage.UpdateCache(context.Background(), "BTC", 49000, aged)
+
+	// The query for now falls back to the database
+	mock.ExpectQuery(`
+		SELECT price, timestamp 
+		FROM currencies 
+		WHERE coin = $1 
+		ORDER BY ABS(timestamp - $2) 
+		LIMIT 1`).
+		WithArgs("BTC", now).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, now))
+
+	point, source, err := mockStorage.GetPrice(context.Background(), "BTC", now)
+	require.NoError(t, err)
+	assert.Equal(t, 50000.0, point.Price)
+	assert.Equal(t, storage.SourceDB, source)
+
+	// A historical query is still served from the cache
+	point, source, err = mockStorage.GetPrice(context.Background(), "BTC", aged)
+	require.NoError(t, err)
+	assert.Equal(t, 49000.0, point.Price)
+	assert.Equal(t, storage.SourceCache, source)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test a point further than max_price_gap from the requested time is rejected
+// unless the gap is overridden
+func TestGetPriceMaxGap(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{QueryConf: models.QueryCfg{MaxPriceGap: time.Hour}},
+		DB:     db,
+		Redis:  rdb,
+	}
+
+	testTime := int64(1736500490)
+	query := `
+		SELECT price, timestamp 
+		FROM currencies 
+		WHERE coin = $1 
+		ORDER BY ABS(timestamp - $2) 
+		LIMIT 1`
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(query).
+			WithArgs("BTC", testTime).
+			WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, testTime-7200))
+	}
+
+	_, _, err = mockStorage.GetPrice(context.Background(), "BTC", testTime)
+	assert.ErrorIs(t, err, storage.ErrPriceTooFar)
+
+	point, source, err := mockStorage.GetPriceWithin(context.Background(), "BTC", testTime, storage.NearestMatch{}, 3*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, models.PricePoint{Price: 50000, Timestamp: testTime - 7200}, point)
+	assert.Equal(t, storage.SourceDB, source)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test a drained storage reports not ready, starting from the zero value
+func TestDrain(t *testing.T) {
+	mockStorage := &storage.Storage{}
+	assert.True(t, mockStorage.Ready())
+
+	mockStorage.Drain()
+	assert.False(t, mockStorage.Ready())
+}
+
+// Test a storage without Redis serves prices from the database only
+func TestCacheDisabled(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual), sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := &storage.Storage{
+		DB:          db,
+		ActiveCoins: map[string]struct{}{"BTC": {}},
+	}
+	testTime := time.Now().Unix()
+
+	mock.ExpectQuery(`
+			SELECT price, timestamp 
+			FROM currencies 
+			WHERE coin = $1 
+			ORDER BY ABS(timestamp - $2) 
+			LIMIT 1`).
+		WithArgs("BTC", testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, testTime))
+
+	point, source, err := mockStorage.GetPrice(context.Background(), "BTC", testTime)
+	require.NoError(t, err)
+	assert.Equal(t, 50000.0, point.Price)
+	assert.Equal(t, storage.SourceDB, source)
+
+	assert.NotPanics(t, func() { mockStorage.UpdateCache(context.Background(), "BTC", 50001, testTime+1) })
+	removed, err := mockStorage.PruneLRU(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, removed)
+	_, err = mockStorage.WarmHotCoins(context.Background())
+	assert.ErrorIs(t, err, storage.ErrCacheDisabled)
+
+	mock.ExpectPing()
+	assert.Equal(t, map[string]string{"postgres": "ok"}, mockStorage.HealthCheck(context.Background()))
+
+	mock.ExpectExec("DELETE FROM tracked_coins WHERE coin = $1").WithArgs("BTC").WillReturnResult(sqlmock.NewResult(0, 1))
+	tracked, err := mockStorage.RemoveCurrency("BTC")
+	require.NoError(t, err)
+	assert.True(t, tracked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the instance is not ready until the pairs are loaded
+func TestAwaitPairs(t *testing.T) {
+	t.Run("loaded", func(t *testing.T) {
+		mockStorage := &storage.Storage{Shutdwn: make(chan struct{})}
+		calls := 0
+		mockStorage.AwaitPairs(func() bool {
+			calls++
+			assert.True(t, mockStorage.Starting())
+			assert.False(t, mockStorage.Ready())
+			return calls == 3
+		}, time.Millisecond)
+
+		assert.Equal(t, 3, calls)
+		assert.False(t, mockStorage.Starting())
+		assert.True(t, mockStorage.Ready())
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		mockStorage := &storage.Storage{Shutdwn: make(chan struct{})}
+		close(mockStorage.Shutdwn)
+		mockStorage.AwaitPairs(func() bool { return false }, time.Millisecond)
+
+		assert.False(t, mockStorage.Ready())
+	})
+}
+
+// Test write_behind mode only caches prices that reached the database
+func TestWriteBehind(t *testing.T) {
+	newStorage := func(t *testing.T, invalidate bool) (*storage.Storage, sqlmock.Sqlmock, *redis.Client) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+
+		_, rdb := newTestRedis(t)
+		return &storage.Storage{
+			Config: models.Config{CollConf: models.CollectorCfg{
+				Interval:                 10 * time.Millisecond,
+				CacheMode:                models.CacheModeWriteBehind,
+				InvalidateCacheOnFailure: invalidate,
+			}},
+			Source:      fixedSource{price: 50000},
+			DB:          db,
+			Redis:       rdb,
+			ActiveCoins: make(map[string]struct{}),
+			Shutdwn:     make(chan struct{}),
+		}, mock, rdb
+	}
+	insert := "INSERT INTO currencies (coin, price, timestamp, synthetic) VALUES ($1, $2, $3, $4)"
+	tracked := "INSERT INTO tracked_coins (coin) VALUES ($1) ON CONFLICT DO NOTHING"
+	ctx := context.Background()
+
+	t.Run("failed write is not cached", func(t *testing.T) {
+		mockStorage, mock, rdb := newStorage(t, false)
+		defer mockStorage.Shutdown()
+
+		mock.ExpectExec(tracked).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(insert).WillReturnError(errors.New("db down"))
+		mockStorage.AddCurrency("BTC")
+		assert.Eventually(t, func() bool {
+			return mock.ExpectationsWereMet() == nil
+		}, 2*time.Second, 5*time.Millisecond)
+		mockStorage.RemoveCurrency("BTC")
+
+		count, err := rdb.ZCard(ctx, "token:BTC").Result()
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("successful write is cached", func(t *testing.T) {
+		mockStorage, mock, rdb := newStorage(t, false)
+		defer mockStorage.Shutdown()
+
+		mock.ExpectExec(tracked).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(insert).WillReturnResult(sqlmock.NewResult(1, 1))
+		mockStorage.AddCurrency("BTC")
+		assert.Eventually(t, func() bool {
+			count, _ := rdb.ZCard(ctx, "token:BTC").Result()
+			return count > 0
+		}, 2*time.Second, 5*time.Millisecond)
+		mockStorage.RemoveCurrency("BTC")
+	})
+
+	t.Run("failed write invalidates the cache", func(t *testing.T) {
+		mockStorage, mock, rdb := newStorage(t, true)
+		defer mockStorage.Shutdown()
+
+		mockStorage.UpdateCache(context.Background(), "BTC", 49000, time.Now().Unix()-60)
+		mock.ExpectExec(tracked).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(insert).WillReturnError(errors.New("db down"))
+		mockStorage.AddCurrency("BTC")
+		assert.Eventually(t, func() bool {
+			exists, _ := rdb.Exists(ctx, "token:BTC").Result()
+			return exists == 0
+		}, 2*time.Second, 5*time.Millisecond)
+		mockStorage.RemoveCurrency("BTC")
+	})
+}
+
+// Test database lookups are recorded in the query latency histogram
+func TestDBQueryDuration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{DB: db, Redis: rdb}
+
+	observed := func() uint64 {
+		var m dto.Metric
+		h := metrics.DBQueryDuration.WithLabelValues(metrics.QueryNearest).(prometheus.Histogram)
+		require.NoError(t, h.Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	before := observed()
+
+	testTime := time.Now().Unix()
+	mock.ExpectQuery("SELECT price, timestamp FROM currencies").
+		WithArgs("BTC", testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, testTime))
+
+	_, source, err := mockStorage.GetPrice(context.Background(), "BTC", testTime)
+	require.NoError(t, err)
+	assert.Equal(t, storage.SourceDB, source)
+	assert.Equal(t, before+1, observed())
+}
+
+// Test a failing command in the middle of the cache pipeline is detected
+func TestUpdateCachePartialFailure(t *testing.T) {
+	failures := func(command string) float64 {
+		return testutil.ToFloat64(metrics.CacheWriteFailures.WithLabelValues(command))
+	}
+
+	t.Run("LRU bookkeeping fails", func(t *testing.T) {
+		mr, rdb := newTestRedis(t)
+		mockStorage := &storage.Storage{Redis: rdb}
+
+		// ZADD on the LRU key fails with WRONGTYPE, the price point still succeeds
+		require.NoError(t, mr.Set("token:lru", "not a sorted set"))
+		before := failures("zadd")
+
+		testTime := time.Now().Unix()
+		mockStorage.UpdateCache(context.Background(), "BTC", 50000, testTime)
+
+		assert.Equal(t, before+1, failures("zadd"))
+		price, err := mockStorage.GetFromCache(context.Background(), "token:BTC", testTime)
+		require.NoError(t, err)
+		assert.Equal(t, 50000.0, price)
+	})
+
+	t.Run("price point fails", func(t *testing.T) {
+		mr, rdb := newTestRedis(t)
+		mockStorage := &storage.Storage{Redis: rdb}
+
+		require.NoError(t, mr.Set("token:BTC", "not a sorted set"))
+		beforeAdd, beforeTrim := failures("zadd"), failures("zremrangebyscore")
+
+		mockStorage.UpdateCache(context.Background(), "BTC", 50000, time.Now().Unix())
+
+		assert.Equal(t, beforeAdd+1, failures("zadd"))
+		assert.Equal(t, beforeTrim+1, failures("zremrangebyscore"))
+	})
+}
+
+// Test the first price is collected right after adding a coin with collect_on_add
+func TestCollectOnAdd(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf: models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{
+				Interval:     time.Hour,
+				CollectOnAdd: true,
+			},
+		},
+		Source:      slowSource{},
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	mockStorage.AddCurrency("BTC")
+	assert.Eventually(t, func() bool {
+		count, _ := rdb.ZCard(context.Background(), "token:BTC").Result()
+		return count == 1
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+// panicSource is a PriceSource that panics on its first calls
+type panicSource struct {
+	panics atomic.Int32
+}
+
+func (s *panicSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	if s.panics.Add(-1) >= 0 {
+		panic("malformed response")
+	}
+	return 50000, nil
+}
+
+func (*panicSource) SupportedCoins() []string { return nil }
+
+// Test a panicking collector is restarted and resumes collecting
+func TestCollectorRestartsAfterPanic(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	source := &panicSource{}
+	source.panics.Store(2)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf: models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{
+				Interval:       10 * time.Millisecond,
+				RestartBackoff: 10 * time.Millisecond,
+			},
+		},
+		Source:      source,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+	before := testutil.ToFloat64(metrics.CollectorPanics.WithLabelValues("PANIC"))
+
+	mockStorage.AddCurrency("PANIC")
+	assert.Eventually(t, func() bool {
+		_, ok := mockStorage.LastUpdate("PANIC")
+		return ok
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.CollectorPanics.WithLabelValues("PANIC")))
+}
+
+// Test the applied migration version and dirty flag are read from schema_migrations
+func TestMigrationStatus(t *testing.T) {
+	query := "SELECT version, dirty FROM schema_migrations LIMIT 1"
+	newStorage := func(t *testing.T) (*storage.Storage, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return &storage.Storage{DB: db}, mock
+	}
+
+	t.Run("dirty version", func(t *testing.T) {
+		mockStorage, mock := newStorage(t)
+		mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(5, true))
+
+		version, dirty, err := mockStorage.MigrationStatus()
+		require.NoError(t, err)
+		assert.Equal(t, uint(5), version)
+		assert.True(t, dirty)
+	})
+
+	t.Run("no migrations", func(t *testing.T) {
+		mockStorage, mock := newStorage(t)
+		mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}))
+
+		version, dirty, err := mockStorage.MigrationStatus()
+		require.NoError(t, err)
+		assert.Zero(t, version)
+		assert.False(t, dirty)
+	})
+
+	t.Run("cache-only", func(t *testing.T) {
+		mockStorage := &storage.Storage{Config: models.Config{DBConf: models.DatabaseCfg{CacheOnly: true}}}
+
+		_, _, err := mockStorage.MigrationStatus()
+		assert.ErrorIs(t, err, storage.ErrCacheOnly)
+	})
+}
+
+// tradeSource is a TimestampedSource whose last trade happened at a fixed time
+type tradeSource struct {
+	at time.Time
+}
+
+func (s tradeSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	return 50000, nil
+}
+
+func (tradeSource) SupportedCoins() []string { return nil }
+
+func (s tradeSource) GetPriceAt(ctx context.Context, coin string) (float64, time.Time, error) {
+	return 50000, s.at, nil
+}
+
+// Test prices are stored at the exchange-reported trade time, once per trade
+func TestCollectExchangeTimestamp(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	tradedAt := time.Now().Add(-42 * time.Second)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: 5 * time.Millisecond},
+		},
+		Source:      tradeSource{at: tradedAt},
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	mockStorage.AddCurrency("BTC")
+	require.Eventually(t, func() bool {
+		_, ok := mockStorage.LastPriceUpdate("BTC")
+		return ok
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// Later collections see the same trade and only refresh LastUpdate
+	first, _ := mockStorage.LastUpdate("BTC")
+	require.Eventually(t, func() bool {
+		last, _ := mockStorage.LastUpdate("BTC")
+		return last.After(first)
+	}, 2*time.Second, 5*time.Millisecond)
+
+	points, err := rdb.ZRangeWithScores(context.Background(), "token:BTC", 0, -1).Result()
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	assert.Equal(t, float64(tradedAt.Unix()), points[0].Score)
+}
+
+// Test coins beyond collector.max_coins are refused
+func TestAddCurrencyMaxCoins(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: time.Hour, MaxCoins: 3},
+		},
+		Source:      slowSource{},
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	for _, coin := range []string{"BTC", "ETH", "SOL"} {
+		require.NoError(t, mockStorage.AddCurrency(coin))
+	}
+	err := mockStorage.AddCurrency("ADA")
+	assert.ErrorIs(t, err, storage.ErrTooManyCoins)
+	assert.False(t, mockStorage.Tracked("ADA"))
+
+	// Tracked coins can still be re-added, and removing one frees a slot
+	assert.NoError(t, mockStorage.AddCurrency("BTC"))
+	mockStorage.RemoveCurrency("ETH")
+	assert.NoError(t, mockStorage.AddCurrency("ADA"))
+}
+
+// Test adds are refused while a store collectors write to is down
+func TestAddCurrencyUnhealthy(t *testing.T) {
+	cfg := models.Config{CollConf: models.CollectorCfg{RejectUnhealthyAdds: true}}
+
+	t.Run("redis down", func(t *testing.T) {
+		mr, rdb := newTestRedis(t)
+		mr.Close()
+		mockStorage := &storage.Storage{
+			Config:      cfg,
+			Redis:       rdb,
+			ActiveCoins: make(map[string]struct{}),
+			Shutdwn:     make(chan struct{}),
+		}
+
+		err := mockStorage.AddCurrency("BTC")
+		assert.ErrorIs(t, err, storage.ErrUnhealthy)
+		assert.NotContains(t, mockStorage.ActiveCoins, "BTC")
+	})
+
+	t.Run("postgres down", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		require.NoError(t, err)
+		defer db.Close()
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+		_, rdb := newTestRedis(t)
+		mockStorage := &storage.Storage{
+			Config:      cfg,
+			DB:          db,
+			Redis:       rdb,
+			ActiveCoins: make(map[string]struct{}),
+			Shutdwn:     make(chan struct{}),
+		}
+
+		err = mockStorage.AddCurrency("BTC")
+		assert.ErrorIs(t, err, storage.ErrUnhealthy)
+		assert.NotContains(t, mockStorage.ActiveCoins, "BTC")
+	})
+
+	t.Run("check disabled", func(t *testing.T) {
+		mr, rdb := newTestRedis(t)
+		mr.Close()
+		mockStorage := &storage.Storage{
+			Redis:       rdb,
+			ActiveCoins: make(map[string]struct{}),
+			Shutdwn:     make(chan struct{}),
+		}
+
+		require.NoError(t, mockStorage.AddCurrency("BTC"))
+		assert.Contains(t, mockStorage.ActiveCoins, "BTC")
+		mockStorage.RemoveCurrency("BTC")
+	})
+}
+
+// blockingSource is a PriceSource whose fetches hang until they are cancelled.
+type blockingSource struct {
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func (s blockingSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	s.started <- struct{}{}
+	<-ctx.Done()
+	close(s.cancelled)
+	return 0, ctx.Err()
+}
+
+func (blockingSource) SupportedCoins() []string { return nil }
+
+// Test removing a coin cancels its collector's fetch in flight
+func TestRemoveCurrencyCancelsFetch(t *testing.T) {
+	src := blockingSource{started: make(chan struct{}, 1), cancelled: make(chan struct{})}
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: time.Hour, CollectOnAdd: true},
+		},
+		Source:      src,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	<-src.started
+	mockStorage.RemoveCurrency("BTC")
+
+	select {
+	case <-src.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("fetch in flight was not cancelled")
+	}
+}
+
+// Test removing coins with fetches in flight leaves no goroutines behind and
+// does not block Shutdown
+func TestRemoveCurrencyReleasesGoroutines(t *testing.T) {
+	src := blockingSource{started: make(chan struct{}, 1), cancelled: make(chan struct{})}
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: time.Hour, CollectOnAdd: true},
+		},
+		Source:      src,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	baseline := testutil.ToFloat64(metrics.CollectorGoroutines)
+
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	<-src.started
+	// The fetch in flight is the only goroutine of the coin
+	assert.Equal(t, baseline+1, testutil.ToFloat64(metrics.CollectorGoroutines))
+
+	mockStorage.RemoveCurrency("BTC")
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.CollectorGoroutines) == baseline
+	}, time.Second, 10*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		mockStorage.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+}
+
+// stuckSource is a PriceSource whose fetches ignore cancellation and hang
+// until release is closed.
+type stuckSource struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s stuckSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return 0, errors.New("released")
+}
+
+func (stuckSource) SupportedCoins() []string { return nil }
+
+// Test ShutdownContext gives up at the deadline and logs the collectors still running
+func TestShutdownContextDeadline(t *testing.T) {
+	src := stuckSource{started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(src.release)
+	_, rdb := newTestRedis(t)
+	var buf bytes.Buffer
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: time.Hour, CollectOnAdd: true},
+		},
+		Source:      src,
+		Redis:       rdb,
+		Logger:      slog.New(slog.NewTextHandler(&buf, nil)),
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	<-src.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := mockStorage.ShutdownContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, buf.String(), "shutdown deadline exceeded")
+	assert.Contains(t, buf.String(), "running_collectors=[BTC]")
+}
+
+// Test the collector fetches no more coins at once than collector.workers
+func TestCollectorWorkers(t *testing.T) {
+	src := stuckSource{started: make(chan struct{}, 2), release: make(chan struct{})}
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: time.Hour, CollectOnAdd: true, Workers: 1},
+		},
+		Source:      src,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	require.NoError(t, mockStorage.AddCurrency("ETH"))
+	<-src.started
+	select {
+	case <-src.started:
+		t.Fatal("second coin was fetched while the only worker was busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once the worker is free the other coin is fetched
+	close(src.release)
+	select {
+	case <-src.started:
+	case <-time.After(time.Second):
+		t.Fatal("second coin was not fetched")
+	}
+}
+
+// Test the health check reports the status of every dependency
+func TestHealthCheck(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mr, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{DB: db, Redis: rdb}
+
+	mock.ExpectPing()
+	assert.Equal(t, map[string]string{"postgres": "ok", "redis": "ok"}, mockStorage.HealthCheck(context.Background()))
+
+	mr.Close()
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	health := mockStorage.HealthCheck(context.Background())
+	assert.Equal(t, "connection refused", health["postgres"])
+	assert.NotEqual(t, "ok", health["redis"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Without PostgreSQL only Redis is checked
+	cacheOnly := &storage.Storage{Config: models.Config{DBConf: models.DatabaseCfg{CacheOnly: true}}, Redis: rdb}
+	assert.NotContains(t, cacheOnly.HealthCheck(context.Background()), "postgres")
+}
+
+// Test price queries are counted by the store that answered them
+func TestPriceLookupMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{DB: db, Redis: rdb}
+	cacheHits := testutil.ToFloat64(metrics.PriceLookups.WithLabelValues(storage.SourceCache))
+	dbReads := testutil.ToFloat64(metrics.PriceLookups.WithLabelValues(storage.SourceDB))
+
+	testTime := time.Now().Unix()
+	mock.ExpectQuery(`
+		SELECT price, timestamp 
+		FROM currencies 
+		WHERE coin = $1 
+		ORDER BY ABS(timestamp - $2) 
+		LIMIT 1`).
+		WithArgs("BTC", testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).AddRow(50000.0, testTime))
+
+	// The first query reads the database and caches the point for the second
+	for _, want := range []string{storage.SourceDB, storage.SourceCache} {
+		_, source, err := mockStorage.GetPrice(context.Background(), "BTC", testTime)
+		require.NoError(t, err)
+		assert.Equal(t, want, source)
+	}
+	assert.Equal(t, cacheHits+1, testutil.ToFloat64(metrics.PriceLookups.WithLabelValues(storage.SourceCache)))
+	assert.Equal(t, dbReads+1, testutil.ToFloat64(metrics.PriceLookups.WithLabelValues(storage.SourceDB)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test collectors count their requests to the price source and the failed ones
+func TestCollectorMetrics(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	newStorage := func(src storage.PriceSource) *storage.Storage {
+		return &storage.Storage{
+			Config: models.Config{
+				DBConf:   models.DatabaseCfg{CacheOnly: true},
+				CollConf: models.CollectorCfg{Interval: time.Hour, CollectOnAdd: true},
+			},
+			Source:      src,
+			Redis:       rdb,
+			ActiveCoins: make(map[string]struct{}),
+			Shutdwn:     make(chan struct{}),
+		}
+	}
+	requests := testutil.ToFloat64(metrics.SourceRequests)
+	failures := testutil.ToFloat64(metrics.SourceRequestFailures)
+	coins := testutil.ToFloat64(metrics.ActiveCoins)
+
+	ok := newStorage(fixedSource{price: 50000})
+	defer ok.Shutdown()
+	failing := newStorage(fixedSource{err: errors.New("kraken is down")})
+	defer failing.Shutdown()
+
+	require.NoError(t, ok.AddCurrency("BTC"))
+	require.NoError(t, failing.AddCurrency("ETH"))
+	assert.Equal(t, coins+2, testutil.ToFloat64(metrics.ActiveCoins))
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.SourceRequests) == requests+2 &&
+			testutil.ToFloat64(metrics.SourceRequestFailures) == failures+1
+	}, time.Second, 10*time.Millisecond)
+
+	ok.RemoveCurrency("BTC")
+	failing.RemoveCurrency("ETH")
+	assert.Equal(t, coins, testutil.ToFloat64(metrics.ActiveCoins))
+}
+
+// Test price lookups are logged at debug level only
+func TestGetPriceDebugLog(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	for _, tc := range []struct {
+		level  slog.Level
+		logged bool
+	}{
+		{slog.LevelDebug, true},
+		{slog.LevelInfo, false},
+	} {
+		var buf bytes.Buffer
+		mockStorage := &storage.Storage{
+			Config: models.Config{DBConf: models.DatabaseCfg{CacheOnly: true}},
+			Redis:  rdb,
+			Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: tc.level})),
+		}
+
+		now := time.Now().Unix()
+		mockStorage.UpdateCache(context.Background(), "BTC", 50000, now)
+		_, _, err := mockStorage.GetPrice(context.Background(), "BTC", now)
+		require.NoError(t, err)
+
+		assert.Equal(t, tc.logged, strings.Contains(buf.String(), `msg="cache hit" coin=BTC latency_ns=`), buf.String())
+	}
+}
+
+// Test Shutdown cancels a collector's request to a slow Kraken instead of
+// waiting for the response
+func TestShutdownCancelsKrakenRequest(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/0/public/AssetPairs" {
+			w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"wsname":"XBT/USD","status":"online"}}}`))
+			return
+		}
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(30 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	kraken_api.Configure(models.KrakenCfg{BaseURL: srv.URL, RequestTimeout: time.Minute})
+	defer kraken_api.Configure(models.KrakenCfg{BaseURL: kraken_api.DefaultBaseURL})
+	require.NoError(t, kraken_api.RefreshPairs(context.Background()))
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: time.Hour, CollectOnAdd: true},
+		},
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+
+	select {
+	case <-requested:
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector did not request the price")
+	}
+
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		mockStorage.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+		assert.Less(t, time.Since(start), time.Second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown waited for the Kraken response")
+	}
+}
+
+// Test adding coins concurrently with running Kraken collectors and pair
+// refreshes; run with -race to detect unsynchronized access to the pairs
+func TestAddCurrencyConcurrentWithKrakenCollectors(t *testing.T) {
+	coins := []string{"BTC", "ETH", "SOL", "ADA", "DOT", "XRP"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/0/public/AssetPairs" {
+			pairs := make([]string, 0, len(coins))
+			for _, coin := range coins {
+				pairs = append(pairs, fmt.Sprintf(`"%sUSD":{"wsname":"%s/USD","status":"online"}`, coin, coin))
+			}
+			fmt.Fprintf(w, `{"error":[],"result":{%s}}`, strings.Join(pairs, ","))
+			return
+		}
+		pair := r.URL.Query().Get("pair")
+		fmt.Fprintf(w, `{"error":[],"result":{"%s":[["100.5","1",%d]],"last":"1"}}`, pair, time.Now().UnixNano())
+	}))
+	defer srv.Close()
+
+	kraken_api.Configure(models.KrakenCfg{BaseURL: srv.URL})
+	defer kraken_api.Configure(models.KrakenCfg{BaseURL: kraken_api.DefaultBaseURL})
+	require.NoError(t, kraken_api.RefreshPairs(context.Background()))
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: 5 * time.Millisecond, CollectOnAdd: true},
+		},
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, kraken_api.RefreshPairs(context.Background()))
+		}()
+	}
+	for _, coin := range coins {
+		wg.Add(1)
+		go func(coin string) {
+			defer wg.Done()
+			if kraken_api.IsSupported(coin) {
+				assert.NoError(t, mockStorage.AddCurrency(coin))
+			}
+		}(coin)
+	}
+	wg.Wait()
+
+	for _, coin := range coins {
+		assert.True(t, mockStorage.Tracked(coin), coin)
+		assert.Eventually(t, func() bool {
+			_, ok := mockStorage.LastUpdate(coin)
+			return ok
+		}, 2*time.Second, 5*time.Millisecond, coin)
+	}
+}
+
+// Test prices within dedup_epsilon of the last saved one are not inserted
+func TestDedupPrices(t *testing.T) {
+	tests := []struct {
+		name   string
+		dedup  bool
+		prices []float64
+		saved  []float64
+	}{
+		{"enabled", true, []float64{100, 100, 100.5, 101}, []float64{100, 101}},
+		{"disabled", false, []float64{100, 100}, []float64{100, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer db.Close()
+			mock.MatchExpectationsInOrder(false)
+
+			_, rdb := newTestRedis(t)
+			source := &sequenceSource{prices: tt.prices}
+			mockStorage := &storage.Storage{
+				Config: models.Config{CollConf: models.CollectorCfg{
+					Interval:     5 * time.Millisecond,
+					DedupPrices:  tt.dedup,
+					DedupEpsilon: 0.5,
+				}},
+				Source:      source,
+				DB:          db,
+				Redis:       rdb,
+				ActiveCoins: make(map[string]struct{}),
+				Shutdwn:     make(chan struct{}),
+			}
+			defer mockStorage.Shutdown()
+
+			mock.ExpectExec("INSERT INTO tracked_coins (coin) VALUES ($1) ON CONFLICT DO NOTHING").
+				WithArgs("BTC").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			for _, price := range tt.saved {
+				mock.ExpectExec("INSERT INTO currencies (coin, price, timestamp, synthetic) VALUES ($1, $2, $3, $4)").
+					WithArgs("BTC", price, sqlmock.AnyArg(), false).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			require.NoError(t, mockStorage.AddCurrency("BTC"))
+			require.Eventually(t, func() bool {
+				update, ok := mockStorage.LastPriceUpdate("BTC")
+				return source.remaining() == 0 && ok && update.Price == tt.prices[len(tt.prices)-1]
+			}, 2*time.Second, 5*time.Millisecond)
+
+			// The cache is still updated
+			count, err := rdb.ZCard(context.Background(), "token:BTC").Result()
+			require.NoError(t, err)
+			assert.Positive(t, count)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
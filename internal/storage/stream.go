@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"test-task1/models"
+)
+
+// ErrNotTracked is returned by Subscribe for coins whose prices are not collected.
+var ErrNotTracked = errors.New("coin is not tracked")
+
+// subscriberBuffer is how many updates a subscriber may lag behind; further
+// updates are dropped for it until it catches up.
+const subscriberBuffer = 16
+
+// Subscribe returns a channel receiving every price collected for the coin
+// from now on, with its change like LastPriceUpdate. The channel is closed
+// when the coin is removed or the storage shuts down; call unsubscribe once
+// done with it. Updates a slow subscriber has no room for are dropped, so
+// collectors never wait for subscribers.
+func (s *Storage) Subscribe(coin string) (updates <-chan models.PriceUpdate, unsubscribe func(), err error) {
+	coin = s.resolveCoin(coin)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, tracked := s.ActiveCoins[coin]; !tracked {
+		return nil, nil, fmt.Errorf("storage.Subscribe: %w: %s", ErrNotTracked, coin)
+	}
+
+	ch := make(chan models.PriceUpdate, subscriberBuffer)
+	select {
+	case <-s.Shutdwn:
+		close(ch)
+		return ch, func() {}, nil
+	default:
+	}
+
+	if s.subscribers == nil {
+		s.subscribers = make(map[string]map[chan models.PriceUpdate]struct{})
+	}
+	if s.subscribers[coin] == nil {
+		s.subscribers[coin] = make(map[chan models.PriceUpdate]struct{})
+	}
+	s.subscribers[coin][ch] = struct{}{}
+
+	return ch, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if _, ok := s.subscribers[coin][ch]; ok {
+			delete(s.subscribers[coin], ch)
+			close(ch)
+		}
+	}, nil
+}
+
+// publish sends update to the subscribers of its coin. The caller must hold s.mutex.
+func (s *Storage) publish(update models.PriceUpdate) {
+	for ch := range s.subscribers[update.Coin] {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// closeSubscribers closes the channels of the coin's subscribers. The caller
+// must hold s.mutex.
+func (s *Storage) closeSubscribers(coin string) {
+	for ch := range s.subscribers[coin] {
+		close(ch)
+	}
+	delete(s.subscribers, coin)
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"runtime/debug"
+	"sort"
+	"test-task1/internal/metrics"
+	"time"
+)
+
+// defaultRestartBackoff is used when collector.restart_backoff is not set.
+const defaultRestartBackoff = time.Second
+
+// restartBackoff returns collector.restart_backoff, falling back to defaultRestartBackoff.
+func (s *Storage) restartBackoff() time.Duration {
+	if s.Config.CollConf.RestartBackoff <= 0 {
+		return defaultRestartBackoff
+	}
+	return s.Config.CollConf.RestartBackoff
+}
+
+// goCollector runs a collector of a coin in a goroutine that Shutdown waits
+// for, counted in the collector_goroutines metric. name identifies it in the
+// collectors ShutdownContext reports as still running.
+func (s *Storage) goCollector(name string, collector func()) {
+	s.wg.Add(1)
+	metrics.CollectorGoroutines.Inc()
+	s.setRunning(name, 1)
+	go func() {
+		defer s.wg.Done()
+		defer metrics.CollectorGoroutines.Dec()
+		defer s.setRunning(name, -1)
+		collector()
+	}()
+}
+
+// setRunning adds delta to the number of running collectors named name.
+func (s *Storage) setRunning(name string, delta int) {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	if s.running == nil {
+		s.running = make(map[string]int)
+	}
+	s.running[name] += delta
+	if s.running[name] <= 0 {
+		delete(s.running, name)
+	}
+}
+
+// runningCollectors returns the sorted names of the running collectors.
+func (s *Storage) runningCollectors() []string {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	names := make([]string, 0, len(s.running))
+	for name := range s.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rootContext returns the context the collectors' contexts derive from. It is
+// cancelled by ShutdownContext, so a fetch in flight is aborted on shutdown.
+func (s *Storage) rootContext() context.Context {
+	s.rootOnce.Do(func() {
+		s.root, s.cancelRoot = context.WithCancel(context.Background())
+	})
+	return s.root
+}
+
+// collectCoin collects a price of the coin, aborting the fetch once ctx is
+// cancelled: when the coin is removed or the storage shuts down. A panic, e.g. because of a bug in a
+// price source, is recovered and the coin is collected again after
+// collector.restart_backoff, so one bad response does not stop the coin's
+// collection for good.
+func (s *Storage) collectCoin(ctx context.Context, coin string) {
+	panicked := s.collectRecovered(ctx, coin)
+	s.finishCollecting(coin, panicked)
+}
+
+// collectRecovered runs collect and reports whether it panicked.
+func (s *Storage) collectRecovered(ctx context.Context, coin string) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger().Error("collector panicked", "coin", coin, "panic", r, "stack", string(debug.Stack()))
+			metrics.CollectorPanics.WithLabelValues(coin).Inc()
+			panicked = true
+		}
+	}()
+	s.collect(ctx, coin)
+	return false
+}
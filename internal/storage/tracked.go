@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"test-task1/internal/metrics"
+)
+
+// startTracking registers the coin with the collector loop, starting the loop
+// if needed, which warms the coin's cache right away. With collector.collect_on_add the
+// first price is fetched right away instead of at the next tick; a failed
+// first fetch is only logged. The caller must hold s.mutex and have checked
+// that the coin is not tracked yet.
+func (s *Storage) startTracking(coin string) {
+	s.ActiveCoins[coin] = struct{}{}
+	if s.collectors == nil {
+		s.collectors = make(map[string]*coinCollector)
+	}
+	ctx, cancel := context.WithCancel(s.rootContext())
+	// In flight until warmed up, so the loop does not collect it before
+	s.collectors[coin] = &coinCollector{inFlight: true, added: true, ctx: ctx, cancel: cancel}
+	metrics.ActiveCoins.Inc()
+	s.startCollector()
+	s.wakeCollector()
+}
+
+// stopTracking unregisters the coin from the collector loop and cancels its
+// collection in flight. The caller must hold s.mutex.
+// Returns whether the coin was tracked.
+func (s *Storage) stopTracking(coin string) bool {
+	if _, exists := s.ActiveCoins[coin]; !exists {
+		return false
+	}
+	if c := s.collectors[coin]; c != nil {
+		c.cancel()
+	}
+	delete(s.ActiveCoins, coin)
+	delete(s.collectors, coin)
+	metrics.ActiveCoins.Dec()
+	metrics.CollectorLag.DeleteLabelValues(coin)
+	return true
+}
+
+// saveTracked records in tracked_coins that the coin is tracked, so its
+// collector is restarted by ReloadTracked after a restart.
+func (s *Storage) saveTracked(coin string) error {
+	if s.cacheOnly() || s.DB == nil {
+		return nil
+	}
+	_, err := s.DB.Exec("INSERT INTO tracked_coins (coin) VALUES ($1) ON CONFLICT DO NOTHING", coin)
+	return err
+}
+
+// deleteTracked removes the coin from tracked_coins.
+func (s *Storage) deleteTracked(coin string) error {
+	if s.cacheOnly() || s.DB == nil {
+		return nil
+	}
+	_, err := s.DB.Exec("DELETE FROM tracked_coins WHERE coin = $1", coin)
+	return err
+}
+
+// ReloadTracked starts the collectors of the coins recorded in tracked_coins
+// that are not tracked yet, e.g. after a restart. New calls it on startup.
+// Coins beyond collector.max_coins are left out and logged.
+// Nothing is persisted in cache-only mode.
+// Returns the number of started collectors.
+func (s *Storage) ReloadTracked() (int, error) {
+	const op = "storage.ReloadTracked"
+	if s.cacheOnly() {
+		return 0, nil
+	}
+
+	rows, err := s.DB.Query("SELECT coin FROM tracked_coins ORDER BY coin")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	defer rows.Close()
+
+	var coins []string
+	for rows.Next() {
+		var coin string
+		if err := rows.Scan(&coin); err != nil {
+			return 0, fmt.Errorf("%s: %v", op, err)
+		}
+		coins = append(coins, coin)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	started := 0
+	for i, coin := range coins {
+		if _, exists := s.ActiveCoins[coin]; exists {
+			continue
+		}
+		if len(s.ActiveCoins) >= s.maxCoins() {
+			s.logger().Warn("collector.max_coins reached, not reloading tracked coins", "coins", coins[i:])
+			break
+		}
+		s.startTracking(coin)
+		started++
+	}
+	return started, nil
+}
@@ -0,0 +1,121 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// Test collectors are restarted for the coins persisted in tracked_coins
+func TestReloadTracked(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config:      models.Config{CollConf: models.CollectorCfg{Interval: time.Hour}},
+		Source:      fixedSource{price: 50000},
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	mock.ExpectQuery("SELECT coin FROM tracked_coins ORDER BY coin").
+		WillReturnRows(sqlmock.NewRows([]string{"coin"}).AddRow("BTC").AddRow("ETH"))
+
+	started, err := mockStorage.ReloadTracked()
+	require.NoError(t, err)
+	assert.Equal(t, 2, started)
+	assert.True(t, mockStorage.Tracked("BTC"))
+	assert.True(t, mockStorage.Tracked("ETH"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Coins already tracked are not started twice
+	mock.ExpectQuery("SELECT coin FROM tracked_coins ORDER BY coin").
+		WillReturnRows(sqlmock.NewRows([]string{"coin"}).AddRow("BTC"))
+
+	started, err = mockStorage.ReloadTracked()
+	require.NoError(t, err)
+	assert.Zero(t, started)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test adding and removing a coin persists it in tracked_coins
+func TestTrackedPersisted(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config:      models.Config{CollConf: models.CollectorCfg{Interval: time.Hour}},
+		Source:      fixedSource{price: 50000},
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	mock.ExpectExec("INSERT INTO tracked_coins (coin) VALUES ($1) ON CONFLICT DO NOTHING").
+		WithArgs("BTC").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+
+	mock.ExpectExec("DELETE FROM tracked_coins WHERE coin = $1").
+		WithArgs("BTC").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockStorage.RemoveCurrency("BTC")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test a failed tracked_coins write leaves the coin as it was and is returned
+func TestTrackedPersistFailure(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config:      models.Config{CollConf: models.CollectorCfg{Interval: time.Hour}},
+		Source:      fixedSource{price: 50000},
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	// A failed insert unregisters the coin again
+	mock.ExpectExec("INSERT INTO tracked_coins (coin) VALUES ($1) ON CONFLICT DO NOTHING").
+		WithArgs("BTC").
+		WillReturnError(errors.New("connection refused"))
+	assert.Error(t, mockStorage.AddCurrency("BTC"))
+	assert.False(t, mockStorage.Tracked("BTC"))
+
+	mock.ExpectExec("INSERT INTO tracked_coins (coin) VALUES ($1) ON CONFLICT DO NOTHING").
+		WithArgs("BTC").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+
+	// A failed delete keeps the coin tracked
+	mock.ExpectExec("DELETE FROM tracked_coins WHERE coin = $1").
+		WithArgs("BTC").
+		WillReturnError(errors.New("connection refused"))
+	removed, err := mockStorage.RemoveCurrency("BTC")
+	assert.Error(t, err)
+	assert.False(t, removed)
+	assert.True(t, mockStorage.Tracked("BTC"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,47 @@
+package storage
+
+import "test-task1/models"
+
+// LastPriceUpdate returns the latest price collected for the coin by this
+// process with its change from the price collected before it. ok is false if
+// the coin is not tracked or nothing was collected yet.
+func (s *Storage) LastPriceUpdate(coin string) (update models.PriceUpdate, ok bool) {
+	coin = s.resolveCoin(coin)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	update, ok = s.lastPrices[coin]
+	return update, ok
+}
+
+// collectedAt reports whether the latest collected price of the coin is from timestamp.
+func (s *Storage) collectedAt(coin string, timestamp int64) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	update, ok := s.lastPrices[coin]
+	return ok && update.Timestamp == timestamp
+}
+
+// recordPrice makes price the latest collected price of the coin and
+// publishes it to the coin's subscribers.
+func (s *Storage) recordPrice(coin string, price float64, timestamp int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, tracked := s.ActiveCoins[coin]; !tracked {
+		return // removed while the fetch was in flight
+	}
+	if s.lastPrices == nil {
+		s.lastPrices = make(map[string]models.PriceUpdate)
+	}
+
+	update := models.PriceUpdate{Coin: coin, Price: price, Timestamp: timestamp}
+	if prev, ok := s.lastPrices[coin]; ok {
+		change := price - prev.Price
+		update.Change = &change
+		if prev.Price != 0 {
+			percent := change / prev.Price * 100
+			update.ChangePercent = &percent
+		}
+	}
+	s.lastPrices[coin] = update
+	s.publish(update)
+}
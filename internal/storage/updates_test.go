@@ -0,0 +1,141 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// sequenceSource returns the given prices in order, then fails
+type sequenceSource struct {
+	mu     sync.Mutex
+	prices []float64
+}
+
+func (s *sequenceSource) GetPrice(ctx context.Context, coin string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.prices) == 0 {
+		return 0, errors.New("no more prices")
+	}
+	price := s.prices[0]
+	s.prices = s.prices[1:]
+	return price, nil
+}
+
+func (*sequenceSource) SupportedCoins() []string { return nil }
+
+func (s *sequenceSource) remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.prices)
+}
+
+// Test the change from the previous collected price is computed across updates
+func TestLastPriceUpdate(t *testing.T) {
+	tests := []struct {
+		name          string
+		prices        []float64
+		change        *float64
+		changePercent *float64
+	}{
+		{"first price", []float64{200}, nil, nil},
+		{"rise", []float64{200, 250}, ptr(50.0), ptr(25.0)},
+		{"fall", []float64{200, 250, 225}, ptr(-25.0), ptr(-10.0)},
+		{"from zero", []float64{0, 10}, ptr(10.0), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rdb := newTestRedis(t)
+			source := &sequenceSource{prices: tt.prices}
+			mockStorage := &storage.Storage{
+				Config: models.Config{
+					DBConf:   models.DatabaseCfg{CacheOnly: true},
+					CollConf: models.CollectorCfg{Interval: 5 * time.Millisecond},
+				},
+				Source:      source,
+				Redis:       rdb,
+				ActiveCoins: make(map[string]struct{}),
+				Shutdwn:     make(chan struct{}),
+			}
+			defer mockStorage.Shutdown()
+
+			mockStorage.AddCurrency("BTC")
+			require.Eventually(t, func() bool {
+				update, ok := mockStorage.LastPriceUpdate("BTC")
+				return source.remaining() == 0 && ok && update.Price == tt.prices[len(tt.prices)-1]
+			}, 2*time.Second, 5*time.Millisecond)
+
+			update, _ := mockStorage.LastPriceUpdate("BTC")
+			assert.Equal(t, "BTC", update.Coin)
+			assert.Equal(t, tt.change, update.Change)
+			assert.Equal(t, tt.changePercent, update.ChangePercent)
+
+			mockStorage.RemoveCurrency("BTC")
+			_, ok := mockStorage.LastPriceUpdate("BTC")
+			assert.False(t, ok)
+		})
+	}
+}
+
+// Test subscribers receive every collected price until the coin is removed
+func TestSubscribe(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			DBConf:   models.DatabaseCfg{CacheOnly: true},
+			CollConf: models.CollectorCfg{Interval: 20 * time.Millisecond},
+		},
+		Source:      &sequenceSource{prices: []float64{100, 101}},
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	_, _, err := mockStorage.Subscribe("BTC")
+	require.ErrorIs(t, err, storage.ErrNotTracked)
+
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+	updates, unsubscribe, err := mockStorage.Subscribe("BTC")
+	require.NoError(t, err)
+	defer unsubscribe()
+	other, unsubscribeOther, err := mockStorage.Subscribe("BTC")
+	require.NoError(t, err)
+
+	receive := func() models.PriceUpdate {
+		select {
+		case update := <-updates:
+			return update
+		case <-time.After(2 * time.Second):
+			t.Fatal("no price update")
+			return models.PriceUpdate{}
+		}
+	}
+	first := receive()
+	assert.Equal(t, 100.0, first.Price)
+	assert.Nil(t, first.Change)
+	second := receive()
+	assert.Equal(t, 101.0, second.Price)
+	assert.Equal(t, ptr(1.0), second.Change)
+
+	// An unsubscribed channel is closed and no longer published to
+	unsubscribeOther()
+	for range other {
+	}
+
+	mockStorage.RemoveCurrency("BTC")
+	_, ok := <-updates
+	assert.False(t, ok, "channel is closed once the coin is removed")
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// WarmCache loads the last collector.warmup_points prices of the coin from
+// the database into its cache, so queries right after a re-add don't all
+// miss. Points older than redis.data_retention are skipped; without the
+// Redis cache nothing is loaded.
+// Returns the number of cached points.
+func (s *Storage) WarmCache(ctx context.Context, coin string) (int, error) {
+	const op = "storage.WarmCache"
+	if s.cacheDisabled() {
+		return 0, nil
+	}
+
+	cutoff := s.Config.CollConf.Now() - s.Config.CollConf.Units(s.dataRetention())
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT price, timestamp
+		FROM currencies
+		WHERE coin = $1 AND timestamp > $2
+		ORDER BY timestamp DESC
+		LIMIT $3`,
+		coin, cutoff, s.Config.CollConf.WarmupPoints,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	defer rows.Close()
+
+	var members []*redis.Z
+	for rows.Next() {
+		var (
+			price     float64
+			timestamp int64
+		)
+		if err := rows.Scan(&price, &timestamp); err != nil {
+			return 0, fmt.Errorf("%s: %v", op, err)
+		}
+		members = append(members, &redis.Z{
+			Score:  float64(timestamp),
+			Member: formatMember(timestamp, price),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	key := fmt.Sprintf("token:%s", coin)
+	pipe := s.Redis.Pipeline()
+	pipe.ZAdd(ctx, key, members...)
+	pipe.Expire(ctx, key, s.cacheTTL())
+	pipe.ZAdd(ctx, lruKey, &redis.Z{
+		Score:  float64(time.Now().Unix()),
+		Member: coin,
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+	return len(members), nil
+}
+
+// warmCache runs WarmCache for a newly added coin if warmup is enabled.
+// Removing the coin meanwhile cancels it through ctx.
+func (s *Storage) warmCache(ctx context.Context, coin string) {
+	if s.Config.CollConf.WarmupPoints <= 0 || s.cacheOnly() {
+		return
+	}
+	if _, err := s.WarmCache(ctx, coin); err != nil {
+		s.logger().Error("failed to warm cache", "coin", coin, "err", err)
+	}
+}
+
+// defaultWarmHotCoins is used when query.warm_hot_coins is not set.
+const defaultWarmHotCoins = 10
+
+// WarmHotCoins loads the latest stored prices of the query.warm_hot_coins
+// most queried coins (see HotCoins) into their caches. Coins that still have
+// cached prices are skipped, so a run never rewrites a live cache and loads
+// at most warm_hot_coins times collector.warmup_points points.
+// Returns the coins whose cache was warmed.
+func (s *Storage) WarmHotCoins(ctx context.Context) ([]string, error) {
+	const op = "storage.WarmHotCoins"
+	if s.cacheOnly() {
+		return nil, fmt.Errorf("%s: %w", op, ErrCacheOnly)
+	}
+	if s.cacheDisabled() {
+		return nil, fmt.Errorf("%s: %w", op, ErrCacheDisabled)
+	}
+	limit := s.Config.QueryConf.WarmHotCoins
+	if limit <= 0 {
+		limit = defaultWarmHotCoins
+	}
+
+	hot, err := s.HotCoins(limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+
+	var warmed []string
+	for _, c := range hot {
+		cached, err := s.Redis.Exists(ctx, fmt.Sprintf("token:%s", c.Coin)).Result()
+		if err != nil {
+			return warmed, fmt.Errorf("%s: %v", op, err)
+		}
+		if cached > 0 {
+			continue
+		}
+		n, err := s.WarmCache(ctx, c.Coin)
+		if err != nil {
+			return warmed, fmt.Errorf("%s: %v", op, err)
+		}
+		if n > 0 {
+			warmed = append(warmed, c.Coin)
+		}
+	}
+	return warmed, nil
+}
+
+// startHotWarming runs WarmHotCoins every query.warm_hot_interval until shutdown.
+func (s *Storage) startHotWarming() {
+	ticker := time.NewTicker(s.Config.QueryConf.WarmHotInterval)
+	defer ticker.Stop()
+
+	ctx := s.rootContext()
+
+	for {
+		select {
+		case <-ticker.C:
+			warmed, err := s.WarmHotCoins(ctx)
+			if err != nil {
+				s.logger().Error("warming hot coins failed", "err", err)
+			}
+			if len(warmed) > 0 {
+				s.logger().Info("warmed the cache of hot coins", "coins", warmed)
+			}
+		case <-s.Shutdwn:
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/internal/storage"
+	"test-task1/models"
+)
+
+// Test re-adding a coin loads its latest stored prices into the cache
+func TestWarmCacheOnAdd(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{CollConf: models.CollectorCfg{
+			Interval:     time.Hour,
+			WarmupPoints: 2,
+		}},
+		Source:      fixedSource{price: 50000},
+		DB:          db,
+		Redis:       rdb,
+		ActiveCoins: make(map[string]struct{}),
+		Shutdwn:     make(chan struct{}),
+	}
+	defer mockStorage.Shutdown()
+
+	now := time.Now().Unix()
+	mock.ExpectQuery("SELECT price, timestamp FROM currencies").
+		WithArgs("BTC", sqlmock.AnyArg(), 2).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).
+			AddRow(50010.0, now-5).
+			AddRow(50000.0, now-10))
+	mock.ExpectExec("INSERT INTO tracked_coins").WithArgs("BTC").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, mockStorage.AddCurrency("BTC"))
+
+	ctx := context.Background()
+	assert.Eventually(t, func() bool {
+		count, _ := rdb.ZCard(ctx, "token:BTC").Result()
+		return count == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Queries are answered from the warmed cache
+	price, err := mockStorage.GetFromCache(ctx, "token:BTC", now-5)
+	require.NoError(t, err)
+	assert.Contains(t, []float64{50000, 50010}, price)
+
+	mockStorage.RemoveCurrency("BTC")
+}
+
+// Test the warmer fills the empty caches of the most queried coins only
+func TestWarmHotCoins(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rdb := newTestRedis(t)
+	mockStorage := &storage.Storage{
+		Config: models.Config{
+			CollConf:  models.CollectorCfg{WarmupPoints: 2},
+			QueryConf: models.QueryCfg{WarmHotCoins: 2},
+		},
+		DB:    db,
+		Redis: rdb,
+	}
+
+	ctx := context.Background()
+	now := time.Now().Unix()
+	mockStorage.UpdateCache(ctx, "ETH", 3000, now)
+
+	// BTC is queried most but has nothing cached, ETH is cached and SOL is
+	// queried least
+	queries := map[string]int{"BTC": 3, "ETH": 2, "SOL": 1}
+	for coin, n := range queries {
+		for i := 0; i < n; i++ {
+			if coin != "ETH" {
+				mock.ExpectQuery("SELECT price, timestamp FROM currencies").
+					WithArgs(coin, now).
+					WillReturnError(sql.ErrNoRows)
+			}
+			mockStorage.GetPrice(ctx, coin, now)
+		}
+	}
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectQuery("SELECT price, timestamp FROM currencies").
+		WithArgs("BTC", sqlmock.AnyArg(), 2).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "timestamp"}).
+			AddRow(50010.0, now-5).
+			AddRow(50000.0, now-10))
+
+	warmed, err := mockStorage.WarmHotCoins(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"BTC"}, warmed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	count, err := rdb.ZCard(ctx, "token:BTC").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	count, err = rdb.ZCard(ctx, "token:SOL").Result()
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
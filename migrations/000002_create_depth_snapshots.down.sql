@@ -0,0 +1 @@
+DROP TABLE IF EXISTS depth_snapshots;
\ No newline at end of file
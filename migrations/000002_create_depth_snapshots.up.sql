@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS depth_snapshots (
+    id SERIAL PRIMARY KEY,
+    coin VARCHAR(10) NOT NULL,
+    timestamp BIGINT NOT NULL,
+    bids JSONB NOT NULL,
+    asks JSONB NOT NULL
+);
+
+CREATE INDEX idx_depth_snapshots_coin_timestamp ON depth_snapshots (coin, timestamp);
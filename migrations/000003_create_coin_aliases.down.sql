@@ -0,0 +1 @@
+DROP TABLE IF EXISTS coin_aliases;
\ No newline at end of file
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS coin_aliases (
+    alias VARCHAR(10) PRIMARY KEY,
+    coin VARCHAR(10) NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE currencies ADD COLUMN IF NOT EXISTS synthetic BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS exchange_prices (
+    id SERIAL PRIMARY KEY,
+    coin VARCHAR(10) NOT NULL,
+    source VARCHAR(32) NOT NULL,
+    price DOUBLE PRECISION NOT NULL,
+    timestamp BIGINT NOT NULL
+);
+
+CREATE INDEX idx_exchange_prices_coin_source_timestamp ON exchange_prices (coin, source, timestamp);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS coin_hotness (
+    coin VARCHAR(10) PRIMARY KEY,
+    accesses BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_coin_hotness_accesses ON coin_hotness (accesses DESC);
@@ -0,0 +1,6 @@
+ALTER TABLE coin_hotness ALTER COLUMN coin TYPE VARCHAR(10);
+ALTER TABLE exchange_prices ALTER COLUMN coin TYPE VARCHAR(10);
+ALTER TABLE coin_aliases ALTER COLUMN coin TYPE VARCHAR(10);
+ALTER TABLE coin_aliases ALTER COLUMN alias TYPE VARCHAR(10);
+ALTER TABLE depth_snapshots ALTER COLUMN coin TYPE VARCHAR(10);
+ALTER TABLE currencies ALTER COLUMN coin TYPE VARCHAR(10);
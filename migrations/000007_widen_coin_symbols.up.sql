@@ -0,0 +1,7 @@
+-- Pairs outside the configured quote currency are stored as "COIN/QUOTE"
+ALTER TABLE currencies ALTER COLUMN coin TYPE VARCHAR(32);
+ALTER TABLE depth_snapshots ALTER COLUMN coin TYPE VARCHAR(32);
+ALTER TABLE coin_aliases ALTER COLUMN alias TYPE VARCHAR(32);
+ALTER TABLE coin_aliases ALTER COLUMN coin TYPE VARCHAR(32);
+ALTER TABLE exchange_prices ALTER COLUMN coin TYPE VARCHAR(32);
+ALTER TABLE coin_hotness ALTER COLUMN coin TYPE VARCHAR(32);
@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS tracked_coins (
+    coin VARCHAR(32) PRIMARY KEY
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS downsample_watermark;
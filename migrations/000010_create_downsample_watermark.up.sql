@@ -0,0 +1,5 @@
+-- Rows of currencies below downsampled_until are already downsampled
+CREATE TABLE IF NOT EXISTS downsample_watermark (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    downsampled_until BIGINT NOT NULL
+);
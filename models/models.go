@@ -1,35 +1,498 @@
 package models
 
 import (
+	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
 	"log"
+	"log/slog"
 	"time"
 )
 
 // Config with yaml-tags
 type Config struct {
-	ServConf ServerCfg   `yaml:"server"`
-	DBConf   DatabaseCfg `yaml:"database"`
-	RDBConf  Redis       `yaml:"redis"`
+	ServConf   ServerCfg    `yaml:"server"`
+	DBConf     DatabaseCfg  `yaml:"database"`
+	RDBConf    Redis        `yaml:"redis"`
+	CollConf   CollectorCfg `yaml:"collector"`
+	KrakenConf KrakenCfg    `yaml:"kraken"`
+	QueryConf  QueryCfg     `yaml:"query"`
+	MetricConf MetricsCfg   `yaml:"metrics"`
+	LogConf    LogCfg       `yaml:"log"`
+
+	CoinbaseConf CoinbaseCfg `yaml:"coinbase"`
+}
+
+// LogCfg configures logging. Level is the least severe level logged: debug,
+// info, warn or error; debug adds every collected price and the latency of
+// each price lookup.
+type LogCfg struct {
+	Level string `yaml:"level" env:"LOG_LEVEL" env-default:"info"`
+}
+
+// SlogLevel parses Level.
+func (c LogCfg) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return 0, fmt.Errorf("log.level must be debug, info, warn or error, got %q", c.Level)
+	}
+	return level, nil
+}
+
+// MetricsCfg selects where metrics are exported: the Prometheus /metrics
+// endpoint, StatsD over UDP (when StatsDAddress is set), or both.
+type MetricsCfg struct {
+	Prometheus     bool          `yaml:"prometheus" env:"METRICS_PROMETHEUS" env-default:"true"`
+	StatsDAddress  string        `yaml:"statsd_address" env:"STATSD_ADDRESS"`
+	StatsDPrefix   string        `yaml:"statsd_prefix" env:"STATSD_PREFIX" env-default:"crypto."`
+	StatsDInterval time.Duration `yaml:"statsd_interval" env:"STATSD_INTERVAL" env-default:"10s"`
 }
 
 type Redis struct {
 	RedisAddress  string `yaml:"redis_address"`
 	RedisPassword string `yaml:"redis_password"`
 	RedisDB       int    `yaml:"redis_db"`
+
+	// CacheTTL expires the cached prices of a coin that is not written to
+	// anymore; DataRetention is how far back each coin's cached prices reach.
+	CacheTTL      time.Duration `yaml:"cache_ttl" env:"REDIS_CACHE_TTL" env-default:"10m"`
+	DataRetention time.Duration `yaml:"data_retention" env:"REDIS_DATA_RETENTION" env-default:"4h"`
+
+	// PoolSize and MinIdleConns size the connection pool; 0 keeps the
+	// go-redis defaults. With MasterName set, the master is looked up through
+	// the Sentinels at SentinelAddresses and RedisAddress is ignored.
+	PoolSize          int      `yaml:"pool_size" env:"REDIS_POOL_SIZE" env-default:"0"`
+	MinIdleConns      int      `yaml:"min_idle_conns" env:"REDIS_MIN_IDLE_CONNS" env-default:"0"`
+	MasterName        string   `yaml:"master_name" env:"REDIS_MASTER_NAME"`
+	SentinelAddresses []string `yaml:"sentinel_addresses" env:"REDIS_SENTINEL_ADDRESSES" env-separator:","`
+	SentinelPassword  string   `yaml:"sentinel_password" env:"REDIS_SENTINEL_PASSWORD"`
+
+	// CacheRequired fails startup when Redis is unreachable instead of
+	// running from PostgreSQL only.
+	CacheRequired bool `yaml:"cache_required" env:"REDIS_CACHE_REQUIRED" env-default:"false"`
 }
 
+// JSON field casings of API responses.
+const (
+	JSONCaseSnake = "snake"
+	JSONCaseCamel = "camel"
+)
+
+// Response compression algorithms, named as in Content-Encoding.
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// ServerCfg configures the HTTP server listening on Host, an address such as
+// ":8080". Timeout bounds reading a request and writing its response; 0
+// disables it. ShutdownTimeout bounds the graceful shutdown of the server
+// and the collectors. JSONCase selects snake_case (default)
+// or camelCase field names in responses. RequestTimeout bounds the handling
+// of every request; 0 disables it. APIKeys are accepted in the X-API-Key
+// header of protected endpoints (/currency, /admin and /account); without keys
+// /currency and /admin are open. MaxStreamConnections bounds the concurrent
+// WebSocket connections; 0 disables the limit.
 type ServerCfg struct {
-	Timeout time.Duration `yaml:"timeout" env:"TIMEOUT" env-default:"10s"`
-	Host    string        `yaml:"hostGateway" env:"HostGateway" env-default:":8081"`
+	Timeout        time.Duration `yaml:"timeout" env:"TIMEOUT" env-default:"10s"`
+	Host           string        `yaml:"host" env:"SERVER_HOST" env-default:":8080"`
+	JSONCase       string        `yaml:"json_case" env:"JSON_CASE" env-default:"snake"`
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"REQUEST_TIMEOUT" env-default:"5s"`
+	APIKeys        []string      `yaml:"api_keys" env:"API_KEYS" env-separator:","`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" env-default:"10s"`
+
+	Compression        []string `yaml:"compression" env:"COMPRESSION" env-separator:"," env-default:"br,gzip"`
+	CompressionMinSize int      `yaml:"compression_min_size" env:"COMPRESSION_MIN_SIZE" env-default:"1024"`
+
+	MaxStreamConnections int `yaml:"max_stream_connections" env:"MAX_STREAM_CONNECTIONS" env-default:"1000"`
 }
 
+// DatabaseCfg configures PostgreSQL. Prices older than DownsampleAfter are
+// replaced by one average per DownsampleBucket; 0 disables downsampling.
+// CacheOnly runs without PostgreSQL: prices are only kept in Redis.
+// HotnessFlush mirrors per-coin query counts to PostgreSQL at this interval
+// so that /currency/hot survives restarts; 0 keeps them in memory only.
+// PartitionByMonth keeps prices in one partition per month, converting the
+// table on the first start with it; partitions whose month ended more than
+// PartitionRetention ago are dropped (0 keeps them all).
+// SSLMode is the libpq sslmode of the connection; verify-ca and verify-full
+// check the server certificate against SSLRootCert, e.g. the CA bundle of a
+// managed database.
+// MaxOpenConns bounds the connections to PostgreSQL (0 = unlimited), of which
+// up to MaxIdleConns are kept open between queries; ConnMaxLifetime closes
+// connections after that long (0 = never), e.g. to follow failovers.
 type DatabaseCfg struct {
-	Port     string `yaml:"port" env:"DB_PORT" env-default:"5432"`
-	User     string `yaml:"user" env:"DB_USER" env-default:"postgres"`
-	Password string `yaml:"password" env:"DB_PASSWORD" env-default:"1234"`
-	DBName   string `yaml:"dbname" env:"DB_NAME" env-default:"postgres"`
-	Host     string `yaml:"host" env:"DB_HOST" env-default:"localhost"`
+	Port             string        `yaml:"port" env:"DB_PORT" env-default:"5432"`
+	User             string        `yaml:"user" env:"DB_USER" env-default:"postgres"`
+	Password         string        `yaml:"password" env:"DB_PASSWORD" env-default:"1234"`
+	DBName           string        `yaml:"dbname" env:"DB_NAME" env-default:"postgres"`
+	Host             string        `yaml:"host" env:"DB_HOST" env-default:"localhost"`
+	DownsampleAfter  time.Duration `yaml:"downsample_after" env:"DB_DOWNSAMPLE_AFTER" env-default:"0"`
+	DownsampleBucket time.Duration `yaml:"downsample_bucket" env:"DB_DOWNSAMPLE_BUCKET" env-default:"1m"`
+	CacheOnly        bool          `yaml:"cache_only" env:"DB_CACHE_ONLY" env-default:"false"`
+	HotnessFlush     time.Duration `yaml:"hotness_flush" env:"DB_HOTNESS_FLUSH" env-default:"0"`
+
+	Retention     time.Duration `yaml:"retention" env:"DB_RETENTION" env-default:"0"`
+	PruneInterval time.Duration `yaml:"prune_interval" env:"DB_PRUNE_INTERVAL" env-default:"1h"`
+
+	PartitionByMonth   bool          `yaml:"partition_by_month" env:"DB_PARTITION_BY_MONTH" env-default:"false"`
+	PartitionRetention time.Duration `yaml:"partition_retention" env:"DB_PARTITION_RETENTION" env-default:"0"`
+
+	SSLMode     string `yaml:"sslmode" env:"DB_SSLMODE" env-default:"disable"`
+	SSLRootCert string `yaml:"sslrootcert" env:"DB_SSLROOTCERT"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" env-default:"25"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" env-default:"10"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" env-default:"30m"`
+}
+
+// SSL modes of the PostgreSQL connection, see DatabaseCfg.
+const (
+	SSLModeDisable    = "disable"
+	SSLModeAllow      = "allow"
+	SSLModePrefer     = "prefer"
+	SSLModeRequire    = "require"
+	SSLModeVerifyCA   = "verify-ca"
+	SSLModeVerifyFull = "verify-full"
+)
+
+// ConnString returns the lib/pq connection string of the database.
+func (c DatabaseCfg) ConnString() string {
+	conn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode)
+	if c.SSLRootCert != "" {
+		conn += " sslrootcert=" + c.SSLRootCert
+	}
+	return conn
+}
+
+// KrakenCfg configures the Kraken integration. Quote is the currency prices
+// are denominated in; only pairs quoted in it are tracked.
+// MaxIdleConnsPerHost and IdleConnTimeout tune the keep-alive pool shared
+// by all requests to the Kraken API; RequestTimeout bounds each of them.
+// BaseURL points the client at another API host, e.g. a mock exchange in QA;
+// prices collected with Synthetic set are marked as such in the database.
+// APIKey and APISecret are the credentials of the account whose balances are
+// exposed on /account/balance; keep them in the environment, not the file.
+// DeadLetter logs the raw body of Kraken responses that cannot be parsed.
+// RetryAttempts and RetryBaseDelay retry requests failing with a transport
+// error or a 5xx response, doubling the delay after every attempt.
+// PairsRefresh is how often the list of tradable pairs is reloaded; 0 loads
+// it only on startup.
+// RateLimit bounds the public API requests of all collectors together to
+// that many per second, with bursts of RateBurst; 0 disables the limit.
+type KrakenCfg struct {
+	Quote               string        `yaml:"quote" env:"KRAKEN_QUOTE" env-default:"USD"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host" env:"KRAKEN_MAX_IDLE_CONNS" env-default:"10"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout" env:"KRAKEN_IDLE_CONN_TIMEOUT" env-default:"90s"`
+	RequestTimeout      time.Duration `yaml:"request_timeout" env:"KRAKEN_REQUEST_TIMEOUT" env-default:"10s"`
+	BaseURL             string        `yaml:"base_url" env:"KRAKEN_BASE_URL" env-default:"https://api.kraken.com"`
+	Synthetic           bool          `yaml:"synthetic" env:"KRAKEN_SYNTHETIC" env-default:"false"`
+	APIKey              string        `yaml:"api_key" env:"KRAKEN_API_KEY"`
+	APISecret           string        `yaml:"api_secret" env:"KRAKEN_API_SECRET"`
+	DeadLetter          bool          `yaml:"dead_letter" env:"KRAKEN_DEAD_LETTER" env-default:"false"`
+	RetryAttempts       int           `yaml:"retry_attempts" env:"KRAKEN_RETRY_ATTEMPTS" env-default:"3"`
+	RetryBaseDelay      time.Duration `yaml:"retry_base_delay" env:"KRAKEN_RETRY_BASE_DELAY" env-default:"200ms"`
+	PairsRefresh        time.Duration `yaml:"pairs_refresh" env:"KRAKEN_PAIRS_REFRESH" env-default:"1h"`
+	RateLimit           float64       `yaml:"rate_limit" env:"KRAKEN_RATE_LIMIT" env-default:"10"`
+	RateBurst           int           `yaml:"rate_burst" env:"KRAKEN_RATE_BURST" env-default:"10"`
+}
+
+// CoinbaseCfg configures the Coinbase price source. Compare collects Coinbase
+// prices for /currency/compare next to the primary source, like listing it
+// in collector.compare_sources.
+type CoinbaseCfg struct {
+	BaseURL string `yaml:"base_url" env:"COINBASE_BASE_URL" env-default:"https://api.coinbase.com"`
+	Compare bool   `yaml:"compare" env:"COINBASE_COMPARE" env-default:"false"`
+}
+
+// QueryCfg holds defaults and limits of the query endpoints.
+// DecayHalfLife is the default half-life of /currency/twap-decay and
+// MaxDecayWindow bounds the window it may average over.
+// VerifyCacheHits checks every cache hit against the database to detect
+// prices that were cached but never persisted.
+// MaxStaleness rejects price queries without a timestamp once the coin's
+// last collected price is older than the bound; 0 disables the check.
+// MaxCacheAge makes queries near the current time skip cached points older
+// than the bound and read the database instead; 0 disables the check.
+// MaxPriceGap rejects price queries whose nearest stored point is further
+// from the requested time than the bound; 0 disables the check.
+// MemoryCacheSize bounds the in-process cache of historical price results
+// that can no longer change; 0 disables it.
+// MaxRangePoints caps the number of points /currency/range returns.
+// RejectBeforeFirst answers price queries for timestamps before the coin's
+// first stored price with 404 instead of the nearest later price.
+// WarmHotInterval runs a job loading the latest collector.warmup_points
+// prices of the WarmHotCoins most queried coins into their empty caches;
+// 0 disables it.
+// PriceDecimals overrides per coin how many decimals prices are rounded to
+// in responses; other coins use the pair_decimals Kraken reports.
+type QueryCfg struct {
+	DecayHalfLife   time.Duration `yaml:"decay_half_life" env:"DECAY_HALF_LIFE" env-default:"10m"`
+	MaxDecayWindow  time.Duration `yaml:"max_decay_window" env:"MAX_DECAY_WINDOW" env-default:"24h"`
+	VerifyCacheHits bool          `yaml:"verify_cache_hits" env:"VERIFY_CACHE_HITS" env-default:"false"`
+	MaxStaleness    time.Duration `yaml:"max_staleness" env:"MAX_STALENESS" env-default:"0"`
+	MaxCacheAge     time.Duration `yaml:"max_cache_age" env:"MAX_CACHE_AGE" env-default:"0"`
+	MaxPriceGap     time.Duration `yaml:"max_price_gap" env:"MAX_PRICE_GAP" env-default:"0"`
+	MemoryCacheSize int           `yaml:"memory_cache_size" env:"MEMORY_CACHE_SIZE" env-default:"0"`
+	MaxRangePoints  int           `yaml:"max_range_points" env:"MAX_RANGE_POINTS" env-default:"1000"`
+
+	RejectBeforeFirst bool `yaml:"reject_before_first" env:"REJECT_BEFORE_FIRST" env-default:"false"`
+
+	WarmHotInterval time.Duration `yaml:"warm_hot_interval" env:"WARM_HOT_INTERVAL" env-default:"0"`
+	WarmHotCoins    int           `yaml:"warm_hot_coins" env:"WARM_HOT_COINS" env-default:"10"`
+
+	PriceDecimals map[string]int `yaml:"price_decimals" env:"PRICE_DECIMALS"`
+}
+
+// MinCollectInterval is the shortest collector.interval accepted, keeping
+// many collectors within Kraken's public rate limits.
+const MinCollectInterval = time.Second
+
+// Cache consistency modes of the collector.
+const (
+	CacheModeIndependent = "independent"
+	CacheModeWriteBehind = "write_behind"
+)
+
+// Exchanges prices can be collected from.
+const (
+	PriceSourceKraken   = "kraken"
+	PriceSourceCoinbase = "coinbase"
+)
+
+// Ways the prices of several exchanges are combined.
+const (
+	AggregateMedian = "median"
+	AggregateMean   = "mean"
+)
+
+// Timestamp precisions used for stored, cached and API timestamps.
+const (
+	PrecisionSeconds      = "s"
+	PrecisionMilliseconds = "ms"
+)
+
+// minMillisTimestamp separates second and millisecond Unix timestamps:
+// it is year 5138 in seconds and March 1973 in milliseconds.
+const minMillisTimestamp = 100_000_000_000
+
+// maxTimestamp is 3000-01-01T00:00:00Z in Unix seconds. Later timestamps are
+// rejected so that window arithmetic on them can't overflow int64.
+const maxTimestamp = 32_503_680_000
+
+// CollectorCfg controls how prices are collected and normalized before storing.
+// Interval is the time between two price fetches of a coin.
+// TimestampPrecision selects Unix seconds ("s") or milliseconds ("ms")
+// for every stored, cached and returned timestamp.
+// StoreDecimals rounds every price to the given number of decimal places
+// before it is written to Postgres and Redis; 0 disables rounding.
+// DepthLevels and DepthInterval bound the order-book snapshots taken for
+// coins added with depth tracking enabled.
+// The Breaker* fields configure the circuit breaker shared by all collectors:
+// once BreakerThreshold of the last BreakerWindow fetches fail, fetches are
+// skipped for BreakerCooldown. A zero threshold disables the breaker.
+// BackfillConcurrency bounds how many coins a backfill job fetches at once;
+// a finished job can be queried for BackfillJobTTL.
+// CacheMode selects how collected prices reach Redis: independently of the
+// database write, or only after it succeeded (write_behind). In write_behind
+// mode InvalidateCacheOnFailure also drops the coin's cache on a failed write.
+// WarmupPoints is how many of the latest stored prices are loaded into the
+// cache when a coin is added; 0 disables the warmup.
+// Schedule limits collection to weekly windows in ScheduleTimezone, e.g.
+// "Mon-Fri 09:30-16:00"; without windows prices are collected around the clock.
+// PriceSource selects the exchange prices are collected from (kraken or coinbase).
+// With PriceSources set, every listed exchange is queried instead and their
+// prices are combined by Aggregate (median or mean); exchanges failing or not
+// answering within SourceTimeout are left out.
+// CompareSources are further exchanges collected next to the primary one,
+// e.g. [coinbase], whose prices /currency/compare reports with the spread.
+// MaxCoins bounds how many coins are tracked at once; further adds are refused.
+// Workers is how many coins the collector loop fetches at once.
+type CollectorCfg struct {
+	Interval           time.Duration `yaml:"interval" env:"COLLECT_INTERVAL" env-default:"5s"`
+	TimestampPrecision string        `yaml:"timestamp_precision" env:"TIMESTAMP_PRECISION" env-default:"s"`
+	StoreDecimals      int           `yaml:"store_decimals" env:"STORE_DECIMALS" env-default:"0"`
+	DepthLevels        int           `yaml:"depth_levels" env:"DEPTH_LEVELS" env-default:"10"`
+	DepthInterval      time.Duration `yaml:"depth_interval" env:"DEPTH_INTERVAL" env-default:"30s"`
+	BreakerThreshold   float64       `yaml:"breaker_threshold" env:"BREAKER_THRESHOLD" env-default:"0.5"`
+	BreakerWindow      int           `yaml:"breaker_window" env:"BREAKER_WINDOW" env-default:"20"`
+	BreakerCooldown    time.Duration `yaml:"breaker_cooldown" env:"BREAKER_COOLDOWN" env-default:"30s"`
+
+	BackfillConcurrency int           `yaml:"backfill_concurrency" env:"BACKFILL_CONCURRENCY" env-default:"2"`
+	BackfillJobTTL      time.Duration `yaml:"backfill_job_ttl" env:"BACKFILL_JOB_TTL" env-default:"1h"`
+
+	CacheMode                string `yaml:"cache_mode" env:"CACHE_MODE" env-default:"independent"`
+	InvalidateCacheOnFailure bool   `yaml:"invalidate_cache_on_failure" env:"INVALIDATE_CACHE_ON_FAILURE" env-default:"false"`
+	WarmupPoints             int    `yaml:"warmup_points" env:"WARMUP_POINTS" env-default:"60"`
+	CollectOnAdd             bool   `yaml:"collect_on_add" env:"COLLECT_ON_ADD" env-default:"true"`
+
+	RestartBackoff      time.Duration `yaml:"restart_backoff" env:"RESTART_BACKOFF" env-default:"1s"`
+	RejectUnhealthyAdds bool          `yaml:"reject_unhealthy_adds" env:"REJECT_UNHEALTHY_ADDS" env-default:"true"`
+
+	DedupPrices  bool    `yaml:"dedup_prices" env:"DEDUP_PRICES" env-default:"true"`
+	DedupEpsilon float64 `yaml:"dedup_epsilon" env:"DEDUP_EPSILON" env-default:"0"`
+
+	Schedule         []string `yaml:"schedule" env:"COLLECT_SCHEDULE" env-separator:";"`
+	ScheduleTimezone string   `yaml:"schedule_timezone" env:"COLLECT_SCHEDULE_TIMEZONE" env-default:"UTC"`
+
+	PriceSource   string        `yaml:"price_source" env:"PRICE_SOURCE" env-default:"kraken"`
+	PriceSources  []string      `yaml:"price_sources" env:"PRICE_SOURCES" env-separator:","`
+	Aggregate     string        `yaml:"aggregate" env:"PRICE_AGGREGATE" env-default:"median"`
+	SourceTimeout time.Duration `yaml:"source_timeout" env:"PRICE_SOURCE_TIMEOUT" env-default:"3s"`
+
+	CompareSources []string `yaml:"compare_sources" env:"COMPARE_SOURCES" env-separator:","`
+
+	MaxCoins int `yaml:"max_coins" env:"MAX_COINS" env-default:"100"`
+
+	Workers int `yaml:"workers" env:"COLLECTOR_WORKERS" env-default:"8"`
+}
+
+// Milliseconds reports whether timestamps are Unix milliseconds.
+func (c CollectorCfg) Milliseconds() bool {
+	return c.TimestampPrecision == PrecisionMilliseconds
+}
+
+// Now returns the current Unix time in the configured precision.
+func (c CollectorCfg) Now() int64 {
+	return c.Timestamp(time.Now())
+}
+
+// Timestamp returns t as Unix time in the configured precision.
+func (c CollectorCfg) Timestamp(t time.Time) int64 {
+	if c.Milliseconds() {
+		return t.UnixMilli()
+	}
+	return t.Unix()
+}
+
+// Units converts a duration into the configured timestamp unit.
+func (c CollectorCfg) Units(d time.Duration) int64 {
+	if c.Milliseconds() {
+		return d.Milliseconds()
+	}
+	return int64(d / time.Second)
+}
+
+// CheckTimestamp verifies that a client-supplied timestamp has the configured
+// precision and lies between 1970 and the year 3000.
+func (c CollectorCfg) CheckTimestamp(ts int64) error {
+	if ts < 0 {
+		return fmt.Errorf("timestamp must not be negative")
+	}
+	if ts > c.FromUnixSeconds(maxTimestamp) {
+		return fmt.Errorf("timestamp must not be after the year 3000")
+	}
+	if c.Milliseconds() && ts < minMillisTimestamp {
+		return fmt.Errorf("timestamp must be in Unix milliseconds")
+	}
+	if !c.Milliseconds() && ts >= minMillisTimestamp {
+		return fmt.Errorf("timestamp must be in Unix seconds")
+	}
+	return nil
+}
+
+// FromUnixSeconds converts a Unix timestamp in seconds into the configured precision.
+func (c CollectorCfg) FromUnixSeconds(ts int64) int64 {
+	if c.Milliseconds() {
+		return ts * 1000
+	}
+	return ts
+}
+
+// Validate checks config values that cannot be expressed with struct tags.
+func (c *Config) Validate() error {
+	switch c.ServConf.JSONCase {
+	case JSONCaseSnake, JSONCaseCamel:
+	default:
+		return fmt.Errorf("server.json_case must be %q or %q, got %q",
+			JSONCaseSnake, JSONCaseCamel, c.ServConf.JSONCase)
+	}
+	for _, enc := range c.ServConf.Compression {
+		switch enc {
+		case EncodingBrotli, EncodingGzip:
+		default:
+			return fmt.Errorf("server.compression must only list %q and %q, got %q",
+				EncodingBrotli, EncodingGzip, enc)
+		}
+	}
+	switch c.CollConf.TimestampPrecision {
+	case PrecisionSeconds, PrecisionMilliseconds:
+	default:
+		return fmt.Errorf("collector.timestamp_precision must be %q or %q, got %q",
+			PrecisionSeconds, PrecisionMilliseconds, c.CollConf.TimestampPrecision)
+	}
+	switch c.CollConf.CacheMode {
+	case "", CacheModeIndependent, CacheModeWriteBehind:
+	default:
+		return fmt.Errorf("collector.cache_mode must be %q or %q, got %q",
+			CacheModeIndependent, CacheModeWriteBehind, c.CollConf.CacheMode)
+	}
+	for _, source := range append([]string{c.CollConf.PriceSource}, c.CollConf.PriceSources...) {
+		switch source {
+		case "", PriceSourceKraken, PriceSourceCoinbase:
+		default:
+			return fmt.Errorf("collector.price_source(s) must be %q or %q, got %q",
+				PriceSourceKraken, PriceSourceCoinbase, source)
+		}
+	}
+	for _, source := range c.CollConf.CompareSources {
+		switch source {
+		case PriceSourceKraken, PriceSourceCoinbase:
+		default:
+			return fmt.Errorf("collector.compare_sources must be %q or %q, got %q",
+				PriceSourceKraken, PriceSourceCoinbase, source)
+		}
+		if source == c.CollConf.PriceSource && len(c.CollConf.PriceSources) == 0 {
+			return fmt.Errorf("collector.compare_sources must not include the price source %q", source)
+		}
+	}
+	switch c.CollConf.Aggregate {
+	case "", AggregateMedian, AggregateMean:
+	default:
+		return fmt.Errorf("collector.aggregate must be %q or %q, got %q",
+			AggregateMedian, AggregateMean, c.CollConf.Aggregate)
+	}
+	switch c.DBConf.SSLMode {
+	case SSLModeDisable, SSLModeAllow, SSLModePrefer, SSLModeRequire, SSLModeVerifyCA, SSLModeVerifyFull:
+	default:
+		return fmt.Errorf("database.sslmode must be one of %q, %q, %q, %q, %q or %q, got %q",
+			SSLModeDisable, SSLModeAllow, SSLModePrefer, SSLModeRequire, SSLModeVerifyCA, SSLModeVerifyFull,
+			c.DBConf.SSLMode)
+	}
+	if c.DBConf.MaxOpenConns < 0 || c.DBConf.MaxIdleConns < 0 || c.DBConf.ConnMaxLifetime < 0 {
+		return fmt.Errorf("database.max_open_conns, max_idle_conns and conn_max_lifetime must not be negative")
+	}
+	if c.CollConf.Interval < MinCollectInterval {
+		return fmt.Errorf("collector.interval must be at least %s, got %s",
+			MinCollectInterval, c.CollConf.Interval)
+	}
+	if c.RDBConf.DataRetention <= c.CollConf.Interval {
+		return fmt.Errorf("redis.data_retention must be greater than collector.interval (%s), got %s",
+			c.CollConf.Interval, c.RDBConf.DataRetention)
+	}
+	if c.RDBConf.PoolSize < 0 || c.RDBConf.MinIdleConns < 0 {
+		return fmt.Errorf("redis.pool_size and min_idle_conns must not be negative")
+	}
+	if c.RDBConf.MasterName != "" && len(c.RDBConf.SentinelAddresses) == 0 {
+		return fmt.Errorf("redis.sentinel_addresses must be set with redis.master_name")
+	}
+	if c.CollConf.MaxCoins < 1 {
+		return fmt.Errorf("collector.max_coins must be at least 1, got %d", c.CollConf.MaxCoins)
+	}
+	if c.CollConf.Workers < 1 {
+		return fmt.Errorf("collector.workers must be at least 1, got %d", c.CollConf.Workers)
+	}
+	if c.CollConf.DedupEpsilon < 0 {
+		return fmt.Errorf("collector.dedup_epsilon must not be negative, got %v", c.CollConf.DedupEpsilon)
+	}
+	if _, err := ParseSchedule(c.CollConf.Schedule, c.CollConf.ScheduleTimezone); err != nil {
+		return fmt.Errorf("collector.schedule: %v", err)
+	}
+	if _, err := c.LogConf.SlogLevel(); err != nil {
+		return err
+	}
+	return nil
 }
 
 func MustLoad(path string) *Config {
@@ -38,26 +501,348 @@ func MustLoad(path string) *Config {
 		log.Fatal("Can't read the common config")
 		return nil
 	}
+	if err := conf.Validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
 	return conf
 }
 
+// AddCurrencyRequest starts tracking the coin's pair in Quote, by default the
+// configured quote currency. Pairs in other quotes are tracked as "COIN/QUOTE".
 type AddCurrencyRequest struct {
-	Coin string `json:"coin" binding:"required" example:"BTC"`
+	Coin  string `json:"coin" binding:"required" example:"BTC"`
+	Quote string `json:"quote,omitempty" binding:"omitempty,alphanum" example:"EUR"`
+	Depth bool   `json:"depth,omitempty" example:"false"`
+}
+
+// AddAllRequest selects the supported coins to track by symbol prefix; an
+// empty prefix matches every coin quoted in the configured quote currency.
+type AddAllRequest struct {
+	Prefix string `json:"prefix,omitempty" example:"BT"`
+	Limit  int    `json:"limit,omitempty" binding:"omitempty,min=1,max=100" example:"20"`
+}
+
+// AddAllResponse lists the coins that are now tracked, in symbol order, the
+// matching coins that were tracked already and those that could not be added.
+type AddAllResponse struct {
+	Quote    string         `json:"quote" example:"USD"`
+	Coins    []string       `json:"coins" example:"BTC,BTT"`
+	Skipped  []string       `json:"skipped" example:"BAT"`
+	Rejected []RejectedCoin `json:"rejected"`
+}
+
+type AddBatchRequest struct {
+	Coins []string `json:"coins" binding:"required,min=1,max=100,dive,required" example:"BTC,ETH,SOL"`
+}
+
+// AddBatchResponse reports the outcome of a batch add per coin.
+type AddBatchResponse struct {
+	Added          []string       `json:"added" example:"BTC,SOL"`
+	AlreadyTracked []string       `json:"already_tracked" example:"ETH"`
+	Unsupported    []string       `json:"unsupported" example:"FOO"`
+	Rejected       []RejectedCoin `json:"rejected"`
+}
+
+// RejectedCoin is a coin of a batch that could not be added and why, e.g.
+// because collector.max_coins was reached. Coins added before it stay tracked.
+type RejectedCoin struct {
+	Coin   string `json:"coin" example:"SOL"`
+	Reason string `json:"reason" example:"too many tracked coins"`
 }
 
 type RemoveCurrencyRequest struct {
 	Coin string `json:"coin" binding:"required" example:"BTC"`
 }
 
+// PriceRequest asks for the price at Timestamp, or at Relative, a negative Go
+// duration before now such as "-15m". Without either the current price is returned.
+// Match selects how stored prices around the time answer the request
+// (nearest by default, last_before, first_after or interpolate).
+// MaxGap, a Go duration such as "10m", overrides query.max_price_gap.
+// Quote selects the pair, by default the one in the configured quote currency.
 type PriceRequest struct {
 	Coin      string `json:"coin" binding:"required" example:"BTC"`
+	Quote     string `json:"quote,omitempty" binding:"omitempty,alphanum" example:"EUR"`
 	Timestamp *int64 `json:"timestamp,omitempty" example:"1736500490"`
+	Relative  string `json:"relative,omitempty" example:"-15m"`
+	Match     string `json:"match,omitempty" binding:"omitempty,oneof=nearest last_before first_after interpolate" example:"nearest"`
+	MaxGap    string `json:"max_gap,omitempty" example:"10m"`
 }
 
+// PriceUpdate is a collected price with its change from the previous price
+// collected for the coin; the changes are nil for the first price.
+type PriceUpdate struct {
+	Coin          string   `json:"coin" example:"BTC"`
+	Price         float64  `json:"price" example:"48523.42"`
+	Timestamp     int64    `json:"timestamp" example:"1736500490"`
+	Change        *float64 `json:"change,omitempty" example:"23.42"`
+	ChangePercent *float64 `json:"change_percent,omitempty" example:"0.048"`
+}
+
+// PriceResponse holds the price of the stored point answering a query and
+// the time of that point in Timestamp; RequestedTimestamp is the (resolved)
+// time that was asked for. Interpolated prices have both at the requested time.
 type PriceResponse struct {
+	Coin               string  `json:"coin" example:"BTC"`
+	Quote              string  `json:"quote" example:"USD"`
+	Price              float64 `json:"price" example:"48523.42"`
+	Timestamp          int64   `json:"timestamp" example:"1736500488"`
+	RequestedTimestamp int64   `json:"requested_timestamp" example:"1736500490"`
+	Source             string  `json:"source" example:"cache" enums:"memory,cache,db"`
+}
+
+// PortfolioRequest values Holdings, amounts by coin, at Timestamp (now when
+// omitted). With Strict set a coin without a price fails the request instead
+// of being left out of the total.
+type PortfolioRequest struct {
+	Holdings  map[string]float64 `json:"holdings" binding:"required,min=1,max=100" example:"BTC:0.5,ETH:3"`
+	Timestamp *int64             `json:"timestamp,omitempty" example:"1736500490"`
+	Quote     string             `json:"quote,omitempty" binding:"omitempty,alphanum" example:"USD"`
+	Strict    bool               `json:"strict,omitempty" example:"false"`
+}
+
+// PortfolioHolding is the value of one coin of a portfolio, priced with the
+// stored point at Timestamp.
+type PortfolioHolding struct {
 	Coin      string  `json:"coin" example:"BTC"`
-	Price     float64 `json:"price" example:"48523.42"`
+	Amount    float64 `json:"amount" example:"0.5"`
+	Price     float64 `json:"price" example:"48523.4"`
+	Value     float64 `json:"value" example:"24261.7"`
+	Timestamp int64   `json:"timestamp" example:"1736500488"`
+}
+
+// PortfolioResponse holds the total value of the holdings that have a price,
+// a breakdown by coin in symbol order and the coins without a price.
+type PortfolioResponse struct {
+	Quote     string             `json:"quote" example:"USD"`
+	Timestamp int64              `json:"timestamp" example:"1736500490"`
+	Total     float64            `json:"total" example:"33761.7"`
+	Holdings  []PortfolioHolding `json:"holdings"`
+	Missing   []string           `json:"missing" example:"SOL"`
+}
+
+// TickerRequest asks for the live ticker of Coin in Quote, by default the
+// configured quote currency.
+type TickerRequest struct {
+	Coin  string `json:"coin" binding:"required" example:"BTC"`
+	Quote string `json:"quote,omitempty" binding:"omitempty,alphanum" example:"EUR"`
+}
+
+// TickerResponse holds the live ticker of a pair; Spread is Ask - Bid.
+type TickerResponse struct {
+	Coin   string  `json:"coin" example:"BTC"`
+	Quote  string  `json:"quote" example:"USD"`
+	Last   float64 `json:"last" example:"48523.4"`
+	Bid    float64 `json:"bid" example:"48523.3"`
+	Ask    float64 `json:"ask" example:"48523.5"`
+	Spread float64 `json:"spread" example:"0.2"`
+	Volume float64 `json:"volume" example:"1523.7"`
+}
+
+type DepthRequest struct {
+	Coin      string `json:"coin" binding:"required" example:"BTC"`
+	Timestamp *int64 `json:"timestamp,omitempty" example:"1736500490"`
+}
+
+// DepthLevel is a single order-book level.
+type DepthLevel struct {
+	Price  float64 `json:"price" example:"48523.4"`
+	Volume float64 `json:"volume" example:"1.25"`
+}
+
+// OrderBook holds the top bid and ask levels of a pair, best price first.
+type OrderBook struct {
+	Bids []DepthLevel `json:"bids"`
+	Asks []DepthLevel `json:"asks"`
+}
+
+type DepthResponse struct {
+	Coin      string       `json:"coin" example:"BTC"`
+	Timestamp int64        `json:"timestamp" example:"1736500490"`
+	Bids      []DepthLevel `json:"bids"`
+	Asks      []DepthLevel `json:"asks"`
+}
+
+// RangeRequest asks for a page of the stored prices between From and To:
+// Limit points (query.max_range_points when omitted or larger) after skipping
+// the first Offset.
+type RangeRequest struct {
+	Coin   string `json:"coin" binding:"required" example:"BTC"`
+	From   int64  `json:"from" binding:"required" example:"1736500000"`
+	To     int64  `json:"to" binding:"required" example:"1736510000"`
+	Limit  int    `json:"limit,omitempty" binding:"min=0" example:"100"`
+	Offset int    `json:"offset,omitempty" binding:"min=0" example:"0"`
+}
+
+// RangeResponse holds a page of the stored prices of a window in time order.
+// Next is the offset of the following page, omitted on the last one.
+type RangeResponse struct {
+	Coin   string       `json:"coin" example:"BTC"`
+	Quote  string       `json:"quote" example:"USD"`
+	From   int64        `json:"from" example:"1736500000"`
+	To     int64        `json:"to" example:"1736510000"`
+	Points []PricePoint `json:"points"`
+	Next   *int         `json:"next,omitempty" example:"100"`
+}
+
+// PricePoint is a stored price at a point in time.
+type PricePoint struct {
 	Timestamp int64   `json:"timestamp" example:"1736500490"`
+	Price     float64 `json:"price" example:"48523.42"`
+}
+
+// Candle is an open/high/low/close price bucket starting at Timestamp.
+type Candle struct {
+	Timestamp int64   `json:"timestamp" example:"1736500440"`
+	Open      float64 `json:"open" example:"48501.1"`
+	High      float64 `json:"high" example:"48530.0"`
+	Low       float64 `json:"low" example:"48490.2"`
+	Close     float64 `json:"close" example:"48523.42"`
+}
+
+// OHLCRequest asks for the candles of Interval, a Go duration such as "5m",
+// between From and To. Interval must divide the window evenly.
+type OHLCRequest struct {
+	Coin     string `json:"coin" binding:"required" example:"BTC"`
+	From     int64  `json:"from" binding:"required" example:"1736500200"`
+	To       int64  `json:"to" binding:"required" example:"1736503800"`
+	Interval string `json:"interval" binding:"required" example:"5m"`
+}
+
+// OHLCResponse holds the candles of a window in time order; buckets without
+// stored prices are left out.
+type OHLCResponse struct {
+	Coin     string   `json:"coin" example:"BTC"`
+	Quote    string   `json:"quote" example:"USD"`
+	From     int64    `json:"from" example:"1736500200"`
+	To       int64    `json:"to" example:"1736503800"`
+	Interval string   `json:"interval" example:"5m0s"`
+	Candles  []Candle `json:"candles"`
+}
+
+type DecayRequest struct {
+	Coin      string `json:"coin" binding:"required" example:"BTC"`
+	Window    string `json:"window" binding:"required" example:"1h"`
+	HalfLife  string `json:"half_life,omitempty" example:"10m"`
+	Timestamp *int64 `json:"timestamp,omitempty" example:"1736500490"`
+}
+
+type DecayResponse struct {
+	Coin     string  `json:"coin" example:"BTC"`
+	Quote    string  `json:"quote" example:"USD"`
+	Price    float64 `json:"price" example:"48523.42"`
+	From     int64   `json:"from" example:"1736496890"`
+	To       int64   `json:"to" example:"1736500490"`
+	HalfLife string  `json:"half_life" example:"10m0s"`
+	Points   int     `json:"points" example:"720"`
+}
+
+type MergeCoinsRequest struct {
+	From string `json:"from" binding:"required" example:"XBT"`
+	To   string `json:"to" binding:"required" example:"BTC"`
+}
+
+type MergeCoinsResponse struct {
+	From string `json:"from" example:"XBT"`
+	To   string `json:"to" example:"BTC"`
+	Rows int64  `json:"rows" example:"1024"`
+}
+
+// Backfill job and per-coin states.
+const (
+	BackfillPending = "pending"
+	BackfillRunning = "running"
+	BackfillDone    = "done"
+	BackfillFailed  = "failed"
+)
+
+type BackfillRequest struct {
+	Coins []string `json:"coins" binding:"required,min=1,max=100,dive,required" example:"BTC,ETH"`
+	Since *int64   `json:"since,omitempty" example:"1736456400"`
+}
+
+// BackfillCoin reports the progress of one coin of a backfill job.
+type BackfillCoin struct {
+	Coin   string `json:"coin" example:"BTC"`
+	Status string `json:"status" example:"done"`
+	Rows   int64  `json:"rows" example:"720"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BackfillJob struct {
+	ID     string         `json:"id" example:"3f2a9c41d0e7b815"`
+	Status string         `json:"status" example:"running"`
+	Since  int64          `json:"since" example:"1736456400"`
+	Coins  []BackfillCoin `json:"coins"`
+}
+
+// StalePriceResponse is returned instead of a "current" price whose
+// collection has stalled.
+type StalePriceResponse struct {
+	Error      string `json:"error" example:"price is stale"`
+	LastUpdate int64  `json:"last_update,omitempty" example:"1736500190"`
+	Age        string `json:"age,omitempty" example:"5m0s"`
+}
+
+// NoDataBeforeResponse is returned for a price query before the coin's
+// first stored price, with the earliest timestamp that can be queried.
+type NoDataBeforeResponse struct {
+	Error    string `json:"error" example:"no data before the first stored price"`
+	Earliest int64  `json:"earliest" example:"1736400000"`
+}
+
+type BalanceResponse struct {
+	Balances map[string]float64 `json:"balances" swaggertype:"object,number" example:"BTC:0.5,USD:1200.25"`
+}
+
+// MigrationStatusResponse reports the applied schema migration version. A
+// dirty version failed half-way and must be fixed and forced by hand.
+type MigrationStatusResponse struct {
+	Version uint `json:"version" example:"5"`
+	Dirty   bool `json:"dirty" example:"false"`
+}
+
+// HotCoin is a coin with the number of price queries for it.
+type HotCoin struct {
+	Coin     string `json:"coin" example:"BTC"`
+	Accesses int64  `json:"accesses" example:"1024"`
+}
+
+type HotCoinsRequest struct {
+	Limit int `form:"limit" binding:"omitempty,min=1,max=100" example:"10"`
+}
+
+type HotCoinsResponse struct {
+	Coins []HotCoin `json:"coins"`
+}
+
+type StatusResponse struct {
+	Status string `json:"status" example:"ready"`
+}
+
+type CompareRequest struct {
+	Coin      string `json:"coin" binding:"required" example:"BTC"`
+	Timestamp *int64 `json:"timestamp,omitempty" example:"1736500490"`
+}
+
+// ExchangePrice is the price of one source nearest to the requested time.
+type ExchangePrice struct {
+	Source    string  `json:"source" example:"kraken"`
+	Price     float64 `json:"price" example:"50000.5"`
+	Timestamp int64   `json:"timestamp" example:"1736500488"`
+}
+
+// CompareResponse lists the price of every source with data. Spread is the
+// difference between the highest and lowest price and is omitted when fewer
+// than two sources have data; SpreadPercent is relative to the lowest price.
+type CompareResponse struct {
+	Coin          string          `json:"coin" example:"BTC"`
+	Quote         string          `json:"quote" example:"USD"`
+	Timestamp     int64           `json:"timestamp" example:"1736500490"`
+	Prices        []ExchangePrice `json:"prices"`
+	Missing       []string        `json:"missing,omitempty" example:"coinbase"`
+	Spread        *float64        `json:"spread,omitempty" example:"12.5"`
+	SpreadPercent *float64        `json:"spread_percent,omitempty" example:"0.025"`
 }
 
 type ErrorResponse struct {
@@ -69,6 +854,49 @@ type KrakenTickerResponse struct {
 	Result map[string]KrakenTickerDetails `json:"result"`
 }
 
+// KrakenTickerDetails holds the Ticker fields used: the last trade closed
+// [price, lot volume], the best ask and bid [price, whole lot volume, lot
+// volume] and the volume [today, last 24 hours].
 type KrakenTickerDetails struct {
 	C []string `json:"c"`
+	A []string `json:"a"`
+	B []string `json:"b"`
+	V []string `json:"v"`
+}
+
+// Ticker is a snapshot of a pair's market: the last trade price, the best
+// bid and ask and the volume traded in the last 24 hours.
+type Ticker struct {
+	Last   float64
+	Bid    float64
+	Ask    float64
+	Volume float64
+}
+
+// CoinbaseSpotResponse is the payload of the Coinbase spot price endpoint.
+// Errors is set instead of Data for failed requests.
+type CoinbaseSpotResponse struct {
+	Data struct {
+		Amount   string `json:"amount"`
+		Base     string `json:"base"`
+		Currency string `json:"currency"`
+	} `json:"data"`
+	Errors []CoinbaseError `json:"errors"`
+}
+
+type CoinbaseError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// KrakenDepthResponse is the Depth endpoint payload. Each level is encoded
+// by Kraken as [price, volume, timestamp] with price and volume as strings.
+type KrakenDepthResponse struct {
+	Error  []string                     `json:"error"`
+	Result map[string]KrakenDepthLevels `json:"result"`
+}
+
+type KrakenDepthLevels struct {
+	Asks [][]interface{} `json:"asks"`
+	Bids [][]interface{} `json:"bids"`
 }
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// Schedule is a set of weekly time windows, such as "Mon-Fri 09:30-16:00"
+// or "22:00-06:00", evaluated in a time zone. Windows without days apply to
+// every day; a window ending before it starts runs past midnight and belongs
+// to the day it starts on. A nil Schedule contains every time.
+type Schedule struct {
+	windows  []scheduleWindow
+	location *time.Location
+}
+
+type scheduleWindow struct {
+	days     [7]bool // indexed by time.Weekday
+	from, to int     // minutes since midnight
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses the windows of a schedule in the named time zone.
+// It returns nil if there are no windows.
+func ParseSchedule(windows []string, timezone string) (*Schedule, error) {
+	if len(windows) == 0 {
+		return nil, nil
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %v", timezone, err)
+	}
+
+	s := &Schedule{location: location}
+	for _, window := range windows {
+		w, err := parseScheduleWindow(strings.TrimSpace(window))
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %v", window, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// parseScheduleWindow parses "[days ]HH:MM-HH:MM", where days is a comma
+// separated list of days or day ranges such as "Mon-Fri,Sun".
+func parseScheduleWindow(window string) (scheduleWindow, error) {
+	var w scheduleWindow
+	fields := strings.Fields(window)
+	switch len(fields) {
+	case 1:
+		for d := range w.days {
+			w.days[d] = true
+		}
+	case 2:
+		if err := parseDays(fields[0], &w.days); err != nil {
+			return w, err
+		}
+	default:
+		return w, fmt.Errorf(`expected "[days ]HH:MM-HH:MM"`)
+	}
+
+	times := strings.Split(fields[len(fields)-1], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("expected a time range HH:MM-HH:MM")
+	}
+	var err error
+	if w.from, err = parseClock(times[0]); err != nil {
+		return w, err
+	}
+	if w.to, err = parseClock(times[1]); err != nil {
+		return w, err
+	}
+	if w.from == w.to {
+		return w, fmt.Errorf("window is empty")
+	}
+	return w, nil
+}
+
+// parseDays marks the days of a list such as "Mon-Fri,Sun". Ranges may wrap
+// around the week, e.g. "Fri-Mon".
+func parseDays(list string, days *[7]bool) error {
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return fmt.Errorf("invalid day range %q", part)
+		}
+		first, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight; "24:00" ends a day.
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > minutesPerDay {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	return hours*60 + minutes, nil
+}
+
+// Contains reports whether t falls into one of the schedule's windows.
+func (s *Schedule) Contains(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	t = t.In(s.location)
+	day := t.Weekday()
+	previous := (day + 6) % 7
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, w := range s.windows {
+		if w.from < w.to {
+			if w.days[day] && minute >= w.from && minute < w.to {
+				return true
+			}
+			continue
+		}
+		// Past midnight: the evening of a scheduled day or the morning after it
+		if (w.days[day] && minute >= w.from) || (w.days[previous] && minute < w.to) {
+			return true
+		}
+	}
+	return false
+}
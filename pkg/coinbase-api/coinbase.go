@@ -0,0 +1,150 @@
+package coinbase_api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"test-task1/models"
+	"time"
+)
+
+// DefaultBaseURL is the production Coinbase API host.
+const DefaultBaseURL = "https://api.coinbase.com"
+
+const defaultRequestTimeout = 10 * time.Second
+
+// baseURL is the host of sources created without one, see Configure.
+var baseURL = DefaultBaseURL
+
+// Configure applies the Coinbase section of the config to the sources
+// created afterwards.
+func Configure(c models.CoinbaseCfg) {
+	if c.BaseURL != "" {
+		baseURL = c.BaseURL
+	}
+}
+
+// symbolAliases maps Kraken's legacy asset codes to the ones Coinbase uses.
+var symbolAliases = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+// Source prices symbols with Coinbase spot prices. Symbols follow the
+// Kraken convention: a bare coin is priced in the default quote currency
+// and "COIN/QUOTE" in any other, e.g. "BTC/EUR".
+type Source struct {
+	baseURL string
+	quote   string
+	client  *http.Client
+}
+
+// NewSource returns a Source querying host (the configured one, see
+// Configure, when empty) that prices bare coins in quote.
+func NewSource(host, quote string) *Source {
+	if host == "" {
+		host = baseURL
+	}
+	return &Source{
+		baseURL: strings.TrimRight(host, "/"),
+		quote:   strings.ToUpper(quote),
+		client:  &http.Client{Timeout: defaultRequestTimeout},
+	}
+}
+
+// GetPrice returns the spot price of the symbol.
+func (s *Source) GetPrice(ctx context.Context, symbol string) (float64, error) {
+	const op = "coinbase.GetPrice"
+
+	url := fmt.Sprintf("%s/v2/prices/%s/spot", s.baseURL, s.pair(symbol))
+	body, err := s.fetch(ctx, url)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", op, err)
+	}
+
+	var spot models.CoinbaseSpotResponse
+	if err := json.Unmarshal(body, &spot); err != nil {
+		return 0, fmt.Errorf("%s: json parse error: %v", op, err)
+	}
+	price, err := strconv.ParseFloat(spot.Data.Amount, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid price format: %v", op, err)
+	}
+	return price, nil
+}
+
+// SupportedCoins returns the crypto currencies Coinbase lists, in
+// alphabetical order. They are priced in the default quote currency.
+// nil is returned when the list cannot be fetched.
+func (s *Source) SupportedCoins() []string {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	body, err := s.fetch(ctx, s.baseURL+"/v2/currencies/crypto")
+	if err != nil {
+		slog.Warn("failed to list Coinbase currencies", "err", err)
+		return nil
+	}
+	var currencies struct {
+		Data []struct {
+			Code string `json:"code"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &currencies); err != nil {
+		slog.Warn("failed to list Coinbase currencies", "err", fmt.Errorf("json parse error: %v", err))
+		return nil
+	}
+
+	coins := make([]string, 0, len(currencies.Data))
+	for _, c := range currencies.Data {
+		coins = append(coins, c.Code)
+	}
+	sort.Strings(coins)
+	return coins
+}
+
+// pair returns the Coinbase currency pair of the symbol, e.g. "BTC-USD".
+func (s *Source) pair(symbol string) string {
+	coin, quote := symbol, s.quote
+	if i := strings.LastIndex(symbol, "/"); i >= 0 {
+		coin, quote = symbol[:i], symbol[i+1:]
+	}
+	coin = strings.ToUpper(coin)
+	if alias, ok := symbolAliases[coin]; ok {
+		coin = alias
+	}
+	return coin + "-" + strings.ToUpper(quote)
+}
+
+// fetch GETs url and returns the response body. For error responses the
+// message Coinbase reports is returned if there is one.
+func (s *Source) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request error: %v", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read error: %v", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var failure models.CoinbaseSpotResponse
+		if json.Unmarshal(body, &failure) == nil && len(failure.Errors) > 0 {
+			return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, failure.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return body, nil
+}
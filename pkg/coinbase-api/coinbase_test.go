@@ -0,0 +1,71 @@
+package coinbase_api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPrice(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/v2/prices/BTC-USD/spot":
+			w.Write([]byte(`{"data":{"amount":"50123.45","base":"BTC","currency":"USD"}}`))
+		case "/v2/prices/ETH-EUR/spot":
+			w.Write([]byte(`{"data":{"amount":"2890.1","base":"ETH","currency":"EUR"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"id":"not_found","message":"Invalid base currency"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	src := NewSource(srv.URL+"/", "usd")
+
+	price, err := src.GetPrice(context.Background(), "BTC")
+	require.NoError(t, err)
+	assert.Equal(t, 50123.45, price)
+
+	// Kraken's legacy codes are mapped
+	price, err = src.GetPrice(context.Background(), "XBT")
+	require.NoError(t, err)
+	assert.Equal(t, 50123.45, price)
+
+	price, err = src.GetPrice(context.Background(), "ETH/EUR")
+	require.NoError(t, err)
+	assert.Equal(t, 2890.1, price)
+	assert.Equal(t, []string{"/v2/prices/BTC-USD/spot", "/v2/prices/BTC-USD/spot", "/v2/prices/ETH-EUR/spot"}, paths)
+
+	_, err = src.GetPrice(context.Background(), "NOPE")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected status 404: Invalid base currency")
+}
+
+func TestGetPriceMalformed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"amount":"n/a","base":"BTC","currency":"USD"}}`))
+	}))
+	defer srv.Close()
+
+	_, err := NewSource(srv.URL, "USD").GetPrice(context.Background(), "BTC")
+	assert.ErrorContains(t, err, "invalid price format")
+}
+
+func TestSupportedCoins(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/currencies/crypto", r.URL.Path)
+		w.Write([]byte(`{"data":[{"code":"ETH","name":"Ethereum"},{"code":"BTC","name":"Bitcoin"}]}`))
+	}))
+	defer srv.Close()
+
+	assert.Equal(t, []string{"BTC", "ETH"}, NewSource(srv.URL, "USD").SupportedCoins())
+
+	srv.Close()
+	assert.Nil(t, NewSource(srv.URL, "USD").SupportedCoins())
+}
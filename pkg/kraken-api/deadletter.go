@@ -0,0 +1,56 @@
+package kraken_api
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// deadLetterMaxBody is how much of a response body is logged.
+	deadLetterMaxBody = 4096
+	// deadLetterInterval is the minimum time between two logged bodies.
+	deadLetterInterval = time.Minute
+)
+
+// errAPI wraps errors Kraken reports in the "error" field of a well-formed response.
+var errAPI = errors.New("API returned error")
+
+var (
+	deadLetterEnabled bool
+	deadLetterLog     = log.Default()
+
+	deadLetterMu         sync.Mutex
+	deadLetterLast       time.Time
+	deadLetterSuppressed int
+)
+
+// deadLetter logs the raw body of a response that could not be parsed when
+// kraken.dead_letter is enabled, so changes of Kraken's API can be diagnosed
+// after the fact. Bodies are truncated to deadLetterMaxBody bytes and at most
+// one is logged per deadLetterInterval; the others are only counted. Errors
+// Kraken reported itself are not logged.
+func deadLetter(op string, body []byte, err error) {
+	if !deadLetterEnabled || errors.Is(err, errAPI) {
+		return
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	if time.Since(deadLetterLast) < deadLetterInterval {
+		deadLetterSuppressed++
+		return
+	}
+	deadLetterLast = time.Now()
+	suppressed := deadLetterSuppressed
+	deadLetterSuppressed = 0
+
+	truncated := ""
+	if len(body) > deadLetterMaxBody {
+		truncated = " (truncated)"
+		body = body[:deadLetterMaxBody]
+	}
+	deadLetterLog.Printf("kraken_api: dead letter from %s: %v (%d more since the last one); body%s: %s",
+		op, err, suppressed, truncated, body)
+}
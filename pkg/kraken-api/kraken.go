@@ -1,33 +1,240 @@
 package kraken_api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"golang.org/x/time/rate"
 	"io"
+	"log/slog"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"test-task1/models"
+	"time"
 )
 
+// DefaultQuote is the quote currency used when none is configured.
+const DefaultQuote = "USD"
+
+// DefaultBaseURL is the production Kraken API host.
+const DefaultBaseURL = "https://api.kraken.com"
+
+const (
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultRequestTimeout      = 10 * time.Second
+)
+
+// RetryConfig controls how requests to the public API are retried after a
+// transport error or a 5xx response. The n-th retry waits BaseDelay*2^(n-1).
+// 4xx responses and errors Kraken reports in a well-formed body are not retried.
+type RetryConfig struct {
+	MaxAttempts int // including the first request; 1 disables retries
+	BaseDelay   time.Duration
+}
+
+// Retry is the retry policy of all public API requests.
+var Retry = RetryConfig{MaxAttempts: 3, BaseDelay: 200 * time.Millisecond}
+
 var (
-	KrakenPairs   = make(map[string]string)
+	// pairs maps symbols (see Symbol) of the online pairs to their Kraken pair IDs.
+	// It is replaced as a whole by RefreshPairs, together with pairDecimals.
+	pairs         = make(map[string]string)
+	pairDecimals  = make(map[string]int) // symbol -> price decimals of its pair
+	pairsLoaded   bool                   // whether RefreshPairs ever replaced the pairs
+	pairsMu       sync.RWMutex
 	initPairsOnce sync.Once
+	quote         = DefaultQuote
+	baseURL       = DefaultBaseURL
+	httpClient    = newHTTPClient(defaultMaxIdleConnsPerHost, defaultIdleConnTimeout, defaultRequestTimeout)
+	// limiter is shared by all public API requests, so many collectors
+	// together stay below Kraken's rate limits.
+	limiter = newLimiter(0, 0)
 )
 
-func InitKrakenPairs() {
-	resp, err := http.Get("https://api.kraken.com/0/public/AssetPairs")
+// Configure applies the Kraken section of the config. It must be called
+// before the pairs are loaded; pairs loaded before are dropped.
+func Configure(c models.KrakenCfg) {
+	pairsMu.Lock()
+	pairs, pairDecimals, pairsLoaded = make(map[string]string), make(map[string]int), false
+	pairsMu.Unlock()
+
+	if c.Quote != "" {
+		quote = strings.ToUpper(c.Quote)
+	}
+	if c.BaseURL != "" {
+		baseURL = strings.TrimRight(c.BaseURL, "/")
+	}
+	deadLetterEnabled = c.DeadLetter
+	if c.RetryAttempts > 0 {
+		Retry.MaxAttempts = c.RetryAttempts
+	}
+	if c.RetryBaseDelay > 0 {
+		Retry.BaseDelay = c.RetryBaseDelay
+	}
+
+	idleConns, idleTimeout, timeout := c.MaxIdleConnsPerHost, c.IdleConnTimeout, c.RequestTimeout
+	if idleConns <= 0 {
+		idleConns = defaultMaxIdleConnsPerHost
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleConnTimeout
+	}
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	httpClient = newHTTPClient(idleConns, idleTimeout, timeout)
+	limiter = newLimiter(c.RateLimit, c.RateBurst)
+}
+
+// newLimiter returns a token bucket allowing perSecond requests per second
+// with bursts of burst (at least 1); perSecond <= 0 disables the limit.
+func newLimiter(perSecond float64, burst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// newHTTPClient returns a client that keeps connections to the Kraken API
+// alive between requests instead of dialing for every collector tick.
+// All collectors talk to the same host, so the per-host idle pool is what
+// matters; HTTP/2 multiplexes concurrent requests over one connection.
+// Every request, including reading the body, is bounded by timeout so a hung
+// connection cannot block a collector.
+func newHTTPClient(maxIdleConnsPerHost int, idleConnTimeout, timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConnsPerHost
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	transport.ForceAttemptHTTP2 = true
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// errStatus is returned by fetch for non-2xx responses.
+var errStatus = errors.New("unexpected status")
+
+// fetch GETs url and returns the response body, retrying transport errors
+// and 5xx responses according to Retry. Every attempt first waits for the
+// rate limiter. Cancelling ctx aborts the request in flight and the waits.
+func fetch(ctx context.Context, url string) ([]byte, error) {
+	attempts := Retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var (
+			body      []byte
+			retryable bool
+		)
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("rate limit wait: %v", err)
+		}
+		body, retryable, err = fetchOnce(ctx, url)
+		if err == nil || !retryable || attempt == attempts || ctx.Err() != nil {
+			return body, err
+		}
+
+		timer := time.NewTimer(Retry.BaseDelay << (attempt - 1))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// fetchOnce GETs url once; retryable reports whether a failure may be transient.
+func fetchOnce(ctx context.Context, url string) (body []byte, retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		fmt.Printf("kraken_api: failed to fetch asset pairs: %v\n", err)
-		return
+		return nil, false, fmt.Errorf("request error: %v", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("request error: %v", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("kraken_api: failed to read response: %v\n", err)
-		return
+		return nil, true, fmt.Errorf("read error: %v", err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, true, fmt.Errorf("%w %d", errStatus, resp.StatusCode)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, false, fmt.Errorf("%w %d", errStatus, resp.StatusCode)
+	}
+	return body, false, nil
+}
+
+// Quote returns the quote currency all tracked pairs are denominated in.
+func Quote() string {
+	return quote
+}
+
+// Symbol returns the symbol the coin's pair in quote is tracked and stored
+// under: the bare coin in the configured quote currency, which is also used
+// when quote is empty, and "COIN/QUOTE" in any other, e.g. "BTC/EUR".
+func Symbol(coin, q string) string {
+	q = strings.ToUpper(q)
+	if q == "" || q == quote {
+		return coin
+	}
+	return coin + "/" + q
+}
+
+// SplitSymbol is the inverse of Symbol.
+func SplitSymbol(symbol string) (coin, q string) {
+	if i := strings.LastIndex(symbol, "/"); i >= 0 {
+		return symbol[:i], symbol[i+1:]
+	}
+	return symbol, quote
+}
+
+// ErrNoPairs is returned by RefreshPairs when Kraken lists no online pair in
+// the configured quote currency, e.g. because of a typo in kraken.quote.
+var ErrNoPairs = errors.New("no tradable pairs for the quote currency")
+
+// InitKrakenPairs loads the pairs, logging a failure. The first lookup of a
+// pair calls it once in case the pairs were not loaded on startup.
+func InitKrakenPairs() {
+	if err := RefreshPairs(context.Background()); err != nil {
+		slog.Error("failed to load Kraken pairs", "err", err)
+	}
+}
+
+// loadPairs calls InitKrakenPairs once before the first lookup of a pair,
+// unless RefreshPairs already loaded the pairs, e.g. on startup.
+func loadPairs() {
+	pairsMu.RLock()
+	loaded := pairsLoaded
+	pairsMu.RUnlock()
+	if !loaded {
+		initPairsOnce.Do(InitKrakenPairs)
+	}
+}
+
+// RefreshPairs fetches the online pairs of every quote currency and replaces
+// the loaded ones, so listed pairs are added and delisted ones removed. It
+// fails with ErrNoPairs if none is quoted in the configured one; the pairs
+// are replaced anyway. On any other error the loaded pairs are kept.
+func RefreshPairs(ctx context.Context) error {
+	body, err := fetch(ctx, baseURL+"/0/public/AssetPairs")
+	if err != nil {
+		return fmt.Errorf("failed to fetch asset pairs: %v", err)
 	}
 
 	var result struct {
@@ -36,31 +243,123 @@ func InitKrakenPairs() {
 	}
 
 	if err := json.Unmarshal(body, &result); err != nil {
-		fmt.Printf("kraken_api: failed to parse JSON: %v\n", err)
-		return
+		deadLetter("kraken.RefreshPairs", body, err)
+		return fmt.Errorf("failed to parse JSON: %v", err)
+	}
+	if len(result.Error) > 0 {
+		return fmt.Errorf("failed to fetch asset pairs: %s", strings.Join(result.Error, ", "))
 	}
 
+	loaded := make(map[string]string, len(result.Result))
+	decimals := make(map[string]int, len(result.Result))
+	found := 0
 	for pairID, data := range result.Result {
 		if status, ok := data["status"].(string); !ok || status != "online" {
 			continue
 		}
 		wsname, _ := data["wsname"].(string)
 
-		if !strings.HasSuffix(wsname, "/USD") {
-			continue
-		}
-
 		parts := strings.Split(wsname, "/")
 		if len(parts) != 2 {
 			continue
 		}
 
-		baseSymbol := parts[0]
-		mappedSymbol := mapSpecialSymbols(baseSymbol)
-		KrakenPairs[mappedSymbol] = pairID
+		// Special symbols only occur as base assets, quotes keep Kraken's names
+		symbol := Symbol(mapSpecialSymbols(parts[0]), parts[1])
+		loaded[symbol] = pairID
+		if d, ok := data["pair_decimals"].(float64); ok {
+			decimals[symbol] = int(d)
+		}
+		if parts[1] == quote {
+			found++
+		}
+	}
+
+	pairsMu.Lock()
+	pairs, pairDecimals, pairsLoaded = loaded, decimals, true
+	pairsMu.Unlock()
+
+	if found == 0 {
+		return fmt.Errorf("%w %s", ErrNoPairs, quote)
+	}
+	return nil
+}
+
+// RefreshPairsEvery calls RefreshPairs at every interval until ctx is
+// cancelled, so pairs Kraken lists after startup can be tracked. A failed
+// refresh is logged and the previously loaded pairs stay in use.
+func RefreshPairsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := RefreshPairs(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("failed to refresh Kraken pairs", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
+// IsSupported reports whether Kraken lists an online pair for the symbol
+// (see Symbol).
+func IsSupported(symbol string) bool {
+	_, ok := PairID(symbol)
+	return ok
+}
+
+// PairsLoaded reports whether the pairs were ever loaded from Kraken. Until
+// they are, IsSupported is false for every symbol because Kraken could not be
+// asked, not because it does not list them.
+func PairsLoaded() bool {
+	loadPairs()
+
+	pairsMu.RLock()
+	defer pairsMu.RUnlock()
+	return pairsLoaded
+}
+
+// PairID returns the Kraken pair ID of the symbol (see Symbol). ok is false
+// if Kraken lists no online pair for it.
+func PairID(symbol string) (pairID string, ok bool) {
+	loadPairs()
+
+	pairsMu.RLock()
+	defer pairsMu.RUnlock()
+	pairID, ok = pairs[symbol]
+	return pairID, ok
+}
+
+// PairDecimals returns the number of decimals Kraken quotes the price of the
+// symbol's pair with, as loaded by RefreshPairs.
+func PairDecimals(symbol string) (int, bool) {
+	pairsMu.RLock()
+	defer pairsMu.RUnlock()
+	decimals, ok := pairDecimals[symbol]
+	return decimals, ok
+}
+
+// Coins returns the coins of all loaded pairs in the configured quote
+// currency in alphabetical order.
+func Coins() []string {
+	loadPairs()
+
+	pairsMu.RLock()
+	coins := make([]string, 0, len(pairs))
+	for symbol := range pairs {
+		if !strings.Contains(symbol, "/") {
+			coins = append(coins, symbol)
+		}
+	}
+	pairsMu.RUnlock()
+
+	sort.Strings(coins)
+	return coins
+}
+
 func mapSpecialSymbols(symbol string) string {
 	specialCases := map[string]string{
 		"XBT": "BTC",
@@ -74,51 +373,330 @@ func mapSpecialSymbols(symbol string) string {
 	return symbol
 }
 
-func GetPrice(coin string) (float64, error) {
-	const op = "kraken.GetPrice"
+// GetPrice returns the last trade price of the coin in quote; an empty quote
+// selects the configured quote currency. It is the Last price of GetTicker.
+func GetPrice(ctx context.Context, coin, quote string) (float64, error) {
+	ticker, err := GetTicker(ctx, coin, quote)
+	if err != nil {
+		return 0, err
+	}
+	return ticker.Last, nil
+}
 
-	initPairsOnce.Do(InitKrakenPairs)
+// GetTicker returns the last trade price, the best bid and ask and the
+// 24-hour volume of the coin in quote; an empty quote selects the configured
+// quote currency.
+func GetTicker(ctx context.Context, coin, quote string) (models.Ticker, error) {
+	const op = "kraken.GetTicker"
 
-	pairID, ok := KrakenPairs[coin]
+	symbol := Symbol(coin, quote)
+	pairID, ok := PairID(symbol)
 	if !ok {
-		return 0, fmt.Errorf("%s: token doesn't exist: %s", op, coin)
+		return models.Ticker{}, fmt.Errorf("%s: token doesn't exist: %s", op, symbol)
 	}
 
-	url := fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=%s", pairID)
+	url := fmt.Sprintf("%s/0/public/Ticker?pair=%s", baseURL, pairID)
 
-	resp, err := http.Get(url)
+	body, err := fetch(ctx, url)
 	if err != nil {
-		return 0, fmt.Errorf("%s: request error: %v", op, err)
+		return models.Ticker{}, fmt.Errorf("%s: %v", op, err)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	ticker, err := parseTicker(body, pairID)
 	if err != nil {
-		return 0, fmt.Errorf("%s: read error: %v", op, err)
+		deadLetter(op, body, err)
+		return models.Ticker{}, fmt.Errorf("%s: %v", op, err)
 	}
+	return ticker, nil
+}
 
+// parseTicker decodes a Ticker response body for the given pair. The last
+// trade price is required; ask, bid and volume are left zero when missing.
+func parseTicker(body []byte, pairID string) (models.Ticker, error) {
 	var ticker models.KrakenTickerResponse
 	if err := json.Unmarshal(body, &ticker); err != nil {
-		return 0, fmt.Errorf("%s: json parse error: %v", op, err)
+		return models.Ticker{}, fmt.Errorf("json parse error: %v", err)
 	}
 
 	if len(ticker.Error) > 0 {
-		return 0, fmt.Errorf("%s: API returned error: %v", op, ticker.Error)
+		return models.Ticker{}, fmt.Errorf("%w: %v", errAPI, ticker.Error)
 	}
 
 	pairData, ok := ticker.Result[pairID]
 	if !ok {
-		return 0, fmt.Errorf("%s: no data for pair %s", op, pairID)
+		return models.Ticker{}, fmt.Errorf("no data for pair %s", pairID)
 	}
 
 	if len(pairData.C) < 1 {
-		return 0, fmt.Errorf("%s: no price data in response", op)
+		return models.Ticker{}, fmt.Errorf("no price data in response")
+	}
+
+	var result models.Ticker
+	var err error
+	if result.Last, err = strconv.ParseFloat(pairData.C[0], 64); err != nil {
+		return models.Ticker{}, fmt.Errorf("invalid price format: %v", err)
+	}
+	if result.Ask, err = parseTickerField(pairData.A, 0); err != nil {
+		return models.Ticker{}, fmt.Errorf("invalid ask format: %v", err)
+	}
+	if result.Bid, err = parseTickerField(pairData.B, 0); err != nil {
+		return models.Ticker{}, fmt.Errorf("invalid bid format: %v", err)
+	}
+	// v holds the volume of today and of the last 24 hours
+	if result.Volume, err = parseTickerField(pairData.V, 1); err != nil {
+		return models.Ticker{}, fmt.Errorf("invalid volume format: %v", err)
 	}
 
-	price, err := strconv.ParseFloat(pairData.C[0], 64)
+	return result, nil
+}
+
+// parseTickerField parses the i-th value of a Ticker array, 0 if it is missing.
+func parseTickerField(values []string, i int) (float64, error) {
+	if len(values) <= i {
+		return 0, nil
+	}
+	return strconv.ParseFloat(values[i], 64)
+}
+
+// GetDepth returns the top count bid and ask levels of the coin's order book.
+// The coin may be a symbol of another quote currency, see Symbol.
+func GetDepth(ctx context.Context, coin string, count int) (models.OrderBook, error) {
+	const op = "kraken.GetDepth"
+
+	pairID, ok := PairID(coin)
+	if !ok {
+		return models.OrderBook{}, fmt.Errorf("%s: token doesn't exist: %s", op, coin)
+	}
+
+	url := fmt.Sprintf("%s/0/public/Depth?pair=%s&count=%d", baseURL, pairID, count)
+
+	body, err := fetch(ctx, url)
+	if err != nil {
+		return models.OrderBook{}, fmt.Errorf("%s: %v", op, err)
+	}
+
+	book, err := parseDepth(body, pairID)
+	if err != nil {
+		deadLetter(op, body, err)
+		return models.OrderBook{}, fmt.Errorf("%s: %v", op, err)
+	}
+	return book, nil
+}
+
+// parseDepth decodes a Depth response body for the given pair.
+func parseDepth(body []byte, pairID string) (models.OrderBook, error) {
+	var depth models.KrakenDepthResponse
+	if err := json.Unmarshal(body, &depth); err != nil {
+		return models.OrderBook{}, fmt.Errorf("json parse error: %v", err)
+	}
+
+	if len(depth.Error) > 0 {
+		return models.OrderBook{}, fmt.Errorf("%w: %v", errAPI, depth.Error)
+	}
+
+	levels, ok := depth.Result[pairID]
+	if !ok {
+		return models.OrderBook{}, fmt.Errorf("no data for pair %s", pairID)
+	}
+
+	bids, err := parseLevels(levels.Bids)
+	if err != nil {
+		return models.OrderBook{}, fmt.Errorf("invalid bids: %v", err)
+	}
+	asks, err := parseLevels(levels.Asks)
+	if err != nil {
+		return models.OrderBook{}, fmt.Errorf("invalid asks: %v", err)
+	}
+
+	return models.OrderBook{Bids: bids, Asks: asks}, nil
+}
+
+// parseLevels converts raw [price, volume, timestamp] entries into DepthLevel values.
+func parseLevels(raw [][]interface{}) ([]models.DepthLevel, error) {
+	levels := make([]models.DepthLevel, 0, len(raw))
+	for _, entry := range raw {
+		if len(entry) < 2 {
+			return nil, fmt.Errorf("malformed level: %v", entry)
+		}
+		priceStr, ok := entry[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("malformed price: %v", entry[0])
+		}
+		volumeStr, ok := entry[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("malformed volume: %v", entry[1])
+		}
+
+		price, err := strconv.ParseFloat(priceStr, 64)
+		if err != nil {
+			return nil, err
+		}
+		volume, err := strconv.ParseFloat(volumeStr, 64)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, models.DepthLevel{Price: price, Volume: volume})
+	}
+	return levels, nil
+}
+
+// GetOHLC returns one-minute candles of the coin starting at since (Unix seconds).
+// Kraken serves at most the 720 most recent candles. The coin may be a symbol
+// of another quote currency, see Symbol.
+func GetOHLC(ctx context.Context, coin string, since int64) ([]models.Candle, error) {
+	const op = "kraken.GetOHLC"
+
+	pairID, ok := PairID(coin)
+	if !ok {
+		return nil, fmt.Errorf("%s: token doesn't exist: %s", op, coin)
+	}
+
+	url := fmt.Sprintf("%s/0/public/OHLC?pair=%s&interval=1&since=%d", baseURL, pairID, since)
+
+	body, err := fetch(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+
+	candles, err := parseOHLC(body, pairID)
+	if err != nil {
+		deadLetter(op, body, err)
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	return candles, nil
+}
+
+// parseOHLC decodes an OHLC response body for the given pair. Each candle is
+// encoded by Kraken as [time, open, high, low, close, vwap, volume, count].
+func parseOHLC(body []byte, pairID string) ([]models.Candle, error) {
+	var ohlc struct {
+		Error  []string                   `json:"error"`
+		Result map[string]json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &ohlc); err != nil {
+		return nil, fmt.Errorf("json parse error: %v", err)
+	}
+
+	if len(ohlc.Error) > 0 {
+		return nil, fmt.Errorf("%w: %v", errAPI, ohlc.Error)
+	}
+
+	raw, ok := ohlc.Result[pairID]
+	if !ok {
+		return nil, fmt.Errorf("no data for pair %s", pairID)
+	}
+
+	var entries [][]interface{}
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("json parse error: %v", err)
+	}
+
+	candles := make([]models.Candle, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry) < 5 {
+			return nil, fmt.Errorf("malformed candle: %v", entry)
+		}
+		ts, ok := entry[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("malformed candle time: %v", entry[0])
+		}
+
+		var prices [4]float64
+		for i := range prices {
+			str, ok := entry[i+1].(string)
+			if !ok {
+				return nil, fmt.Errorf("malformed candle price: %v", entry[i+1])
+			}
+			price, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return nil, err
+			}
+			prices[i] = price
+		}
+
+		candles = append(candles, models.Candle{
+			Timestamp: int64(ts),
+			Open:      prices[0],
+			High:      prices[1],
+			Low:       prices[2],
+			Close:     prices[3],
+		})
+	}
+	return candles, nil
+}
+
+// GetLastTrade returns the price and time of the coin's most recent trade in
+// quote. Unlike GetPrice it reports when the price was actually traded.
+func GetLastTrade(ctx context.Context, coin, quote string) (float64, time.Time, error) {
+	const op = "kraken.GetLastTrade"
+
+	symbol := Symbol(coin, quote)
+	pairID, ok := PairID(symbol)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("%s: token doesn't exist: %s", op, symbol)
+	}
+
+	url := fmt.Sprintf("%s/0/public/Trades?pair=%s&count=1", baseURL, pairID)
+
+	body, err := fetch(ctx, url)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%s: %v", op, err)
+	}
+
+	price, at, err := parseLastTrade(body, pairID)
+	if err != nil {
+		deadLetter(op, body, err)
+		return 0, time.Time{}, fmt.Errorf("%s: %v", op, err)
+	}
+	return price, at, nil
+}
+
+// parseLastTrade decodes a Trades response body for the given pair and returns
+// its newest trade. Each trade is encoded by Kraken as
+// [price, volume, time, buy/sell, market/limit, miscellaneous, trade id].
+func parseLastTrade(body []byte, pairID string) (float64, time.Time, error) {
+	var trades struct {
+		Error  []string                   `json:"error"`
+		Result map[string]json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &trades); err != nil {
+		return 0, time.Time{}, fmt.Errorf("json parse error: %v", err)
+	}
+
+	if len(trades.Error) > 0 {
+		return 0, time.Time{}, fmt.Errorf("%w: %v", errAPI, trades.Error)
+	}
+
+	raw, ok := trades.Result[pairID]
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("no data for pair %s", pairID)
+	}
+
+	var entries [][]interface{}
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return 0, time.Time{}, fmt.Errorf("json parse error: %v", err)
+	}
+	if len(entries) == 0 {
+		return 0, time.Time{}, fmt.Errorf("no trades for pair %s", pairID)
+	}
+
+	last := entries[len(entries)-1]
+	if len(last) < 3 {
+		return 0, time.Time{}, fmt.Errorf("malformed trade: %v", last)
+	}
+	str, ok := last[0].(string)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("malformed trade price: %v", last[0])
+	}
+	price, err := strconv.ParseFloat(str, 64)
 	if err != nil {
-		return 0, fmt.Errorf("%s: invalid price format: %v", op, err)
+		return 0, time.Time{}, fmt.Errorf("invalid price format: %v", err)
+	}
+	ts, ok := last[2].(float64)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("malformed trade time: %v", last[2])
 	}
 
-	return price, nil
+	sec, frac := math.Modf(ts)
+	return price, time.Unix(int64(sec), int64(frac*1e9)), nil
 }
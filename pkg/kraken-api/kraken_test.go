@@ -0,0 +1,556 @@
+package kraken_api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test-task1/models"
+)
+
+func TestParseDepth(t *testing.T) {
+	body := []byte(`{
+		"error": [],
+		"result": {
+			"XXBTZUSD": {
+				"asks": [["52523.00000", "1.199", 1616663113], ["52536.00000", "0.300", 1616663112]],
+				"bids": [["52522.90000", "0.753", 1616663112]]
+			}
+		}
+	}`)
+
+	book, err := parseDepth(body, "XXBTZUSD")
+	require.NoError(t, err)
+	assert.Equal(t, []models.DepthLevel{{Price: 52522.9, Volume: 0.753}}, book.Bids)
+	assert.Equal(t, []models.DepthLevel{
+		{Price: 52523, Volume: 1.199},
+		{Price: 52536, Volume: 0.3},
+	}, book.Asks)
+
+	t.Run("api error", func(t *testing.T) {
+		_, err := parseDepth([]byte(`{"error":["EQuery:Unknown asset pair"]}`), "XXBTZUSD")
+		assert.Error(t, err)
+	})
+
+	t.Run("missing pair", func(t *testing.T) {
+		_, err := parseDepth([]byte(`{"error":[],"result":{}}`), "XXBTZUSD")
+		assert.Error(t, err)
+	})
+
+	t.Run("malformed level", func(t *testing.T) {
+		_, err := parseDepth([]byte(`{"error":[],"result":{"XXBTZUSD":{"asks":[[1, 2]],"bids":[]}}}`), "XXBTZUSD")
+		assert.Error(t, err)
+	})
+}
+
+// countConns starts a test server and counts the TCP connections it accepts.
+func countConns(t testing.TB) (*httptest.Server, *atomic.Int32) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":[],"result":{}}`))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+func get(t testing.TB, client *http.Client, url string) {
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestHTTPClientReusesConnections(t *testing.T) {
+	srv, conns := countConns(t)
+	client := newHTTPClient(defaultMaxIdleConnsPerHost, defaultIdleConnTimeout, defaultRequestTimeout)
+
+	for i := 0; i < 10; i++ {
+		get(t, client, srv.URL)
+	}
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func BenchmarkHTTPClient(b *testing.B) {
+	b.Run("keep-alive", func(b *testing.B) {
+		srv, _ := countConns(b)
+		client := newHTTPClient(defaultMaxIdleConnsPerHost, defaultIdleConnTimeout, defaultRequestTimeout)
+		for i := 0; i < b.N; i++ {
+			get(b, client, srv.URL)
+		}
+	})
+
+	b.Run("new connection per request", func(b *testing.B) {
+		srv, _ := countConns(b)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DisableKeepAlives = true
+		client := &http.Client{Transport: transport}
+		for i := 0; i < b.N; i++ {
+			get(b, client, srv.URL)
+		}
+	})
+}
+
+func TestParseTicker(t *testing.T) {
+	body := []byte(`{
+		"error": [],
+		"result": {
+			"XXBTZUSD": {
+				"a": ["30300.10000", "1", "1.000"],
+				"b": ["30300.00000", "2", "2.000"],
+				"c": ["30303.20000", "0.00067643"],
+				"v": ["4083.67001100", "4412.73601799"]
+			}
+		}
+	}`)
+
+	ticker, err := parseTicker(body, "XXBTZUSD")
+	require.NoError(t, err)
+	assert.Equal(t, models.Ticker{Last: 30303.2, Bid: 30300, Ask: 30300.1, Volume: 4412.73601799}, ticker)
+
+	// Only the last trade price is required
+	ticker, err = parseTicker([]byte(`{"error":[],"result":{"XXBTZUSD":{"c":["123.45","1"]}}}`), "XXBTZUSD")
+	require.NoError(t, err)
+	assert.Equal(t, models.Ticker{Last: 123.45}, ticker)
+
+	_, err = parseTicker([]byte(`{"error":[],"result":{"XXBTZUSD":{"c":["1","1"],"a":["ask","1","1"]}}}`), "XXBTZUSD")
+	assert.Error(t, err)
+}
+
+func TestParseOHLC(t *testing.T) {
+	body := []byte(`{
+		"error": [],
+		"result": {
+			"XXBTZUSD": [
+				[1688671200, "30306.1", "30306.2", "30305.7", "30305.7", "30306.1", "3.39243896", 23],
+				[1688671260, "30305.7", "30310.0", "30300.0", "30308.5", "30305.9", "1.2", 7]
+			],
+			"last": 1688671260
+		}
+	}`)
+
+	candles, err := parseOHLC(body, "XXBTZUSD")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Candle{
+		{Timestamp: 1688671200, Open: 30306.1, High: 30306.2, Low: 30305.7, Close: 30305.7},
+		{Timestamp: 1688671260, Open: 30305.7, High: 30310, Low: 30300, Close: 30308.5},
+	}, candles)
+
+	_, err = parseOHLC([]byte(`{"error":[],"result":{"XXBTZUSD":[[1688671200, 1]]}}`), "XXBTZUSD")
+	assert.Error(t, err)
+}
+
+func TestConfigureBaseURL(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/0/public/AssetPairs":
+			w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"wsname":"XBT/USD","status":"online"}}}`))
+		case "/0/public/Ticker":
+			w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"c":["123.45","1"]}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// Restore the package state for the other tests
+	oldBaseURL, oldClient, oldPairs := baseURL, httpClient, pairs
+	defer func() {
+		baseURL, httpClient, pairs = oldBaseURL, oldClient, oldPairs
+		initPairsOnce = sync.Once{}
+	}()
+	pairs = make(map[string]string)
+	initPairsOnce = sync.Once{}
+
+	Configure(models.KrakenCfg{BaseURL: srv.URL + "/"})
+
+	price, err := GetPrice(context.Background(), "BTC", "")
+	require.NoError(t, err)
+	assert.Equal(t, 123.45, price)
+	assert.Equal(t, []string{"/0/public/AssetPairs", "/0/public/Ticker"}, paths)
+}
+
+func TestRefreshPairsNoMatchingQuote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"wsname":"XBT/USD","status":"online"}}}`))
+	}))
+	defer srv.Close()
+
+	oldBaseURL, oldClient, oldPairs, oldQuote := baseURL, httpClient, pairs, quote
+	defer func() {
+		baseURL, httpClient, pairs, quote = oldBaseURL, oldClient, oldPairs, oldQuote
+	}()
+	pairs = make(map[string]string)
+
+	Configure(models.KrakenCfg{BaseURL: srv.URL, Quote: "xyz"})
+	err := RefreshPairs(context.Background())
+	assert.ErrorIs(t, err, ErrNoPairs)
+	assert.EqualError(t, err, "no tradable pairs for the quote currency XYZ")
+	assert.Equal(t, map[string]string{"BTC/USD": "XXBTZUSD"}, pairs)
+
+	pairs = make(map[string]string)
+	Configure(models.KrakenCfg{Quote: "USD"})
+	require.NoError(t, RefreshPairs(context.Background()))
+	assert.Equal(t, map[string]string{"BTC": "XXBTZUSD"}, pairs)
+}
+
+// Test pairs loaded by RefreshPairs, e.g. on startup, are not fetched again
+// by the first lookup
+func TestRefreshPairsSkipsLazyLoad(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"wsname":"XBT/USD","status":"online"}}}`))
+	}))
+	defer srv.Close()
+
+	oldBaseURL, oldClient, oldPairs := baseURL, httpClient, pairs
+	defer func() {
+		baseURL, httpClient, pairs = oldBaseURL, oldClient, oldPairs
+		initPairsOnce = sync.Once{}
+	}()
+	initPairsOnce = sync.Once{}
+
+	Configure(models.KrakenCfg{BaseURL: srv.URL, Quote: "USD"})
+	require.NoError(t, RefreshPairs(context.Background()))
+
+	assert.True(t, PairsLoaded())
+	assert.True(t, IsSupported("BTC"))
+	assert.Equal(t, []string{"BTC"}, Coins())
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestRefreshPairsQuotes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":[],"result":{
+			"XXBTZUSD":{"wsname":"XBT/USD","status":"online","pair_decimals":1},
+			"XXBTZEUR":{"wsname":"XBT/EUR","status":"online","pair_decimals":2},
+			"XDGEUR":{"wsname":"XDG/EUR","status":"online"}}}`))
+	}))
+	defer srv.Close()
+
+	oldBaseURL, oldClient, oldPairs, oldQuote := baseURL, httpClient, pairs, quote
+	defer func() {
+		baseURL, httpClient, pairs, quote = oldBaseURL, oldClient, oldPairs, oldQuote
+	}()
+	pairs = make(map[string]string)
+
+	Configure(models.KrakenCfg{BaseURL: srv.URL, Quote: "USD"})
+	require.NoError(t, RefreshPairs(context.Background()))
+	assert.Equal(t, map[string]string{
+		"BTC":      "XXBTZUSD",
+		"BTC/EUR":  "XXBTZEUR",
+		"DOGE/EUR": "XDGEUR",
+	}, pairs)
+	assert.Equal(t, []string{"BTC"}, Coins())
+
+	decimals, ok := PairDecimals("BTC/EUR")
+	assert.True(t, ok)
+	assert.Equal(t, 2, decimals)
+
+	assert.Equal(t, "BTC", Symbol("BTC", ""))
+	assert.Equal(t, "BTC", Symbol("BTC", "usd"))
+	assert.Equal(t, "BTC/EUR", Symbol("BTC", "eur"))
+
+	coin, q := SplitSymbol("DOGE/EUR")
+	assert.Equal(t, "DOGE", coin)
+	assert.Equal(t, "EUR", q)
+	coin, q = SplitSymbol("BTC")
+	assert.Equal(t, "BTC", coin)
+	assert.Equal(t, "USD", q)
+}
+
+func TestSourceSupportedCoins(t *testing.T) {
+	oldPairs := pairs
+	defer func() {
+		pairs = oldPairs
+		initPairsOnce = sync.Once{}
+	}()
+	pairs = map[string]string{"ETH": "XETHZUSD", "BTC/EUR": "XXBTZEUR", "BTC": "XXBTZUSD"}
+	initPairsOnce.Do(func() {})
+
+	assert.Equal(t, []string{"BTC", "BTC/EUR", "ETH"}, Source{}.SupportedCoins())
+}
+
+func TestParseLastTrade(t *testing.T) {
+	body := []byte(`{"error":[],"result":{"XXBTZUSD":[
+		["30243.40000","0.34507674",1688669597.8277369,"b","m","",61044952],
+		["30245.10000","0.00100000",1688669599.5,"s","l","",61044953]],
+		"last":"1688669599500000000"}}`)
+
+	price, at, err := parseLastTrade(body, "XXBTZUSD")
+	require.NoError(t, err)
+	assert.Equal(t, 30245.1, price)
+	assert.Equal(t, time.UnixMilli(1688669599500), at)
+
+	_, _, err = parseLastTrade([]byte(`{"error":[],"result":{"XXBTZUSD":[],"last":"0"}}`), "XXBTZUSD")
+	assert.Error(t, err)
+}
+
+func TestDeadLetter(t *testing.T) {
+	body := `{"error":[],"result":{"XXBTZUSD":{"last":"30243.4"}}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	oldBaseURL, oldPairs, oldLog := baseURL, pairs, deadLetterLog
+	defer func() {
+		baseURL, pairs, deadLetterLog = oldBaseURL, oldPairs, oldLog
+		initPairsOnce = sync.Once{}
+		deadLetterEnabled, deadLetterLast, deadLetterSuppressed = false, time.Time{}, 0
+	}()
+	var logged strings.Builder
+	deadLetterLog = log.New(&logged, "", 0)
+	baseURL = srv.URL
+	pairs = map[string]string{"BTC": "XXBTZUSD"}
+	initPairsOnce.Do(func() {})
+
+	// Disabled by default
+	_, err := GetPrice(context.Background(), "BTC", "")
+	require.Error(t, err)
+	assert.Empty(t, logged.String())
+
+	deadLetterEnabled = true
+	_, err = GetPrice(context.Background(), "BTC", "")
+	require.Error(t, err)
+	assert.Contains(t, logged.String(), "dead letter from kraken.GetTicker: no price data in response")
+	assert.Contains(t, logged.String(), body)
+
+	// Rate limited: the next failure within the interval is only counted
+	logged.Reset()
+	_, err = GetPrice(context.Background(), "BTC", "")
+	require.Error(t, err)
+	assert.Empty(t, logged.String())
+	assert.Equal(t, 1, deadLetterSuppressed)
+
+	// Kraken's own errors are not dead letters
+	deadLetterLast = time.Time{}
+	body = `{"error":["EGeneral:Too many requests"],"result":{}}`
+	_, err = GetPrice(context.Background(), "BTC", "")
+	require.Error(t, err)
+	assert.Empty(t, logged.String())
+}
+
+func TestDeadLetterTruncates(t *testing.T) {
+	oldLog := deadLetterLog
+	defer func() {
+		deadLetterLog = oldLog
+		deadLetterEnabled, deadLetterLast, deadLetterSuppressed = false, time.Time{}, 0
+	}()
+	var logged strings.Builder
+	deadLetterLog = log.New(&logged, "", 0)
+	deadLetterEnabled, deadLetterLast = true, time.Time{}
+
+	deadLetter("kraken.GetOHLC", []byte(strings.Repeat("x", 2*deadLetterMaxBody)), errors.New("json parse error"))
+	assert.Contains(t, logged.String(), "body (truncated): "+strings.Repeat("x", deadLetterMaxBody)+"\n")
+}
+
+func TestRetry(t *testing.T) {
+	var (
+		hits   int
+		status []int
+		body   string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := http.StatusOK
+		if hits < len(status) {
+			code = status[hits]
+		}
+		hits++
+		w.WriteHeader(code)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	oldBaseURL, oldPairs, oldRetry := baseURL, pairs, Retry
+	defer func() {
+		baseURL, pairs, Retry = oldBaseURL, oldPairs, oldRetry
+	}()
+	baseURL = srv.URL
+	pairs = map[string]string{"BTC": "XXBTZUSD"}
+	initPairsOnce.Do(func() {})
+	Retry = RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	tests := []struct {
+		name     string
+		status   []int
+		body     string
+		wantHits int
+		wantErr  bool
+	}{
+		{"recovers after 5xx", []int{502, 503}, `{"error":[],"result":{"XXBTZUSD":{"c":["123.45","1"]}}}`, 3, false},
+		{"gives up after max attempts", []int{500, 500, 500}, `{}`, 3, true},
+		{"no retry on 4xx", []int{404}, `{}`, 1, true},
+		{"no retry on API error", nil, `{"error":["EGeneral:Invalid arguments"]}`, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, status, body = 0, tt.status, tt.body
+
+			price, err := GetPrice(context.Background(), "BTC", "")
+			assert.Equal(t, tt.wantHits, hits)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 123.45, price)
+		})
+	}
+}
+
+func TestGetPriceCancellation(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A hung Kraken connection
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	oldBaseURL, oldClient, oldPairs, oldRetry := baseURL, httpClient, pairs, Retry
+	defer func() {
+		baseURL, httpClient, pairs, Retry = oldBaseURL, oldClient, oldPairs, oldRetry
+	}()
+	pairs = map[string]string{"BTC": "XXBTZUSD"}
+	initPairsOnce.Do(func() {})
+	Retry = RetryConfig{MaxAttempts: 3, BaseDelay: time.Hour}
+
+	t.Run("request timeout", func(t *testing.T) {
+		Configure(models.KrakenCfg{BaseURL: srv.URL, RequestTimeout: 50 * time.Millisecond})
+		Retry.MaxAttempts = 1
+
+		start := time.Now()
+		_, err := GetPrice(context.Background(), "BTC", "")
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		Configure(models.KrakenCfg{BaseURL: srv.URL, RequestTimeout: time.Minute})
+		Retry.MaxAttempts = 3
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := GetPrice(ctx, "BTC", "")
+		assert.Error(t, err)
+		// Neither the hung request nor the hour-long backoff is waited for
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+// Test requests wait for the shared rate limiter instead of failing, and
+// stop waiting once cancelled
+func TestRateLimit(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte(`{"error":[],"result":{"XXBTZUSD":{"c":["123.45","1"]}}}`))
+	}))
+	defer srv.Close()
+
+	oldBaseURL, oldPairs, oldLimiter := baseURL, pairs, limiter
+	defer func() {
+		baseURL, pairs, limiter = oldBaseURL, oldPairs, oldLimiter
+	}()
+	baseURL = srv.URL
+	pairs = map[string]string{"BTC": "XXBTZUSD"}
+	initPairsOnce.Do(func() {})
+	limiter = newLimiter(20, 1)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := GetPrice(context.Background(), "BTC", "")
+		require.NoError(t, err)
+	}
+	// The burst allows one request right away, the others wait 50ms each
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	assert.Equal(t, int32(3), hits.Load())
+
+	limiter = newLimiter(0.1, 1)
+	_, err := GetPrice(context.Background(), "BTC", "")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = GetPrice(ctx, "BTC", "")
+	assert.Error(t, err)
+	assert.Equal(t, int32(4), hits.Load())
+}
+
+// Test periodic refreshes pick up newly listed pairs and keep the loaded ones on failure
+func TestRefreshPairsEvery(t *testing.T) {
+	var listing atomic.Value
+	listing.Store(`{"error":[],"result":{"XXBTZUSD":{"wsname":"XBT/USD","status":"online"}}}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := listing.Load().(string)
+		if body == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	oldBaseURL, oldClient, oldPairs, oldRetry := baseURL, httpClient, pairs, Retry
+	defer func() {
+		baseURL, httpClient, pairs, Retry = oldBaseURL, oldClient, oldPairs, oldRetry
+	}()
+	Configure(models.KrakenCfg{BaseURL: srv.URL, Quote: "USD", RetryAttempts: 1})
+	initPairsOnce.Do(func() {})
+
+	require.NoError(t, RefreshPairs(context.Background()))
+	_, ok := PairID("ETH")
+	assert.False(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RefreshPairsEvery(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+
+	listing.Store(`{"error":[],"result":{
+		"XXBTZUSD":{"wsname":"XBT/USD","status":"online"},
+		"XETHZUSD":{"wsname":"ETH/USD","status":"online"}}}`)
+	require.Eventually(t, func() bool {
+		_, ok := PairID("ETH")
+		return ok
+	}, 2*time.Second, 5*time.Millisecond)
+
+	listing.Store("")
+	time.Sleep(30 * time.Millisecond)
+	pairID, ok := PairID("ETH")
+	assert.True(t, ok)
+	assert.Equal(t, "XETHZUSD", pairID)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("refresh loop did not stop")
+	}
+}
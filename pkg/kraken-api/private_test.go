@@ -0,0 +1,91 @@
+package kraken_api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Example from the Kraken REST API authentication guide
+const (
+	testSecret = "kQH5HW/8p1uGOVjbgWA7FunAmGO8lsSUXNsu3eow76sz84Q18fWxnyRzBHCd3pd5nE9qa99HAZtuZuj6F1huXg=="
+	testSign   = "4/dpxb3iT4tp/ZCVEwSnEsLxx0bqyhLpdfOpc6fn7OR8+UClSV5n9E6aSS8MPtnRfp32bAb0nmbRn6H8ndwLUQ=="
+)
+
+func TestSign(t *testing.T) {
+	client, err := NewPrivateClient("key", testSecret)
+	require.NoError(t, err)
+
+	postData := "nonce=1616492376594&ordertype=limit&pair=XBTUSD&price=37500&type=buy&volume=1.25"
+	assert.Equal(t, testSign, sign(client.secret, "/0/private/AddOrder", "1616492376594", postData))
+
+	_, err = NewPrivateClient("key", "not base64!")
+	assert.Error(t, err)
+}
+
+func TestNonceIncreases(t *testing.T) {
+	client, err := NewPrivateClient("key", testSecret)
+	require.NoError(t, err)
+
+	last := client.nonce()
+	for i := 0; i < 100; i++ {
+		n := client.nonce()
+		assert.Greater(t, n, last)
+		last = n
+	}
+}
+
+func TestBalance(t *testing.T) {
+	client, err := NewPrivateClient("my-key", testSecret)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+
+		assert.Equal(t, "/0/private/Balance", r.URL.Path)
+		assert.Equal(t, "my-key", r.Header.Get("API-Key"))
+		assert.Equal(t, sign(client.secret, r.URL.Path, form.Get("nonce"), string(body)), r.Header.Get("API-Sign"))
+
+		w.Write([]byte(`{"error":[],"result":{"ZUSD":"171288.6158","XXBT":"0.0011","DOT":"10.5"}}`))
+	}))
+	defer srv.Close()
+
+	oldBaseURL := baseURL
+	baseURL = srv.URL
+	defer func() { baseURL = oldBaseURL }()
+
+	balances, err := client.Balance(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"USD": 171288.6158, "BTC": 0.0011, "DOT": 10.5}, balances)
+}
+
+func TestBalanceStatus(t *testing.T) {
+	client, err := NewPrivateClient("my-key", testSecret)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`<html>bad gateway</html>`))
+	}))
+	defer srv.Close()
+
+	oldBaseURL := baseURL
+	baseURL = srv.URL
+	defer func() { baseURL = oldBaseURL }()
+
+	_, err = client.Balance(context.Background())
+	assert.ErrorContains(t, err, "unexpected status 502")
+
+	// A cancelled request is not sent
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Balance(ctx)
+	assert.ErrorContains(t, err, context.Canceled.Error())
+}
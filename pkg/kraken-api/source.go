@@ -0,0 +1,40 @@
+package kraken_api
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Source prices symbols (see Symbol) with the Kraken public API, so
+// "BTC/EUR" is priced in euros. It is the collectors' default price source.
+type Source struct{}
+
+// GetPrice returns the last trade price of the symbol.
+func (Source) GetPrice(ctx context.Context, symbol string) (float64, error) {
+	base, q := SplitSymbol(symbol)
+	return GetPrice(ctx, base, q)
+}
+
+// GetPriceAt returns the price of the symbol's last trade and its time.
+func (Source) GetPriceAt(ctx context.Context, symbol string) (float64, time.Time, error) {
+	base, q := SplitSymbol(symbol)
+	return GetLastTrade(ctx, base, q)
+}
+
+// SupportedCoins returns the symbols of all online pairs in alphabetical
+// order: bare coins in the configured quote currency and "COIN/QUOTE" in
+// the others.
+func (Source) SupportedCoins() []string {
+	loadPairs()
+
+	pairsMu.RLock()
+	symbols := make([]string, 0, len(pairs))
+	for symbol := range pairs {
+		symbols = append(symbols, symbol)
+	}
+	pairsMu.RUnlock()
+
+	sort.Strings(symbols)
+	return symbols
+}